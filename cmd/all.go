@@ -18,18 +18,20 @@ package cmd
 
 import (
 	"fmt"
-	"log"
 
-	"github.com/docker/attest/mirror"
+	attestmirror "github.com/docker/attest/mirror"
+	"github.com/docker/go-tuf-mirror/pkg/mirror"
 	"github.com/spf13/cobra"
 )
 
 type allOptions struct {
-	srcMeta     string
-	dstMeta     string
-	srcTargets  string
-	dstTargets  string
-	rootOptions *rootOptions
+	srcMeta       string
+	dstMeta       []string
+	srcTargets    string
+	dstTargets    []string
+	allowRollback bool
+	referrers     bool
+	rootOptions   *rootOptions
 }
 
 func defaultAllOptions(opts *rootOptions) *allOptions {
@@ -38,7 +40,7 @@ func defaultAllOptions(opts *rootOptions) *allOptions {
 	}
 }
 
-func newAllCmd(opts *rootOptions) *cobra.Command {
+func newAllCmd(opts *rootOptions) (*cobra.Command, error) {
 	o := defaultAllOptions(opts)
 
 	cmd := &cobra.Command{
@@ -47,51 +49,46 @@ func newAllCmd(opts *rootOptions) *cobra.Command {
 		SilenceUsage: false,
 		RunE:         o.run,
 	}
-	cmd.Flags().StringVar(&o.srcMeta, "source-metadata", mirror.DefaultMetadataURL, fmt.Sprintf("Source metadata location %s<web>, %s<OCI layout>, %s<filesystem> or %s<remote registry>", WebPrefix, OCIPrefix, LocalPrefix, RegistryPrefix))
-	cmd.Flags().StringVar(&o.dstMeta, "dest-metadata", "", fmt.Sprintf("Destination metadata location %s<OCI layout>, %s<filesystem> or %s<remote registry>", OCIPrefix, LocalPrefix, RegistryPrefix))
-	cmd.Flags().StringVar(&o.srcTargets, "source-targets", mirror.DefaultTargetsURL, fmt.Sprintf("Source targets location %s<web>, %s<OCI layout>, %s<filesystem> or %s<remote registry>", WebPrefix, OCIPrefix, LocalPrefix, RegistryPrefix))
-	cmd.Flags().StringVar(&o.dstTargets, "dest-targets", "", fmt.Sprintf("Destination targets location %s<OCI layout>, %s<filesystem> or %s<remote registry>", OCIPrefix, LocalPrefix, RegistryPrefix))
+	cmd.Flags().StringVar(&o.srcMeta, "source-metadata", attestmirror.DefaultMetadataURL, fmt.Sprintf("Source metadata location %s", schemes(mirror.SourceSchemes())))
+	cmd.Flags().StringArrayVar(&o.dstMeta, "dest-metadata", nil, fmt.Sprintf("Destination metadata location %s, may be repeated", schemes(mirror.DestinationSchemes())))
+	cmd.Flags().StringVar(&o.srcTargets, "source-targets", attestmirror.DefaultTargetsURL, fmt.Sprintf("Source targets location %s", schemes(mirror.SourceSchemes())))
+	cmd.Flags().StringArrayVar(&o.dstTargets, "dest-targets", nil, fmt.Sprintf("Destination targets location %s, may be repeated", schemes(mirror.DestinationSchemes())))
 
-	err := cmd.MarkFlagRequired("source-metadata")
-	if err != nil {
-		log.Fatalf("failed to mark flag required: %s", err)
-	}
-	err = cmd.MarkFlagRequired("dest-metadata")
+	cmd.Flags().BoolVar(&o.allowRollback, "allow-rollback", false, "Publish even if the destination holds newer metadata")
+	cmd.Flags().BoolVar(&o.referrers, "referrers", false, "Attach the signing targets metadata to each target image as an OCI referrer")
+	o.rootOptions.addMirrorFlags(cmd.Flags())
+
+	err := markFlagsRequired(cmd.Flags(), "source-metadata", "dest-metadata", "source-targets", "dest-targets")
 	if err != nil {
-		log.Fatalf("failed to mark flag required: %s", err)
+		return nil, err
 	}
-	err = cmd.MarkFlagRequired("source-targets")
+	return cmd, nil
+}
+
+func (o *allOptions) run(cmd *cobra.Command, args []string) error {
+	source, err := mirror.NewSource(o.srcMeta, o.srcTargets)
 	if err != nil {
-		log.Fatalf("failed to mark flag required: %s", err)
+		return err
 	}
-	err = cmd.MarkFlagRequired("dest-targets")
+	metadataDestinations, err := o.rootOptions.destinations(o.dstMeta)
 	if err != nil {
-		log.Fatalf("failed to mark flag required: %s", err)
+		return err
 	}
-	return cmd
-}
-
-func (o *allOptions) run(cmd *cobra.Command, args []string) error {
-	metadata := newMetadataCmd(o.rootOptions)
-	metadata.SetOut(cmd.OutOrStdout())
-	targets := newTargetsCmd(o.rootOptions)
-	targets.SetOut(cmd.OutOrStdout())
-
-	_ = metadata.PersistentFlags().Set("source", o.srcMeta)
-	_ = metadata.PersistentFlags().Set("destination", o.dstMeta)
-	_ = metadata.PersistentFlags().Set("targets", o.srcTargets)
-
-	_ = targets.PersistentFlags().Set("source", o.srcTargets)
-	_ = targets.PersistentFlags().Set("destination", o.dstTargets)
-	_ = targets.PersistentFlags().Set("metadata", o.srcMeta)
-
-	err := metadata.ExecuteContext(cmd.Context())
+	targetsDestinations, err := o.rootOptions.destinations(o.dstTargets)
 	if err != nil {
-		return fmt.Errorf("error mirroring metadata: %w", err)
+		return err
 	}
-	err = targets.ExecuteContext(cmd.Context())
+	cfg, err := o.rootOptions.config(cmd)
 	if err != nil {
-		return fmt.Errorf("error mirroring targets: %w", err)
+		return err
 	}
-	return nil
+	// metadata and targets are mirrored in one run, holding all destination locks throughout so overlapping
+	// runs can't interleave
+	cfg.Source = source
+	cfg.MetadataDestinations = metadataDestinations
+	cfg.TargetsDestinations = targetsDestinations
+	cfg.AllowRollback = o.allowRollback
+	cfg.Referrers = o.referrers
+	_, err = o.rootOptions.mirror(cmd, *cfg)
+	return err
 }
@@ -17,26 +17,22 @@
 package cmd
 
 import (
-	"bufio"
 	"bytes"
-	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/docker/go-tuf-mirror/internal/lock"
 	"github.com/google/go-containerregistry/pkg/registry"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-const (
-	DelegatedTargetsLength = 1
-)
-
 func TestAll(t *testing.T) {
 	tempDir := filepath.Join(os.TempDir(), "test")
 	tempPath := OCIPrefix + tempDir
@@ -73,10 +69,11 @@ func TestAll(t *testing.T) {
 			opts.tufPath = tempDir
 			opts.full = tc.full
 			opts.tufRoot = "dev"
-			cmd := newAllCmd(opts)
+			cmd, err := newAllCmd(opts)
+			require.NoError(t, err)
 
-			expectedMetadataOutput := fmt.Sprintf("Mirroring TUF metadata %s to %s\n", tc.srcMeta, tc.dstMeta)
-			expectedTargetsOutput := fmt.Sprintf("Mirroring TUF targets %s to %s\n", tc.srcTgt, tc.dstTgt)
+			expectedMetadataLog := fmt.Sprintf("msg=\"Mirroring TUF metadata\" source=%s destination=%s\n", tc.srcMeta, tc.dstMeta)
+			expectedTargetsLog := fmt.Sprintf("msg=\"Mirroring TUF targets\" source=%s destination=%s\n", tc.srcTgt, tc.dstTgt)
 
 			b := bytes.NewBufferString("")
 			cmd.SetOut(b)
@@ -85,28 +82,209 @@ func TestAll(t *testing.T) {
 			_ = cmd.Flags().Set("dest-metadata", tc.dstMeta)
 			_ = cmd.Flags().Set("dest-targets", tc.dstTgt)
 
-			err := cmd.ExecuteContext(context.Background())
+			ctx, logs := testLogContext(t)
+			err = cmd.ExecuteContext(ctx)
 			require.NoError(t, err)
 
 			err = os.RemoveAll("./tmp")
 			require.NoError(t, err)
 
-			reader := bufio.NewReader(b)
-			metaOut, err := reader.ReadString('\n')
-			require.NoError(t, err)
-			assert.Equal(t, expectedMetadataOutput, metaOut)
+			// metadata is mirrored before targets
+			metaLog := strings.Index(logs.String(), expectedMetadataLog)
+			targetsLog := strings.Index(logs.String(), expectedTargetsLog)
+			require.GreaterOrEqual(t, metaLog, 0)
+			require.Greater(t, targetsLog, metaLog)
+			assert.Contains(t, b.String(), "Metadata manifest ")
+			assert.Contains(t, b.String(), "Target manifest ")
+		})
+	}
+}
 
-			_, err = reader.ReadString('\n')
-			require.NoError(t, err)
-			if tc.full {
-				for i := 0; i < DelegatedTargetsLength; i++ {
-					_, err = reader.ReadString('\n')
-					require.NoError(t, err)
-				}
-			}
-			targetsOut, err := reader.ReadString('\n')
+func TestAllMultipleDestinations(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	reg := httptest.NewServer(registry.New(registry.WithReferrersSupport(false)))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	registryHost := "localhost:" + url.Port()
+
+	opts := defaultRootOptions()
+	opts.tufPath = filepath.Join(tempDir, "tuf")
+	opts.tufRoot = "dev"
+	cmd, err := newAllCmd(opts)
+	require.NoError(t, err)
+	b := bytes.NewBufferString("")
+	cmd.SetOut(b)
+	cmd.SetArgs([]string{
+		"--source-metadata", server.URL + "/metadata",
+		"--source-targets", server.URL + "/targets",
+		"--dest-metadata", OCIPrefix + filepath.Join(tempDir, "metadata"),
+		"--dest-metadata", RegistryPrefix + registryHost + "/test/metadata:latest",
+		"--dest-targets", OCIPrefix + filepath.Join(tempDir, "targets"),
+		"--dest-targets", RegistryPrefix + registryHost + "/test/targets",
+	})
+	ctx, _ := testLogContext(t)
+	require.NoError(t, cmd.ExecuteContext(ctx))
+
+	out := b.String()
+	assert.Contains(t, out, "Metadata manifest layout saved to "+filepath.Join(tempDir, "metadata"))
+	assert.Contains(t, out, "Metadata manifest pushed to "+registryHost+"/test/metadata:latest")
+	assert.Equal(t, TopLevelTargetsLength, strings.Count(out, "Target manifest layout saved to "+filepath.Join(tempDir, "targets")))
+	assert.Equal(t, TopLevelTargetsLength, strings.Count(out, "Target manifest pushed to "+registryHost+"/test/targets"))
+}
+
+func TestAllMirroredSources(t *testing.T) {
+	tempDir := t.TempDir()
+	testRepo := filepath.Join("..", "internal", "test", "testdata", "test-repo")
+
+	server := httptest.NewServer(http.FileServer(http.Dir(testRepo)))
+	defer server.Close()
+
+	reg := httptest.NewServer(registry.New(registry.WithReferrersSupport(false)))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	registryHost := "localhost:" + url.Port()
+
+	all := func(cache string, args ...string) (string, error) {
+		opts := defaultRootOptions()
+		opts.tufPath = filepath.Join(tempDir, cache)
+		opts.tufRoot = "dev"
+		opts.full = true
+		cmd, err := newAllCmd(opts)
+		require.NoError(t, err)
+		b := bytes.NewBufferString("")
+		cmd.SetOut(b)
+		cmd.SetArgs(args)
+		ctx, _ := testLogContext(t)
+		err = cmd.ExecuteContext(ctx)
+		return b.String(), err
+	}
+
+	// mirror the test repository, then mirror the mirrors
+	_, err = all("tuf",
+		"--source-metadata", server.URL+"/metadata",
+		"--source-targets", server.URL+"/targets",
+		"--dest-metadata", OCIPrefix+filepath.Join(tempDir, "metadata"),
+		"--dest-metadata", RegistryPrefix+registryHost+"/test/metadata:latest",
+		"--dest-targets", OCIPrefix+filepath.Join(tempDir, "targets"),
+		"--dest-targets", RegistryPrefix+registryHost+"/test/targets",
+	)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		srcMeta string
+		srcTgt  string
+	}{
+		{"file", LocalPrefix + filepath.Join(testRepo, "metadata"), LocalPrefix + filepath.Join(testRepo, "targets")},
+		{"oci", OCIPrefix + filepath.Join(tempDir, "metadata"), OCIPrefix + filepath.Join(tempDir, "targets")},
+		{"docker", RegistryPrefix + registryHost + "/test/metadata:latest", RegistryPrefix + registryHost + "/test/targets"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := filepath.Join(tempDir, "from-"+tc.name)
+			out, err := all(tc.name,
+				"--source-metadata", tc.srcMeta,
+				"--source-targets", tc.srcTgt,
+				"--dest-metadata", OCIPrefix+filepath.Join(dst, "metadata"),
+				"--dest-targets", OCIPrefix+filepath.Join(dst, "targets"),
+			)
 			require.NoError(t, err)
-			assert.Equal(t, expectedTargetsOutput, targetsOut)
+			assert.Contains(t, out, "Metadata manifest layout saved to "+filepath.Join(dst, "metadata"))
+			assert.Equal(t, TopLevelTargetsLength, strings.Count(out, "Target manifest layout saved to "+filepath.Join(dst, "targets")))
+			assert.Contains(t, out, "Delegated target index manifest layout saved to "+filepath.Join(dst, "targets", "test-role"))
+		})
+	}
+
+	// a missing target is reported as missing from the source
+	_, err = all("missing",
+		"--source-metadata", LocalPrefix+filepath.Join(testRepo, "metadata"),
+		"--source-targets", LocalPrefix+tempDir,
+		"--dest-metadata", OCIPrefix+filepath.Join(tempDir, "missing", "metadata"),
+		"--dest-targets", OCIPrefix+filepath.Join(tempDir, "missing", "targets"),
+	)
+	require.Error(t, err)
+}
+
+func TestAllFileDestination(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	all := func(cacheControl ...string) (string, error) {
+		opts := defaultRootOptions()
+		opts.tufPath = filepath.Join(tempDir, "tuf")
+		opts.tufRoot = "dev"
+		opts.cacheControl = cacheControl
+		cmd, err := newAllCmd(opts)
+		require.NoError(t, err)
+		b := bytes.NewBufferString("")
+		cmd.SetOut(b)
+		cmd.SetArgs([]string{
+			"--source-metadata", server.URL + "/metadata",
+			"--source-targets", server.URL + "/targets",
+			"--dest-metadata", LocalPrefix + filepath.Join(tempDir, "metadata"),
+			"--dest-targets", LocalPrefix + filepath.Join(tempDir, "targets"),
 		})
+		ctx, _ := testLogContext(t)
+		err = cmd.ExecuteContext(ctx)
+		return b.String(), err
 	}
+
+	out, err := all("timestamp=max-age=60")
+	require.NoError(t, err)
+	// targets are written before the metadata referring to them
+	assert.Less(t, strings.Index(out, "Target manifest saved to "), strings.Index(out, "Metadata manifest saved to "+filepath.Join(tempDir, "metadata")))
+	assert.FileExists(t, filepath.Join(tempDir, "metadata", "timestamp.json"))
+
+	_, err = all("timestamp")
+	assert.Equal(t, ExitInvalidArgument, ExitCode(err))
+}
+
+func TestAllMetadataDestinationFails(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	// a registry rejecting every manifest push but the lock
+	handler := registry.New(registry.WithReferrersSupport(false))
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") && !strings.HasSuffix(r.URL.Path, lock.Tag) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	failing := RegistryPrefix + "localhost:" + url.Port() + "/test/metadata:latest"
+
+	opts := defaultRootOptions()
+	opts.tufPath = filepath.Join(tempDir, "tuf")
+	opts.tufRoot = "dev"
+	cmd, err := newAllCmd(opts)
+	require.NoError(t, err)
+	b := bytes.NewBufferString("")
+	cmd.SetOut(b)
+	cmd.SetArgs([]string{
+		"--source-metadata", server.URL + "/metadata",
+		"--source-targets", server.URL + "/targets",
+		"--dest-metadata", failing,
+		"--dest-targets", OCIPrefix + filepath.Join(tempDir, "targets"),
+	})
+	ctx, _ := testLogContext(t)
+	err = cmd.ExecuteContext(ctx)
+	require.ErrorIs(t, err, ErrDestinationAuth)
+	assert.Equal(t, ExitPartialPush, ExitCode(err))
+
+	// the failing metadata destination doesn't stop the targets
+	assert.Equal(t, TopLevelTargetsLength, strings.Count(b.String(), "Target manifest layout saved to"))
 }
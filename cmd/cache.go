@@ -0,0 +1,239 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/attest/tuf"
+	"github.com/docker/go-tuf-mirror/internal/cache"
+	"github.com/docker/go-tuf-mirror/internal/failure"
+	"github.com/spf13/cobra"
+)
+
+// embeddedRoots are the names of the roots embedded for --tuf-root, to label cached repositories with.
+var embeddedRoots = []string{"dev", "staging", "prod"}
+
+type cacheOptions struct {
+	output      string
+	all         bool
+	rootOptions *rootOptions
+}
+
+type cacheRepository struct {
+	cache.Repository
+	// TUFRoot is the embedded root the repository was cached for, if any.
+	TUFRoot string `json:"tufRoot,omitempty"`
+}
+
+type cacheInfoOutput struct {
+	Path         string            `json:"path"`
+	Repositories []cacheRepository `json:"repositories"`
+}
+
+func defaultCacheOptions(opts *rootOptions) *cacheOptions {
+	return &cacheOptions{
+		output:      tableFormat,
+		rootOptions: opts,
+	}
+}
+
+func newCacheCmd(opts *rootOptions) *cobra.Command {
+	o := defaultCacheOptions(opts)
+
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect and maintain the local TUF cache (--tuf-path)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+
+	infoCmd := &cobra.Command{
+		Use:          "info",
+		Short:        "List the cached repositories, with the version and expiry of each cached role",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE:         o.info,
+	}
+	infoCmd.Flags().StringVarP(&o.output, "output", "o", tableFormat, fmt.Sprintf("Output format [%s, %s]", tableFormat, jsonFormat))
+
+	cleanCmd := &cobra.Command{
+		Use:          "clean [repository|source...]",
+		Short:        "Remove cached repositories, by name as listed by cache info or by source metadata location, or the whole cache",
+		SilenceUsage: true,
+		RunE:         o.clean,
+	}
+	cleanCmd.Flags().BoolVar(&o.all, "all", false, "Remove every cached repository, along with freshness and root records and journals")
+
+	verifyCmd := &cobra.Command{
+		Use:          "verify",
+		Short:        "Verify the repositories cached for the trusted root (--tuf-root or --root-digest) against it",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE:         o.verify,
+	}
+
+	cmd.AddCommand(infoCmd)
+	cmd.AddCommand(cleanCmd)
+	cmd.AddCommand(verifyCmd)
+	return cmd
+}
+
+func (o *cacheOptions) info(cmd *cobra.Command, args []string) error {
+	if o.output != tableFormat && o.output != jsonFormat {
+		return invalidArgumentf("unsupported output format: %s", o.output)
+	}
+	path, err := o.rootOptions.cachePath()
+	if err != nil {
+		return err
+	}
+	repos, err := cache.List(path)
+	if err != nil {
+		return err
+	}
+	names := map[string]string{}
+	for _, name := range embeddedRoots {
+		root, err := tuf.GetEmbeddedRoot(name)
+		if err == nil {
+			names[cache.Fingerprint(root.Data)] = name
+		}
+	}
+	out := &cacheInfoOutput{Path: path, Repositories: []cacheRepository{}}
+	for _, r := range repos {
+		out.Repositories = append(out.Repositories, cacheRepository{Repository: r, TUFRoot: names[r.Root]})
+	}
+
+	if o.output == jsonFormat {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	return writeCacheTables(cmd.OutOrStdout(), out)
+}
+
+func writeCacheTables(w io.Writer, out *cacheInfoOutput) error {
+	if len(out.Repositories) == 0 {
+		fmt.Fprintf(w, "No repositories cached in %s\n", out.Path)
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tSOURCE\tTUF ROOT\tSIZE\tPATH")
+	for _, r := range out.Repositories {
+		source, root := r.Source, r.TUFRoot
+		if source == "" {
+			source = "-"
+		}
+		if root == "" {
+			root = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", r.Name, source, root, r.Size, r.Path)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "REPOSITORY\tROLE\tVERSION\tEXPIRES")
+	for _, r := range out.Repositories {
+		for _, role := range r.Roles {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", r.Name, role.Role, role.Version, role.Expires.UTC().Format(time.RFC3339))
+		}
+	}
+	return tw.Flush()
+}
+
+func (o *cacheOptions) clean(cmd *cobra.Command, args []string) error {
+	if o.all == (len(args) > 0) {
+		return invalidArgumentf("specify either the repositories to clean or --all")
+	}
+	path, err := o.rootOptions.cachePath()
+	if err != nil {
+		return err
+	}
+	if o.all {
+		err = cache.Clean(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Cleaned %s\n", path)
+		return nil
+	}
+	for _, name := range args {
+		removed, err := cache.Remove(path, name)
+		for _, r := range removed {
+			fmt.Fprintf(cmd.OutOrStdout(), "Removed %s\n", r.Name)
+		}
+		if errors.Is(err, cache.ErrNotFound) {
+			return invalidArgumentf("%w in %s", err, path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *cacheOptions) verify(cmd *cobra.Command, args []string) error {
+	path, err := o.rootOptions.cachePath()
+	if err != nil {
+		return err
+	}
+	root, err := o.rootOptions.root(cmd.Context())
+	if err != nil {
+		return err
+	}
+	repos, err := cache.List(path)
+	if err != nil {
+		return err
+	}
+	fingerprint := cache.Fingerprint(root)
+	var verified, failed int
+	for _, r := range repos {
+		if r.Root != fingerprint {
+			continue
+		}
+		checks, err := cache.Verify(r.Path, root)
+		if err != nil {
+			return err
+		}
+		for _, c := range checks {
+			name := r.Name + ": " + c.File
+			if c.Err != nil {
+				failed++
+				fmt.Fprintf(cmd.OutOrStdout(), "FAILED %s: %v\n", name, c.Err)
+				continue
+			}
+			verified++
+			if c.Version == 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "Verified %s\n", name)
+				continue
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Verified %s (version %d)\n", name, c.Version)
+		}
+	}
+	if verified+failed == 0 {
+		return invalidArgumentf("nothing cached in %s for the trusted root", path)
+	}
+	if failed > 0 {
+		return failure.Classify(failure.Verification, fmt.Errorf("%d of %d cached files failed verification", failed, verified+failed))
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Verified %d cached files\n", verified)
+	return nil
+}
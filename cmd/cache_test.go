@@ -0,0 +1,162 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/attest/tuf"
+	"github.com/docker/go-tuf-mirror/internal/cache"
+	"github.com/docker/go-tuf-mirror/internal/freshness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheCmd(t *testing.T) {
+	tempDir := t.TempDir()
+	tufPath := filepath.Join(tempDir, "tuf")
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	// the same repository served from two locations is cached twice
+	other := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer other.Close()
+	sources := []string{server.URL + "/metadata", other.URL + "/metadata"}
+	for i, source := range sources {
+		opts := defaultRootOptions()
+		opts.tufPath = tufPath
+		opts.tufRoot = "dev"
+		metadataCmd, err := newMetadataCmd(opts)
+		require.NoError(t, err)
+		metadataCmd.SetOut(io.Discard)
+		metadataCmd.SetArgs([]string{"--source", source, "--destination", OCIPrefix + filepath.Join(tempDir, "metadata", strconv.Itoa(i))})
+		ctx, _ := testLogContext(t)
+		require.NoError(t, metadataCmd.ExecuteContext(ctx))
+	}
+
+	run := func(args ...string) (string, error) {
+		opts := defaultRootOptions()
+		opts.tufPath = tufPath
+		opts.tufRoot = "dev"
+		cmd := newCacheCmd(opts)
+		b := bytes.NewBufferString("")
+		cmd.SetOut(b)
+		cmd.SetErr(io.Discard)
+		cmd.SetArgs(args)
+		ctx, _ := testLogContext(t)
+		err := cmd.ExecuteContext(ctx)
+		return b.String(), err
+	}
+
+	dev, err := tuf.GetEmbeddedRoot("dev")
+	require.NoError(t, err)
+	root := cache.Fingerprint(dev.Data)
+	names := make([]string, len(sources))
+	paths := make([]string, len(sources))
+	for i, source := range sources {
+		fingerprint := cache.Fingerprint([]byte(source))
+		names[i] = fingerprint[:12] + "-" + root[:12]
+		paths[i] = filepath.Join(tufPath, fingerprint, root)
+	}
+
+	out, err := run("info")
+	require.NoError(t, err)
+	for i, source := range sources {
+		assert.Regexp(t, names[i]+` +`+source+` +dev +[0-9]+ +`+paths[i]+"\n", out)
+		assert.Regexp(t, names[i]+` +root +2 +`, out)
+		assert.Regexp(t, names[i]+` +timestamp +7 +`, out)
+		assert.Regexp(t, names[i]+` +targets +8 +`, out)
+	}
+
+	out, err = run("info", "--output", "json")
+	require.NoError(t, err)
+	var info cacheInfoOutput
+	require.NoError(t, json.Unmarshal([]byte(out), &info))
+	require.Len(t, info.Repositories, 2)
+	for _, r := range info.Repositories {
+		assert.Equal(t, "dev", r.TUFRoot)
+		assert.Equal(t, root, r.Root)
+		assert.Contains(t, sources, r.Source)
+		assert.Positive(t, r.Size)
+	}
+
+	out, err = run("verify")
+	require.NoError(t, err)
+	for _, name := range names {
+		assert.Contains(t, out, "Verified "+name+": root.json (version 2)\nVerified "+name+": timestamp.json (version 7)\nVerified "+name+": snapshot.json (version 7)\nVerified "+name+": targets.json (version 8)\n")
+	}
+	assert.Contains(t, out, "Verified 8 cached files\n")
+
+	// a tampered file fails, and the files depending on it aren't checked
+	snapshot := filepath.Join(paths[0], "snapshot.json")
+	data, err := os.ReadFile(snapshot)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(snapshot, []byte(strings.Replace(string(data), `"expires": "2034-`, `"expires": "2035-`, 1)), 0o644))
+	out, err = run("verify")
+	require.Error(t, err)
+	assert.Equal(t, ExitVerification, ExitCode(err), err.Error())
+	assert.Contains(t, out, "FAILED "+names[0]+": snapshot.json: ")
+	assert.NotContains(t, out, names[0]+": targets.json")
+	assert.Contains(t, out, names[1]+": targets.json")
+
+	_, err = run("clean")
+	assert.Equal(t, ExitInvalidArgument, ExitCode(err), err.Error())
+	_, err = run("clean", "unknown")
+	assert.Equal(t, ExitInvalidArgument, ExitCode(err), err.Error())
+	_, err = run("clean", "..")
+	assert.Equal(t, ExitInvalidArgument, ExitCode(err), err.Error())
+
+	// by source, or by name
+	out, err = run("clean", sources[0])
+	require.NoError(t, err)
+	assert.Equal(t, "Removed "+names[0]+"\n", out)
+	assert.NoDirExists(t, filepath.Dir(paths[0]))
+	out, err = run("clean", names[1])
+	require.NoError(t, err)
+	assert.Equal(t, "Removed "+names[1]+"\n", out)
+	assert.NoDirExists(t, filepath.Dir(paths[1]))
+	out, err = run("info")
+	require.NoError(t, err)
+	assert.Equal(t, "No repositories cached in "+tufPath+"\n", out)
+
+	// repositories cached before the cache was namespaced are listed, and cleaned, too
+	legacy := filepath.Join(tufPath, root)
+	require.NoError(t, os.MkdirAll(legacy, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(legacy, "root.json"), dev.Data, 0o644))
+	out, err = run("info")
+	require.NoError(t, err)
+	assert.Regexp(t, root[:12]+` +- +dev +[0-9]+ +`+legacy+"\n", out)
+
+	// only what the cache holds is removed
+	require.NoError(t, os.WriteFile(filepath.Join(tufPath, "keep"), nil, 0o644))
+	require.DirExists(t, filepath.Join(tufPath, freshness.Dir))
+	_, err = run("clean", "--all")
+	require.NoError(t, err)
+	assert.NoDirExists(t, filepath.Join(tufPath, freshness.Dir))
+	assert.NoDirExists(t, legacy)
+	assert.FileExists(t, filepath.Join(tufPath, "keep"))
+}
@@ -0,0 +1,74 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	"github.com/docker/go-tuf-mirror/pkg/mirror"
+	"github.com/spf13/cobra"
+)
+
+type diffOptions struct {
+	rootOptions *rootOptions
+}
+
+func defaultDiffOptions(opts *rootOptions) *diffOptions {
+	return &diffOptions{
+		rootOptions: opts,
+	}
+}
+
+func newDiffCmd(opts *rootOptions) *cobra.Command {
+	o := defaultDiffOptions(opts)
+
+	cmd := &cobra.Command{
+		Use:          "diff <a> <b>",
+		Short:        "Compare TUF metadata between two mirrors or a mirror and its upstream",
+		Long:         fmt.Sprintf("Compare TUF metadata between two locations %s", schemes(mirror.MetadataSchemes())),
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE:         o.run,
+	}
+	return cmd
+}
+
+func (o *diffOptions) run(cmd *cobra.Command, args []string) error {
+	var repos [2]*mirrortuf.Repository
+	for i, location := range args {
+		f, err := mirror.NewFetcher(location)
+		if err != nil {
+			return err
+		}
+		defer closeLocation(f)
+		repos[i], err = mirrortuf.LoadRepository(cmd.Context(), f)
+		if err != nil {
+			return fmt.Errorf("failed to load metadata from %s: %w", location, err)
+		}
+	}
+
+	diffs := mirrortuf.Diff(repos[0], repos[1])
+	if len(diffs) == 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "No differences between %s and %s\n", args[0], args[1])
+		return nil
+	}
+	for _, d := range diffs {
+		fmt.Fprintln(cmd.OutOrStdout(), d)
+	}
+	return fmt.Errorf("found %d differences between %s and %s", len(diffs), args[0], args[1])
+}
@@ -0,0 +1,94 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffCmd(t *testing.T) {
+	tempDir := t.TempDir()
+	layoutPath := OCIPrefix + filepath.Join(tempDir, "metadata")
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+	serverMetadata := server.URL + "/metadata"
+
+	reg := httptest.NewServer(registry.New(registry.WithReferrersSupport(false)))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	registryPath := RegistryPrefix + "localhost:" + url.Port() + "/test/metadata:latest"
+
+	// mirror metadata with delegates to an OCI layout and without delegates to a registry
+	for _, dst := range []struct {
+		destination string
+		full        bool
+	}{{layoutPath, true}, {registryPath, false}} {
+		opts := defaultRootOptions()
+		opts.tufPath = filepath.Join(tempDir, "tuf")
+		opts.tufRoot = "dev"
+		opts.full = dst.full
+		cmd, err := newMetadataCmd(opts)
+		require.NoError(t, err)
+		cmd.SetOut(bytes.NewBufferString(""))
+		_ = cmd.PersistentFlags().Set("source", serverMetadata)
+		_ = cmd.PersistentFlags().Set("destination", dst.destination)
+		require.NoError(t, cmd.Execute())
+	}
+
+	testCases := []struct {
+		name           string
+		a              string
+		b              string
+		expectedOutput string
+		expectErr      bool
+	}{
+		{"web to web", serverMetadata, serverMetadata, fmt.Sprintf("No differences between %s and %s\n", serverMetadata, serverMetadata), false},
+		{"web to oci", serverMetadata, layoutPath, fmt.Sprintf("No differences between %s and %s\n", serverMetadata, layoutPath), false},
+		{"oci to registry without delegates", layoutPath, registryPath, "test-role: metadata: present != <none>\n", true},
+		{"unsupported location", serverMetadata, "s3://bucket/metadata", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := newDiffCmd(defaultRootOptions())
+			b := bytes.NewBufferString("")
+			cmd.SetOut(b)
+			cmd.SetErr(bytes.NewBufferString(""))
+			cmd.SetArgs([]string{tc.a, tc.b})
+
+			err := cmd.Execute()
+			if tc.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedOutput, b.String())
+		})
+	}
+}
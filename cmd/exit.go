@@ -0,0 +1,93 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/go-tuf-mirror/internal/failure"
+	"github.com/docker/go-tuf-mirror/internal/signing"
+	"github.com/docker/go-tuf-mirror/pkg/mirror"
+)
+
+// Exit codes, see the README.
+const (
+	// ExitError is the exit code of a run failing for any other reason.
+	ExitError = 1
+	// ExitInvalidArgument is the exit code of a run with invalid arguments or flags.
+	ExitInvalidArgument = 2
+	// ExitStale is the exit code of a run refusing to mirror stale source metadata.
+	ExitStale = 3
+	// ExitSourceUnreachable is the exit code of a run failing to download from the source.
+	ExitSourceUnreachable = 4
+	// ExitVerification is the exit code of a run failing TUF or signature verification.
+	ExitVerification = 5
+	// ExitExpired is the exit code of a run finding expired TUF metadata.
+	ExitExpired = 6
+	// ExitDestinationAuth is the exit code of a run denied access to a destination.
+	ExitDestinationAuth = 7
+	// ExitPartialPush is the exit code of a run mirroring to some destinations but not others.
+	ExitPartialPush = 8
+	// ExitRootRotation is the exit code of a run refusing a source whose root was rotated since the last run.
+	ExitRootRotation = 9
+)
+
+// Classes of failure, errors returned by Execute match any that apply with errors.Is.
+var (
+	ErrInvalidArgument   = mirror.ErrInvalidArgument
+	ErrSourceUnreachable = mirror.ErrSourceUnreachable
+	ErrVerification      = mirror.ErrVerification
+	ErrExpired           = mirror.ErrExpired
+	ErrDestinationAuth   = mirror.ErrDestinationAuth
+	ErrPartialPush       = mirror.ErrPartialPush
+)
+
+// exitCodes maps failure classes to exit codes, in order of precedence when an error (e.g. from several
+// destinations) is of several classes.
+var exitCodes = []struct {
+	err  error
+	code int
+}{
+	{mirror.ErrStale, ExitStale},
+	{mirror.ErrRootRotation, ExitRootRotation},
+	{signing.ErrNoSignature, ExitVerification},
+	{ErrInvalidArgument, ExitInvalidArgument},
+	{ErrExpired, ExitExpired},
+	{ErrVerification, ExitVerification},
+	{ErrSourceUnreachable, ExitSourceUnreachable},
+	{ErrPartialPush, ExitPartialPush},
+	{ErrDestinationAuth, ExitDestinationAuth},
+}
+
+// invalidArgumentf formats an invalid argument error.
+func invalidArgumentf(format string, a ...any) error {
+	return failure.Classify(failure.InvalidArgument, fmt.Errorf(format, a...))
+}
+
+// ExitCode returns the process exit code for an error returned by Execute.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	for _, c := range exitCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ExitError
+}
@@ -0,0 +1,145 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-tuf-mirror/internal/lock"
+	"github.com/docker/go-tuf-mirror/internal/test"
+	"github.com/docker/go-tuf-mirror/pkg/mirror"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitCodes(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	// a registry denying every request
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer reg.Close()
+	u, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	denied := RegistryPrefix + "localhost:" + u.Port() + "/test/metadata:latest"
+
+	testCases := []struct {
+		name     string
+		tufRoot  string
+		source   string
+		dest     string
+		expected int
+	}{
+		{"invalid destination", "dev", server.URL + "/metadata", "ftp://example.com", ExitInvalidArgument},
+		{"unreachable source", "dev", unreachable.URL + "/metadata", OCIPrefix + "metadata", ExitSourceUnreachable},
+		// the test repository isn't signed by the prod root keys
+		{"untrusted source", "prod", server.URL + "/metadata", OCIPrefix + "metadata", ExitVerification},
+		{"denied destination", "dev", server.URL + "/metadata", denied, ExitDestinationAuth},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			dest := tc.dest
+			if dest == OCIPrefix+"metadata" {
+				dest = OCIPrefix + filepath.Join(tempDir, "metadata")
+			}
+			opts := defaultRootOptions()
+			opts.tufPath = filepath.Join(tempDir, "tuf")
+			opts.tufRoot = tc.tufRoot
+			opts.lockOptions.Timeout = 0
+			cmd, err := newMetadataCmd(opts)
+			require.NoError(t, err)
+			cmd.SetOut(io.Discard)
+			cmd.SetArgs([]string{"--source", tc.source, "--destination", dest})
+			ctx, _ := testLogContext(t)
+			err = cmd.ExecuteContext(ctx)
+			require.Error(t, err)
+			assert.Equal(t, tc.expected, ExitCode(err), err.Error())
+		})
+	}
+
+	// one of two destinations denying pushes is a partial push, which ranks above the auth failure
+	handler := registry.New(registry.WithReferrersSupport(false))
+	pushDenied := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") && !strings.HasSuffix(r.URL.Path, lock.Tag) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer pushDenied.Close()
+	u, err = url.Parse(pushDenied.URL)
+	require.NoError(t, err)
+	tempDir := t.TempDir()
+	opts := defaultRootOptions()
+	opts.tufPath = filepath.Join(tempDir, "tuf")
+	opts.tufRoot = "dev"
+	opts.lockOptions.Timeout = 0
+	cmd, err := newMetadataCmd(opts)
+	require.NoError(t, err)
+	cmd.SetOut(io.Discard)
+	cmd.SetArgs([]string{"--source", server.URL + "/metadata", "--destination", RegistryPrefix + "localhost:" + u.Port() + "/test/metadata:latest", "--destination", OCIPrefix + filepath.Join(tempDir, "metadata")})
+	ctx, _ := testLogContext(t)
+	err = cmd.ExecuteContext(ctx)
+	require.ErrorIs(t, err, ErrDestinationAuth)
+	assert.Equal(t, ExitPartialPush, ExitCode(err))
+
+	// a bucket whose AWS configuration can't be loaded is refused like denied credentials
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(tempDir, "config"))
+	t.Setenv("AWS_PROFILE", "missing")
+	cmd, err = newMetadataCmd(opts)
+	require.NoError(t, err)
+	cmd.SetOut(io.Discard)
+	cmd.SetArgs([]string{"--source", server.URL + "/metadata", "--destination", "s3://tuf/metadata"})
+	err = cmd.ExecuteContext(ctx)
+	require.Error(t, err)
+	assert.Equal(t, ExitDestinationAuth, ExitCode(err), err.Error())
+
+	assert.Equal(t, 0, ExitCode(nil))
+	assert.Equal(t, ExitError, ExitCode(io.EOF))
+}
+
+func TestExitExpired(t *testing.T) {
+	repo, root := test.NewRepository(t, time.Now().Add(-time.Hour))
+	server := httptest.NewServer(http.FileServer(http.Dir(repo)))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	source, err := mirror.NewWebSource(server.URL+"/metadata", server.URL+"/targets")
+	require.NoError(t, err)
+	_, err = mirror.Mirror(context.Background(), mirror.Config{
+		Root:                 root,
+		CachePath:            filepath.Join(tempDir, "tuf"),
+		Source:               source,
+		MetadataDestinations: []mirror.Destination{mirror.NewLayoutDestination(filepath.Join(tempDir, "metadata"))},
+	})
+	require.ErrorIs(t, err, ErrExpired)
+	assert.Equal(t, ExitExpired, ExitCode(err))
+}
@@ -0,0 +1,188 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	"github.com/docker/go-tuf-mirror/pkg/mirror"
+	"github.com/spf13/cobra"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+const (
+	tableFormat = "table"
+	jsonFormat  = "json"
+)
+
+type inspectOptions struct {
+	metadata    string
+	targets     string
+	output      string
+	rootOptions *rootOptions
+}
+
+type roleInfo struct {
+	Role    string    `json:"role"`
+	Version int64     `json:"version"`
+	Expires time.Time `json:"expires"`
+}
+
+type delegationInfo struct {
+	Role        string   `json:"role"`
+	Delegator   string   `json:"delegator"`
+	Threshold   int      `json:"threshold"`
+	Terminating bool     `json:"terminating"`
+	Paths       []string `json:"paths"`
+}
+
+type inspectOutput struct {
+	Roles       []roleInfo              `json:"roles,omitempty"`
+	Delegations []delegationInfo        `json:"delegations,omitempty"`
+	Targets     []mirrortuf.TargetEntry `json:"targets,omitempty"`
+}
+
+func defaultInspectOptions(opts *rootOptions) *inspectOptions {
+	return &inspectOptions{
+		output:      tableFormat,
+		rootOptions: opts,
+	}
+}
+
+func newInspectCmd(opts *rootOptions) *cobra.Command {
+	o := defaultInspectOptions(opts)
+
+	cmd := &cobra.Command{
+		Use:          "inspect",
+		Short:        "List mirrored TUF metadata and targets",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE:         o.run,
+	}
+	cmd.Flags().StringVar(&o.metadata, "metadata", "", fmt.Sprintf("Mirrored metadata location %s", schemes(mirror.MetadataSchemes())))
+	cmd.Flags().StringVar(&o.targets, "targets", "", fmt.Sprintf("Mirrored targets location %s", schemes(mirror.DestinationSchemes())))
+	cmd.Flags().StringVarP(&o.output, "output", "o", tableFormat, fmt.Sprintf("Output format [%s, %s]", tableFormat, jsonFormat))
+	cmd.MarkFlagsOneRequired("metadata", "targets")
+	return cmd
+}
+
+func (o *inspectOptions) run(cmd *cobra.Command, args []string) error {
+	if o.output != tableFormat && o.output != jsonFormat {
+		return invalidArgumentf("unsupported output format: %s", o.output)
+	}
+	out := &inspectOutput{}
+
+	if o.metadata != "" {
+		f, err := mirror.NewFetcher(o.metadata)
+		if err != nil {
+			return err
+		}
+		defer closeLocation(f)
+		repo, err := mirrortuf.LoadRepository(cmd.Context(), f)
+		if err != nil {
+			return fmt.Errorf("failed to load metadata from %s: %w", o.metadata, err)
+		}
+		out.Roles = []roleInfo{
+			{metadata.ROOT, repo.Root.Signed.Version, repo.Root.Signed.Expires},
+			{metadata.TIMESTAMP, repo.Timestamp.Signed.Version, repo.Timestamp.Signed.Expires},
+			{metadata.SNAPSHOT, repo.Snapshot.Signed.Version, repo.Snapshot.Signed.Expires},
+		}
+		roles := make([]string, 0, len(repo.Targets))
+		for role := range repo.Targets {
+			roles = append(roles, role)
+		}
+		// top-level targets first, then delegated roles by name
+		sort.Slice(roles, func(i, j int) bool {
+			return roles[i] == metadata.TARGETS || (roles[j] != metadata.TARGETS && roles[i] < roles[j])
+		})
+		for _, role := range roles {
+			t := repo.Targets[role]
+			out.Roles = append(out.Roles, roleInfo{role, t.Signed.Version, t.Signed.Expires})
+			if t.Signed.Delegations == nil {
+				continue
+			}
+			for _, d := range t.Signed.Delegations.Roles {
+				out.Delegations = append(out.Delegations, delegationInfo{
+					Role:        d.Name,
+					Delegator:   role,
+					Threshold:   d.Threshold,
+					Terminating: d.Terminating,
+					Paths:       d.Paths,
+				})
+			}
+		}
+	}
+
+	if o.targets != "" {
+		d, err := mirror.NewDestination(o.targets)
+		if err != nil {
+			return err
+		}
+		defer closeLocation(d)
+		out.Targets, err = d.Targets(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("failed to list targets in %s: %w", o.targets, err)
+		}
+	}
+
+	if o.output == jsonFormat {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+	return writeInspectTables(cmd.OutOrStdout(), out)
+}
+
+func writeInspectTables(w io.Writer, out *inspectOutput) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	sections := 0
+	section := func() {
+		if sections > 0 {
+			fmt.Fprintln(tw)
+		}
+		sections++
+	}
+	if len(out.Roles) > 0 {
+		section()
+		fmt.Fprintln(tw, "ROLE\tVERSION\tEXPIRES")
+		for _, r := range out.Roles {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", r.Role, r.Version, r.Expires.UTC().Format(time.RFC3339))
+		}
+	}
+	if len(out.Delegations) > 0 {
+		section()
+		fmt.Fprintln(tw, "DELEGATION\tDELEGATOR\tTHRESHOLD\tTERMINATING\tPATHS")
+		for _, d := range out.Delegations {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%t\t%s\n", d.Role, d.Delegator, d.Threshold, d.Terminating, strings.Join(d.Paths, ","))
+		}
+	}
+	if len(out.Targets) > 0 {
+		section()
+		fmt.Fprintln(tw, "TARGET\tROLE\tTAG\tSIZE\tSHA256")
+		for _, t := range out.Targets {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", t.Path, t.Role, t.Tag, t.Size, t.SHA256)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,219 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	TopLevelTargetsLength  = 5
+	DelegatedTargetsFiles  = 2
+	delegatedTargetPath    = "test-role/dir1/dir2/dir3/test.txt"
+	delegatedTargetTag     = "test-role"
+	delegatedTargetsSHA256 = "bb8fcf06f6c067dcbcb394d7d9ced788316fc02b715fe679097281108a4bd465"
+)
+
+func TestInspectCmd(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+	serverMetadata := server.URL + "/metadata"
+	serverTargets := server.URL + "/targets"
+
+	reg := httptest.NewServer(registry.New(registry.WithReferrersSupport(false)))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		dstMeta    string
+		dstTargets string
+	}{
+		{"oci", OCIPrefix + filepath.Join(tempDir, "metadata"), OCIPrefix + filepath.Join(tempDir, "targets")},
+		{"registry", RegistryPrefix + "localhost:" + url.Port() + "/test/metadata:latest", RegistryPrefix + "localhost:" + url.Port() + "/test/targets"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := defaultRootOptions()
+			opts.tufPath = filepath.Join(tempDir, "tuf")
+			opts.tufRoot = "dev"
+			opts.full = true
+			all, err := newAllCmd(opts)
+			require.NoError(t, err)
+			all.SetOut(bytes.NewBufferString(""))
+			_ = all.Flags().Set("source-metadata", serverMetadata)
+			_ = all.Flags().Set("source-targets", serverTargets)
+			_ = all.Flags().Set("dest-metadata", tc.dstMeta)
+			_ = all.Flags().Set("dest-targets", tc.dstTargets)
+			require.NoError(t, all.Execute())
+
+			// json output
+			cmd := newInspectCmd(defaultRootOptions())
+			b := bytes.NewBufferString("")
+			cmd.SetOut(b)
+			cmd.SetArgs([]string{"--metadata", tc.dstMeta, "--targets", tc.dstTargets, "-o", jsonFormat})
+			require.NoError(t, cmd.Execute())
+
+			out := &inspectOutput{}
+			require.NoError(t, json.Unmarshal(b.Bytes(), out))
+			// root, timestamp, snapshot, targets and the delegated role
+			assert.Len(t, out.Roles, 5)
+			require.Len(t, out.Delegations, 1)
+			assert.Equal(t, DelegatedTargetNames[0], out.Delegations[0].Role)
+			assert.Equal(t, 1, out.Delegations[0].Threshold)
+			assert.Len(t, out.Targets, TopLevelTargetsLength+DelegatedTargetsFiles)
+			found := false
+			for _, target := range out.Targets {
+				if target.Path == delegatedTargetPath {
+					found = true
+					assert.Equal(t, delegatedTargetTag, target.Tag)
+					assert.Equal(t, delegatedTargetsSHA256, target.SHA256)
+				}
+			}
+			assert.True(t, found)
+
+			// table output
+			cmd = newInspectCmd(defaultRootOptions())
+			b = bytes.NewBufferString("")
+			cmd.SetOut(b)
+			cmd.SetArgs([]string{"--targets", tc.dstTargets})
+			require.NoError(t, cmd.Execute())
+			lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+			assert.Len(t, lines, 1+TopLevelTargetsLength+DelegatedTargetsFiles)
+			assert.True(t, strings.HasPrefix(lines[0], "TARGET"))
+		})
+	}
+}
+
+func TestInspectTagTemplate(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	reg := httptest.NewServer(registry.New(registry.WithReferrersSupport(false)))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	dstMeta := RegistryPrefix + "localhost:" + url.Port() + "/test/metadata:latest"
+	dstTargets := RegistryPrefix + "localhost:" + url.Port() + "/test/targets"
+
+	opts := defaultRootOptions()
+	opts.tufPath = filepath.Join(tempDir, "tuf")
+	opts.tufRoot = "dev"
+	opts.full = true
+	opts.targetTag = "{{.Role}}-{{.Hash | trunc 12}}"
+	opts.delegatedTag = `role-{{.Role | replace "-" "_"}}`
+	all, err := newAllCmd(opts)
+	require.NoError(t, err)
+	b := bytes.NewBufferString("")
+	all.SetOut(b)
+	all.SetArgs([]string{
+		"--source-metadata", server.URL + "/metadata",
+		"--source-targets", server.URL + "/targets",
+		"--dest-metadata", dstMeta,
+		"--dest-targets", dstTargets,
+	})
+	require.NoError(t, all.Execute())
+	assert.Contains(t, b.String(), "Target manifest pushed to localhost:"+url.Port()+"/test/targets:targets-02119a076ec3\n")
+	assert.Contains(t, b.String(), "Delegated metadata manifest pushed to localhost:"+url.Port()+"/test/metadata:role-test_role\n")
+	assert.Contains(t, b.String(), "Delegated target index manifest pushed to localhost:"+url.Port()+"/test/targets:role-test_role\n")
+
+	// readers find the delegated role and targets using the scheme recorded on the metadata image
+	cmd := newInspectCmd(defaultRootOptions())
+	b = bytes.NewBufferString("")
+	cmd.SetOut(b)
+	cmd.SetArgs([]string{"--metadata", dstMeta, "--targets", dstTargets, "-o", jsonFormat})
+	require.NoError(t, cmd.Execute())
+	out := &inspectOutput{}
+	require.NoError(t, json.Unmarshal(b.Bytes(), out))
+	assert.Len(t, out.Roles, 5)
+	require.Len(t, out.Targets, TopLevelTargetsLength+DelegatedTargetsFiles)
+	for _, target := range out.Targets {
+		if target.Path == delegatedTargetPath {
+			assert.Equal(t, DelegatedTargetNames[0], target.Role)
+			assert.Equal(t, "role-test_role", target.Tag)
+		}
+	}
+
+	// tags must be valid
+	opts = defaultRootOptions()
+	opts.tufPath = filepath.Join(tempDir, "tuf")
+	opts.tufRoot = "dev"
+	opts.targetTag = "{{.Role}}/{{.Hash}}"
+	all, err = newAllCmd(opts)
+	require.NoError(t, err)
+	all.SetOut(io.Discard)
+	all.SetErr(io.Discard)
+	all.SetArgs([]string{
+		"--source-metadata", server.URL + "/metadata",
+		"--source-targets", server.URL + "/targets",
+		"--dest-metadata", dstMeta,
+		"--dest-targets", dstTargets,
+	})
+	require.ErrorContains(t, all.Execute(), "invalid target tag")
+
+	// tags used by the mirror itself are reserved
+	for _, tc := range []struct{ targetTag, delegatedTag, expected string }{
+		{targetTag: "latest", expected: `invalid target tag "latest"`},
+		{targetTag: "snapshot-{{len .Hash}}", expected: `invalid target tag "snapshot-64"`},
+		{delegatedTag: "go-tuf-mirror-lock", expected: `invalid delegated tag "go-tuf-mirror-lock"`},
+		// and each target and delegated role must be tagged differently
+		{targetTag: "{{.Hash | trunc 1}}", expected: `are both tagged "b"`},
+		{targetTag: `{{if eq .Name "test.txt"}}test-role{{else}}{{.Hash}}{{end}}`, expected: `target test.txt and delegated role test-role are both tagged "test-role"`},
+	} {
+		opts = defaultRootOptions()
+		opts.tufPath = filepath.Join(tempDir, "tuf")
+		opts.tufRoot = "dev"
+		opts.full = true
+		if tc.targetTag != "" {
+			opts.targetTag = tc.targetTag
+		}
+		if tc.delegatedTag != "" {
+			opts.delegatedTag = tc.delegatedTag
+		}
+		all, err = newAllCmd(opts)
+		require.NoError(t, err)
+		all.SetOut(io.Discard)
+		all.SetErr(io.Discard)
+		all.SetArgs([]string{
+			"--source-metadata", server.URL + "/metadata",
+			"--source-targets", server.URL + "/targets",
+			"--dest-metadata", dstMeta,
+			"--dest-targets", dstTargets,
+		})
+		err = all.Execute()
+		require.ErrorContains(t, err, tc.expected)
+		assert.Equal(t, ExitInvalidArgument, ExitCode(err))
+	}
+}
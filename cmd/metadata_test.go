@@ -18,6 +18,8 @@ package cmd
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
@@ -27,12 +29,21 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/docker/attest/tuf"
+	"github.com/docker/go-tuf-mirror/internal/freshness"
+	"github.com/docker/go-tuf-mirror/internal/rotation"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
 )
 
 var (
@@ -84,20 +95,18 @@ func TestMetadataCmd(t *testing.T) {
 					delegatedOutput += fmt.Sprintf("Delegated metadata manifest %s to %s\n", operation, filepath.Join(output, d))
 				}
 			}
-			expectedOutput := fmt.Sprintf("Mirroring TUF metadata %s to %s\nMetadata manifest %s to %s\n",
-				tc.source,
-				tc.destination,
-				operation,
-				output)
+			// delegated metadata is written before the metadata referencing it
+			expectedOutput := fmt.Sprintf("Metadata manifest %s to %s\n", operation, output)
 			if tc.full {
-				expectedOutput += delegatedOutput
+				expectedOutput = delegatedOutput + expectedOutput
 			}
 
 			b := bytes.NewBufferString("")
 			opts := defaultRootOptions()
 			opts.full = tc.full
 			opts.tufRoot = "dev"
-			cmd := newMetadataCmd(opts)
+			cmd, err := newMetadataCmd(opts)
+			require.NoError(t, err)
 			if cmd == nil {
 				t.Fatal("newMetadataCmd returned nil")
 			}
@@ -105,8 +114,10 @@ func TestMetadataCmd(t *testing.T) {
 			_ = cmd.PersistentFlags().Set("source", tc.source)
 			_ = cmd.PersistentFlags().Set("destination", tc.destination)
 
-			err := cmd.Execute()
+			ctx, logs := testLogContext(t)
+			err = cmd.ExecuteContext(ctx)
 			require.NoError(t, err)
+			assert.Contains(t, logs.String(), fmt.Sprintf("msg=\"Mirroring TUF metadata\" source=%s destination=%s\n", tc.source, tc.destination))
 
 			out, err := io.ReadAll(b)
 			require.NoError(t, err)
@@ -134,3 +145,184 @@ func TestMetadataCmd(t *testing.T) {
 		})
 	}
 }
+
+func TestMetadataRollback(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	reg := httptest.NewServer(registry.New(registry.WithReferrersSupport(false)))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	imageName := "localhost:" + url.Port() + "/test/metadata:latest"
+
+	run := func(args ...string) error {
+		opts := defaultRootOptions()
+		opts.tufPath = filepath.Join(tempDir, "tuf")
+		opts.tufRoot = "dev"
+		cmd, err := newMetadataCmd(opts)
+		require.NoError(t, err)
+		cmd.SetOut(io.Discard)
+		cmd.SetArgs(append([]string{"--source", server.URL + "/metadata", "--destination", RegistryPrefix + imageName}, args...))
+		ctx, _ := testLogContext(t)
+		return cmd.ExecuteContext(ctx)
+	}
+	require.NoError(t, run())
+
+	// publish a newer timestamp to the destination (signatures aren't checked, only versions)
+	ref, err := name.ParseReference(imageName)
+	require.NoError(t, err)
+	img, err := remote.Image(ref)
+	require.NoError(t, err)
+	mf, err := img.Manifest()
+	require.NoError(t, err)
+	newer := mutate.MediaType(empty.Image, mf.MediaType)
+	for _, desc := range mf.Layers {
+		layer, err := img.LayerByDigest(desc.Digest)
+		require.NoError(t, err)
+		rc, err := layer.Uncompressed()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		if desc.Annotations[tuf.TUFFileNameAnnotation] == "timestamp.json" {
+			timestamp, err := metadata.Timestamp().FromBytes(data)
+			require.NoError(t, err)
+			timestamp.Signed.Version += 10
+			data, err = timestamp.ToBytes(false)
+			require.NoError(t, err)
+		}
+		newer, err = mutate.Append(newer, mutate.Addendum{Layer: static.NewLayer(data, desc.MediaType), Annotations: desc.Annotations, MediaType: desc.MediaType})
+		require.NoError(t, err)
+	}
+	require.NoError(t, remote.Write(ref, newer))
+
+	err = run()
+	require.ErrorIs(t, err, mirrortuf.ErrRollback)
+	assert.ErrorContains(t, err, "timestamp: version 17 at destination, 7 to be published")
+
+	require.NoError(t, run("--allow-rollback"))
+}
+
+func TestMetadataStaleness(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	run := func(cache string, opts freshness.Options) error {
+		root := defaultRootOptions()
+		root.tufPath = filepath.Join(tempDir, cache)
+		root.tufRoot = "dev"
+		root.freshness = opts
+		cmd, err := newMetadataCmd(root)
+		require.NoError(t, err)
+		cmd.SetOut(io.Discard)
+		cmd.SetArgs([]string{"--source", server.URL + "/metadata", "--destination", OCIPrefix + filepath.Join(tempDir, "metadata")})
+		ctx, _ := testLogContext(t)
+		return cmd.ExecuteContext(ctx)
+	}
+
+	// the test timestamp expires in 2034
+	err := run("tuf", freshness.Options{MinValidity: 100 * 365 * 24 * time.Hour})
+	require.ErrorIs(t, err, freshness.ErrStale)
+	assert.Equal(t, ExitStale, ExitCode(err))
+	require.NoError(t, run("tuf", freshness.Options{MinValidity: time.Hour}))
+
+	// the first run records when the timestamp version was seen, the test timestamp never advances
+	later := func() time.Time { return time.Now().Add(2 * time.Hour) }
+	require.NoError(t, run("tuf", freshness.Options{MaxAge: time.Hour}))
+	err = run("tuf", freshness.Options{MaxAge: time.Hour, Now: later})
+	require.ErrorIs(t, err, freshness.ErrStale)
+	assert.Equal(t, ExitStale, ExitCode(err))
+	require.NoError(t, run("tuf", freshness.Options{MaxAge: 3 * time.Hour, Now: later}))
+
+	// the record at the destination guards runs that don't keep the cache
+	err = run("ephemeral", freshness.Options{MaxAge: time.Hour, Now: later})
+	require.ErrorIs(t, err, freshness.ErrStale)
+}
+
+func TestMetadataRootRotation(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+	source := server.URL + "/metadata"
+
+	reg := httptest.NewServer(registry.New(registry.WithReferrersSupport(false)))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	imageName := "localhost:" + url.Port() + "/test/metadata:latest"
+
+	run := func(tufPath string, accept bool, destination string) (string, error) {
+		opts := defaultRootOptions()
+		opts.tufPath = filepath.Join(tempDir, tufPath)
+		opts.tufRoot = "dev"
+		opts.acceptRotation = accept
+		cmd, err := newMetadataCmd(opts)
+		require.NoError(t, err)
+		b := bytes.NewBufferString("")
+		cmd.SetOut(b)
+		cmd.SetArgs([]string{"--source", source, "--destination", destination})
+		ctx, _ := testLogContext(t)
+		err = cmd.ExecuteContext(ctx)
+		return b.String(), err
+	}
+	// nothing to compare with on the first run
+	out, err := run("tuf", false, RegistryPrefix+imageName)
+	require.NoError(t, err)
+	assert.NotContains(t, out, "Root rotated")
+
+	// publish the metadata as it was before the root was rotated to version 2
+	ref, err := name.ParseReference(imageName)
+	require.NoError(t, err)
+	img, err := remote.Image(ref)
+	require.NoError(t, err)
+	mf, err := img.Manifest()
+	require.NoError(t, err)
+	older := mutate.MediaType(empty.Image, mf.MediaType)
+	for _, desc := range mf.Layers {
+		if desc.Annotations[tuf.TUFFileNameAnnotation] == "2.root.json" {
+			continue
+		}
+		layer, err := img.LayerByDigest(desc.Digest)
+		require.NoError(t, err)
+		rc, err := layer.Uncompressed()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		older, err = mutate.Append(older, mutate.Addendum{Layer: static.NewLayer(data, desc.MediaType), Annotations: desc.Annotations, MediaType: desc.MediaType})
+		require.NoError(t, err)
+	}
+	require.NoError(t, remote.Write(ref, older))
+
+	rotated := "Root rotated from version 1 to 2\n" +
+		"  key beac53949c4cf075824edede7d41715941f524db247d1b455a2389d7490ecd72: <none> -> present\n"
+	// the rotation is refused against the destination root until it's accepted
+	for range 2 {
+		_, err = run("destination", false, RegistryPrefix+imageName)
+		assert.Equal(t, ExitRootRotation, ExitCode(err), err.Error())
+		assert.ErrorContains(t, err, rotated)
+	}
+	out, err = run("destination", true, RegistryPrefix+imageName)
+	require.NoError(t, err)
+	assert.Contains(t, out, rotated)
+	out, err = run("destination", false, RegistryPrefix+imageName)
+	require.NoError(t, err)
+	assert.NotContains(t, out, "Root rotated")
+
+	// and against the root recorded in the cache, with a destination holding nothing
+	dev, err := tuf.GetEmbeddedRoot("dev")
+	require.NoError(t, err)
+	sum := sha256.Sum256([]byte(source))
+	record := filepath.Join(tempDir, "cache", rotation.Dir, hex.EncodeToString(sum[:])+".json")
+	require.NoError(t, os.MkdirAll(filepath.Dir(record), 0o755))
+	require.NoError(t, os.WriteFile(record, dev.Data, 0o644))
+	_, err = run("cache", false, OCIPrefix+filepath.Join(tempDir, "metadata"))
+	assert.Equal(t, ExitRootRotation, ExitCode(err), err.Error())
+	out, err = run("cache", true, OCIPrefix+filepath.Join(tempDir, "metadata"))
+	require.NoError(t, err)
+	assert.Contains(t, out, rotated)
+}
@@ -19,58 +19,413 @@ package cmd
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/docker/attest/mirror"
+	"github.com/docker/attest/tuf"
 	"github.com/docker/attest/useragent"
+	"github.com/docker/go-tuf-mirror/internal/failure"
+	"github.com/docker/go-tuf-mirror/internal/freshness"
+	"github.com/docker/go-tuf-mirror/internal/lock"
+	"github.com/docker/go-tuf-mirror/internal/logging"
+	"github.com/docker/go-tuf-mirror/internal/metrics"
+	"github.com/docker/go-tuf-mirror/internal/signing"
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	"github.com/docker/go-tuf-mirror/internal/tracing"
+	"github.com/docker/go-tuf-mirror/pkg/mirror"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+// Prefixes of the locations of the built-in schemes, see mirror.Register.
 const (
-	OCIPrefix         = "oci://"    // filesystem oci layout
-	RegistryPrefix    = "docker://" // remote registry
-	LocalPrefix       = "file://"   // local filesystem
-	WebPrefix         = "https://"  // web
-	InsecureWebPrefix = "http://"   // insecure web
+	OCIPrefix           = "oci://"            // filesystem oci layout
+	OCIArchivePrefix    = "oci-archive://"    // tar archive of an oci layout
+	DockerArchivePrefix = "docker-archive://" // tar archive of an oci layout with a docker manifest.json
+	RegistryPrefix      = "docker://"         // remote registry
+	LocalPrefix         = "file://"           // local filesystem
+	WebPrefix           = "https://"          // web
+	InsecureWebPrefix   = "http://"           // insecure web
 )
 
+// tracingShutdownTimeout bounds flushing the spans of a run on exit, which mustn't hang on an unreachable collector.
+const tracingShutdownTimeout = 10 * time.Second
+
 type rootOptions struct {
-	tufPath string
-	tufRoot string
-	mirror  *mirror.TUFMirror
-	full    bool
+	tufPath         string
+	tufRoot         string
+	rootDigest      string
+	full            bool
+	metricsAddr     string
+	metricsTextfile string
+	metricsPushURL  string
+	metricsJob      string
+	registry        *prometheus.Registry
+	stopMetrics     func(context.Context) error
+	logLevel        string
+	logFormat       string
+	lockOptions     lock.Options
+	targetTag       string
+	delegatedTag    string
+	signingKey      string
+	freshness       freshness.Options
+	cacheControl    []string
+	singleLayout    bool
+	acceptRotation  bool
+	ephemeralCache  bool
+	// ephemeralDir is the cache directory of --ephemeral-cache, once created
+	ephemeralDir string
 }
 
 func defaultRootOptions() *rootOptions {
-	return &rootOptions{}
+	return &rootOptions{
+		logLevel:  "info",
+		logFormat: logging.TextFormat,
+		registry:  prometheus.NewRegistry(),
+		lockOptions: lock.Options{
+			Timeout:    10 * time.Minute,
+			StaleAfter: mirror.DefaultLockStaleAfter,
+		},
+		targetTag:    tags.DefaultTargetTemplate,
+		delegatedTag: tags.DefaultDelegatedTemplate,
+	}
 }
 
-func newRootCmd(version string) *cobra.Command {
-	o := defaultRootOptions()
+func newRootCmd(version string, o *rootOptions) (*cobra.Command, error) {
 	cmd := &cobra.Command{
 		Use:   "go-tuf-mirror",
 		Short: "Mirror TUF metadata to and between OCI registries, filesystems etc",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return cmd.Help()
 		},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// progress and diagnostics go to stderr, leaving stdout for results
+			logger, err := logging.New(cmd.ErrOrStderr(), o.logLevel, o.logFormat)
+			if err != nil {
+				return failure.Classify(failure.InvalidArgument, err)
+			}
+			cmd.SetContext(logging.WithLogger(cmd.Context(), logger))
+
+			if o.metricsAddr == "" {
+				return nil
+			}
+			stop, err := metrics.Serve(o.metricsAddr, o.registry, logger)
+			if err != nil {
+				return fmt.Errorf("failed to start metrics listener: %w", err)
+			}
+			o.stopMetrics = stop
+			return nil
+		},
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			if o.stopMetrics == nil {
+				return nil
+			}
+			return o.stopMetrics(cmd.Context())
+		},
 	}
 	cmd.PersistentFlags().StringVarP(&o.tufPath, "tuf-path", "t", "", "path on filesystem for tuf root")
+	cmd.PersistentFlags().BoolVar(&o.ephemeralCache, "ephemeral-cache", false, "Cache TUF metadata in a private temporary directory removed on exit, rather than --tuf-path")
+	cmd.PersistentFlags().StringVar(&o.rootDigest, "root-digest", "", "Trust the latest root of a root chain artifact published by the root command, <repository>@sha256:<digest>, instead of an embedded root")
 	cmd.PersistentFlags().BoolVarP(&o.full, "full", "f", false, "Mirror full metadata/targets (includes delegated targets)")
 	cmd.PersistentFlags().StringVarP(&o.tufRoot, "tuf-root", "r", "", "specify embedded tuf root [dev, staging, prod], default [prod]")
+	cmd.PersistentFlags().StringVar(&o.metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on while running (e.g. :9090), disabled by default")
+	cmd.PersistentFlags().StringVar(&o.metricsTextfile, "metrics-textfile", "", "File to write Prometheus metrics to once the command completes, e.g. for the node exporter textfile collector")
+	cmd.PersistentFlags().StringVar(&o.metricsPushURL, "metrics-push-url", "", "URL of a Prometheus Pushgateway to push metrics to once the command completes")
+	cmd.PersistentFlags().StringVar(&o.metricsJob, "metrics-job", "", "Name of the mirror job in the metrics and Pushgateway grouping key, default the source metadata location")
+	cmd.PersistentFlags().StringVar(&o.logLevel, "log-level", o.logLevel, "Log level [debug, info, warn, error]")
+	cmd.PersistentFlags().StringVar(&o.logFormat, "log-format", o.logFormat, fmt.Sprintf("Log format [%s, %s]", logging.TextFormat, logging.JSONFormat))
 
-	cmd.AddCommand(newMetadataCmd(o))      // metadata subcommand
-	cmd.AddCommand(newTargetsCmd(o))       // targets subcommand
+	metadataCmd, err := newMetadataCmd(o)
+	if err != nil {
+		return nil, err
+	}
+	targetsCmd, err := newTargetsCmd(o)
+	if err != nil {
+		return nil, err
+	}
+	allCmd, err := newAllCmd(o)
+	if err != nil {
+		return nil, err
+	}
+	verifyCmd, err := newVerifyCmd(o)
+	if err != nil {
+		return nil, err
+	}
+	rootChainCmd, err := newRootChainCmd(o)
+	if err != nil {
+		return nil, err
+	}
+	cmd.AddCommand(metadataCmd)            // metadata subcommand
+	cmd.AddCommand(targetsCmd)             // targets subcommand
 	cmd.AddCommand(newVersionCmd(version)) // version subcommand
-	cmd.AddCommand(newAllCmd(o))           // all subcommand
+	cmd.AddCommand(allCmd)                 // all subcommand
+	cmd.AddCommand(newDiffCmd(o))          // diff subcommand
+	cmd.AddCommand(newInspectCmd(o))       // inspect subcommand
+	cmd.AddCommand(verifyCmd)              // verify subcommand
+	cmd.AddCommand(rootChainCmd)           // root subcommand
+	cmd.AddCommand(newCacheCmd(o))         // cache subcommand
+
+	return cmd, nil
+}
+
+// cachePath returns the local TUF cache directory, ~/.docker/tuf unless --tuf-path is set, or a temporary
+// directory with --ephemeral-cache.
+func (o *rootOptions) cachePath() (string, error) {
+	if o.ephemeralCache {
+		if o.tufPath != "" {
+			return "", invalidArgumentf("--tuf-path and --ephemeral-cache are mutually exclusive")
+		}
+		if o.ephemeralDir == "" {
+			dir, err := os.MkdirTemp("", "go-tuf-mirror-cache-*")
+			if err != nil {
+				return "", fmt.Errorf("failed to create ephemeral cache directory: %w", err)
+			}
+			o.ephemeralDir = dir
+		}
+		return o.ephemeralDir, nil
+	}
+	if o.tufPath != "" {
+		return strings.TrimSpace(o.tufPath), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(home, ".docker", "tuf"), nil
+}
+
+// removeEphemeralCache removes the --ephemeral-cache directory, if one was created.
+func (o *rootOptions) removeEphemeralCache() error {
+	if o.ephemeralDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(o.ephemeralDir)
+	if err != nil {
+		return fmt.Errorf("failed to remove ephemeral cache directory: %w", err)
+	}
+	o.ephemeralDir = ""
+	return nil
+}
+
+// signer returns the signer for --signing-key, nil if mirrored manifests aren't signed.
+func (o *rootOptions) signer(ctx context.Context) (dsse.SignerVerifier, error) {
+	if o.signingKey == "" {
+		return nil, nil
+	}
+	signer, err := signing.LoadSigner(ctx, o.signingKey)
+	if err != nil {
+		return nil, invalidArgumentf("failed to load signing key: %w", err)
+	}
+	return signer, nil
+}
+
+// root returns the trusted root, the latest of the root chain pinned by --root-digest or the embedded
+// --tuf-root.
+func (o *rootOptions) root(ctx context.Context) ([]byte, error) {
+	if o.rootDigest == "" {
+		root, err := tuf.GetEmbeddedRoot(o.tufRoot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get root bytes: %w", err)
+		}
+		return root.Data, nil
+	}
+	if o.tufRoot != "" {
+		return nil, invalidArgumentf("--tuf-root and --root-digest are mutually exclusive")
+	}
+	return mirror.FetchRoot(ctx, o.rootDigest)
+}
+
+// config returns the mirror configuration shared by the mirroring commands, reporting progress to the
+// output of cmd.
+func (o *rootOptions) config(cmd *cobra.Command) (*mirror.Config, error) {
+	tufPath, err := o.cachePath()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := o.signer(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+	root, err := o.root(cmd.Context())
+	if err != nil {
+		return nil, err
+	}
+	return &mirror.Config{
+		Root:               root,
+		CachePath:          tufPath,
+		Full:               o.full,
+		TargetTag:          o.targetTag,
+		DelegatedTag:       o.delegatedTag,
+		Signer:             signer,
+		Freshness:          o.freshness,
+		AcceptRootRotation: o.acceptRotation,
+		Lock:               o.lockOptions,
+		OnEvent:            printEvent(cmd.OutOrStdout()),
+	}, nil
+}
 
-	return cmd
+// mirror runs cfg, recording its metrics for the --metrics-job and printing any root rotation to the output
+// of cmd, and then exports the metrics to --metrics-textfile and --metrics-push-url, failed runs included,
+// since the command exits before they can be scraped.
+func (o *rootOptions) mirror(cmd *cobra.Command, cfg mirror.Config) (mirror.Result, error) {
+	ctx := cmd.Context()
+	job := o.metricsJob
+	if job == "" {
+		job = cfg.Source.Metadata()
+	}
+	cfg.Registerer = metrics.WithJob(o.registry, job)
+	result, err := mirror.Mirror(ctx, cfg)
+	// a refused rotation is reported by the error
+	if result.RootRotation != nil && !errors.Is(err, mirror.ErrRootRotation) {
+		fmt.Fprintln(cmd.OutOrStdout(), result.RootRotation)
+	}
+	if o.metricsTextfile != "" {
+		err = errors.Join(err, metrics.WriteTextfile(o.metricsTextfile, o.registry))
+	}
+	if o.metricsPushURL != "" {
+		err = errors.Join(err, metrics.Push(ctx, o.metricsPushURL, job, o.registry))
+	}
+	return result, err
+}
+
+// printEvent returns an event handler printing each manifest written to a destination to w.
+func printEvent(w io.Writer) func(mirror.Event) {
+	return func(ev mirror.Event) {
+		// manifest kinds are lower case, sentences aren't
+		kind := strings.ToUpper(string(ev.Manifest[:1])) + string(ev.Manifest[1:])
+		written, skipped := "pushed", "already pushed"
+		switch ev.Destination.(type) {
+		case *mirror.LayoutDestination:
+			written, skipped = "layout saved", "layout already saved"
+		case *mirror.FileDestination:
+			written, skipped = "saved", "already saved"
+		case *mirror.ArchiveDestination:
+			written, skipped = "archived", "already archived"
+		}
+		verb := written
+		if ev.Kind == mirror.Skipped {
+			verb = skipped
+		}
+		fmt.Fprintf(w, "%s %s to %s\n", kind, verb, ev.Location)
+	}
+}
+
+// closeLocation releases what reading a location holds, such as an extracted archive, if anything.
+func closeLocation(v any) {
+	if c, ok := v.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// destinations returns the destinations at locations, with the caching of plain TUF repositories served over
+// http set by --cache-control and the OCI layout mode by --single-layout.
+func (o *rootOptions) destinations(locations []string) ([]mirror.Destination, error) {
+	cacheControl := mirror.CacheControl{}
+	for _, c := range o.cacheControl {
+		key, value, ok := strings.Cut(c, "=")
+		if !ok || key == "" {
+			return nil, invalidArgumentf("invalid cache control %q, expected <role>=<value>", c)
+		}
+		cacheControl[key] = value
+	}
+	destinations := make([]mirror.Destination, len(locations))
+	for i, location := range locations {
+		d, err := mirror.NewDestination(location)
+		if err != nil {
+			return nil, err
+		}
+		switch d := d.(type) {
+		case *mirror.S3Destination:
+			d.CacheControl = cacheControl
+		case *mirror.LayoutDestination:
+			d.Single = o.singleLayout
+		}
+		destinations[i] = d
+	}
+	return destinations, nil
+}
+
+// schemes formats scheme names as the location prefixes listed in flag usage, e.g. "docker://<...> or oci://<...>".
+func schemes(names []string) string {
+	prefixes := make([]string, len(names))
+	for i, name := range names {
+		prefixes[i] = name + "://<...>"
+	}
+	if len(prefixes) < 2 {
+		return strings.Join(prefixes, "")
+	}
+	return strings.Join(prefixes[:len(prefixes)-1], ", ") + " or " + prefixes[len(prefixes)-1]
+}
+
+// addMirrorFlags registers the options only the commands that mirror use, so the others don't accept and ignore them.
+func (o *rootOptions) addMirrorFlags(flags *pflag.FlagSet) {
+	flags.DurationVar(&o.lockOptions.Timeout, "lock-timeout", o.lockOptions.Timeout, "How long to wait for another run holding the destination lock, 0 to fail immediately")
+	flags.DurationVar(&o.lockOptions.StaleAfter, "lock-stale-after", o.lockOptions.StaleAfter, "How long a destination lock lasts without being renewed before it can be taken over")
+	flags.StringVar(&o.targetTag, "target-tag", o.targetTag, "Template for target image tags, with fields .Role, .Hash and .Name and functions trunc, replace, lower and upper")
+	flags.StringVar(&o.delegatedTag, "delegated-tag", o.delegatedTag, "Template for delegated role metadata and target index tags, with field .Role and functions trunc, replace, lower and upper")
+	flags.StringVar(&o.signingKey, "signing-key", o.signingKey, fmt.Sprintf("Key to sign mirrored manifests with, a PEM encoded ECDSA or ed25519 private key file, %s<key ARN> or %s<key version>", signing.AWSKMSPrefix, signing.GCPKMSPrefix))
+	flags.DurationVar(&o.freshness.MaxAge, "max-timestamp-age", o.freshness.MaxAge, "Refuse to mirror if the source timestamp version hasn't advanced for longer than this, 0 to disable")
+	flags.DurationVar(&o.freshness.MinValidity, "min-validity", o.freshness.MinValidity, "Refuse to mirror if the source timestamp expires within this, 0 to disable")
+	flags.BoolVar(&o.acceptRotation, "accept-root-rotation", o.acceptRotation, "Mirror a source whose root was rotated since the last run, refused otherwise")
+	flags.BoolVar(&o.singleLayout, "single-layout", o.singleLayout, "Write every manifest of an oci:// destination to a single OCI layout, named by its tag in an org.opencontainers.image.ref.name annotation, rather than a layout per tag")
+	flags.StringArrayVar(&o.cacheControl, "cache-control", o.cacheControl, fmt.Sprintf("Cache-Control of the files written to s3:// destinations, <role>=<value> for metadata or %s=<value> for target files, may be repeated", mirror.TargetFilesCacheKey))
+}
+
+// markFlagsRequired marks flags as required.
+func markFlagsRequired(flags *pflag.FlagSet, names ...string) error {
+	for _, name := range names {
+		if err := cobra.MarkFlagRequired(flags, name); err != nil {
+			return fmt.Errorf("failed to mark flag %s required: %w", name, err)
+		}
+	}
+	return nil
 }
 
 // Execute invokes the command.
-func Execute(version string) error {
-	ctx := context.Background()
+func Execute(version string) (err error) {
+	// interrupted runs return through here, so the ephemeral cache is removed on signals too
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	ctx = useragent.Set(ctx, fmt.Sprintf("go-tuf-mirror/%s (docker)", version))
-	if err := newRootCmd(version).ExecuteContext(ctx); err != nil {
+	shutdown, err := tracing.Setup(ctx, version)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		// the signal context is cancelled on interrupt, when the spans of the run still need flushing
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		err = errors.Join(err, shutdown(shutdownCtx))
+	}()
+
+	o := defaultRootOptions()
+	cmd, err := newRootCmd(version, o)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, o.removeEphemeralCache())
+	}()
+	// errors before the command starts running are from parsing and validating arguments and flags
+	var running bool
+	preRun := cmd.PersistentPreRunE
+	cmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		running = true
+		return preRun(cmd, args)
+	}
+	if err := cmd.ExecuteContext(ctx); err != nil {
+		if !running {
+			err = failure.Classify(failure.InvalidArgument, err)
+		}
 		return fmt.Errorf("error executing root command: %w", err)
 	}
 
@@ -0,0 +1,353 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/go-tuf-mirror/internal/bucket"
+	"github.com/docker/go-tuf-mirror/internal/lock"
+	"github.com/docker/go-tuf-mirror/internal/logging"
+	"github.com/docker/go-tuf-mirror/internal/test"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	textfile := filepath.Join(tempDir, "go-tuf-mirror.prom")
+	cmd, err := newRootCmd("", defaultRootOptions())
+	require.NoError(t, err)
+	cmd.SetOut(bytes.NewBufferString(""))
+	cmd.SetArgs([]string{
+		"metadata",
+		"--metrics-addr", "127.0.0.1:0",
+		"--metrics-textfile", textfile,
+		"--tuf-path", filepath.Join(tempDir, "tuf"),
+		"--tuf-root", "dev",
+		"--source", server.URL + "/metadata",
+		"--destination", OCIPrefix + filepath.Join(tempDir, "metadata"),
+	})
+	require.NoError(t, cmd.Execute())
+
+	body, err := os.ReadFile(textfile)
+	require.NoError(t, err)
+
+	out := string(body)
+	// series are labelled with the job, by default the source metadata location
+	job := `mirror_job="` + server.URL + `/metadata"`
+	assert.Contains(t, out, `go_tuf_mirror_tuf_role_version{`+job+`,role="targets"} 8`)
+	assert.Contains(t, out, `go_tuf_mirror_tuf_role_expiry_seconds{`+job+`,role="timestamp"}`)
+	assert.Contains(t, out, `go_tuf_mirror_artifacts_total{`+job+`,phase="metadata",result="pushed"}`)
+	assert.Contains(t, out, `go_tuf_mirror_last_success_timestamp_seconds{`+job+`,phase="metadata"}`)
+	// the runtime of a process that has exited isn't exported
+	assert.NotContains(t, out, "go_goroutines")
+	assert.NotContains(t, out, "process_")
+}
+
+func TestMetricsPush(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	var method, path string
+	var body []byte
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+
+	cmd, err := newRootCmd("", defaultRootOptions())
+	require.NoError(t, err)
+	cmd.SetOut(bytes.NewBufferString(""))
+	cmd.SetArgs([]string{
+		"targets",
+		"--metrics-push-url", gateway.URL,
+		"--metrics-job", "nightly",
+		"--tuf-path", filepath.Join(tempDir, "tuf"),
+		"--tuf-root", "dev",
+		"--metadata", server.URL + "/metadata",
+		"--source", server.URL + "/targets",
+		"--destination", OCIPrefix + filepath.Join(tempDir, "targets"),
+	})
+	require.NoError(t, cmd.Execute())
+
+	assert.Equal(t, http.MethodPut, method)
+	// grouped by job, the Pushgateway labels the series with it
+	assert.Equal(t, "/metrics/job/go_tuf_mirror/mirror_job/nightly", path)
+	// pushed in the protobuf exposition format, label names and values appear verbatim
+	assert.Contains(t, string(body), "go_tuf_mirror_last_success_timestamp_seconds")
+	assert.Contains(t, string(body), "targets")
+	assert.NotContains(t, string(body), "mirror_job")
+	assert.NotContains(t, string(body), "go_goroutines")
+}
+
+func TestLogFormat(t *testing.T) {
+	tempDir := t.TempDir()
+	defaultLogger := slog.Default()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	stdout := bytes.NewBufferString("")
+	stderr := bytes.NewBufferString("")
+	cmd, err := newRootCmd("", defaultRootOptions())
+	require.NoError(t, err)
+	cmd.SetOut(stdout)
+	cmd.SetErr(stderr)
+	cmd.SetArgs([]string{
+		"metadata",
+		"--log-level", "debug",
+		"--log-format", "json",
+		"--tuf-path", filepath.Join(tempDir, "tuf"),
+		"--tuf-root", "dev",
+		"--source", server.URL + "/metadata",
+		"--destination", OCIPrefix + filepath.Join(tempDir, "metadata"),
+	})
+	require.NoError(t, cmd.ExecuteContext(context.Background()))
+	// the logger is carried by the context, the process-wide default is left alone
+	assert.Same(t, defaultLogger, slog.Default())
+
+	// results are written to stdout, logs to stderr
+	assert.Contains(t, stdout.String(), "Metadata manifest layout saved to")
+	assert.NotContains(t, stdout.String(), "Mirroring")
+
+	messages := map[string]map[string]any{}
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		entry := map[string]any{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		messages[entry["msg"].(string)] = entry
+	}
+	require.Contains(t, messages, "Mirroring TUF metadata")
+	assert.Equal(t, "INFO", messages["Mirroring TUF metadata"]["level"])
+	require.Contains(t, messages, "trusted root")
+	assert.Equal(t, "DEBUG", messages["trusted root"]["level"])
+	assert.EqualValues(t, 2, messages["trusted root"]["version"])
+}
+
+func TestInvalidLogLevel(t *testing.T) {
+	cmd, err := newRootCmd("", defaultRootOptions())
+	require.NoError(t, err)
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs([]string{"version", "--log-level", "verbose"})
+	require.ErrorContains(t, cmd.Execute(), "invalid log level")
+}
+
+func TestMirrorFlags(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []string
+		expected bool
+	}{
+		{name: "metadata", args: []string{"metadata"}, expected: true},
+		{name: "targets", args: []string{"targets"}, expected: true},
+		{name: "all", args: []string{"all"}, expected: true},
+		{name: "root", args: []string{"root"}, expected: true},
+		{name: "version", args: []string{"version"}},
+		{name: "diff", args: []string{"diff"}},
+		{name: "inspect", args: []string{"inspect"}},
+		{name: "verify", args: []string{"verify"}},
+		{name: "cache list", args: []string{"cache", "list"}},
+	}
+	flags := []string{"lock-timeout", "lock-stale-after", "target-tag", "delegated-tag", "signing-key", "max-timestamp-age", "min-validity", "accept-root-rotation", "single-layout", "cache-control"}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd, err := newRootCmd("", defaultRootOptions())
+			require.NoError(t, err)
+			sub, _, err := cmd.Find(tc.args)
+			require.NoError(t, err)
+			for _, flag := range flags {
+				assert.Equal(t, tc.expected, sub.Flag(flag) != nil, flag)
+			}
+		})
+	}
+}
+
+func TestEphemeralCache(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+	args := []string{"metadata", "--ephemeral-cache", "--tuf-root", "dev", "--source", server.URL + "/metadata", "--destination", OCIPrefix + filepath.Join(tempDir, "metadata")}
+
+	o := defaultRootOptions()
+	cmd, err := newRootCmd("", o)
+	require.NoError(t, err)
+	cmd.SetOut(io.Discard)
+	cmd.SetArgs(args)
+	ctx, _ := testLogContext(t)
+	require.NoError(t, cmd.ExecuteContext(ctx))
+	require.NotEmpty(t, o.ephemeralDir)
+	assert.Equal(t, tempDir, filepath.Dir(o.ephemeralDir))
+	entries, err := os.ReadDir(o.ephemeralDir)
+	require.NoError(t, err)
+	assert.NotEmpty(t, entries)
+
+	dir := o.ephemeralDir
+	require.NoError(t, o.removeEphemeralCache())
+	assert.NoDirExists(t, dir)
+
+	o = defaultRootOptions()
+	cmd, err = newRootCmd("", o)
+	require.NoError(t, err)
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	cmd.SetArgs(append(args, "--tuf-path", filepath.Join(tempDir, "tuf")))
+	err = cmd.ExecuteContext(ctx)
+	require.Error(t, err)
+	assert.Equal(t, ExitInvalidArgument, ExitCode(err), err.Error())
+	assert.Empty(t, o.ephemeralDir)
+}
+
+// testLogContext returns a context carrying a text logger writing to the returned buffer.
+func testLogContext(t *testing.T) (context.Context, *bytes.Buffer) {
+	t.Helper()
+	b := bytes.NewBufferString("")
+	logger, err := logging.New(b, "info", logging.TextFormat)
+	require.NoError(t, err)
+	return logging.WithLogger(context.Background(), logger), b
+}
+
+func TestLockDestination(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	reg := httptest.NewServer(registry.New(registry.WithReferrersSupport(false)))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	repo := "localhost:" + url.Port() + "/test/metadata"
+
+	run := func(destination string, now func() time.Time) error {
+		opts := defaultRootOptions()
+		opts.tufPath = filepath.Join(tempDir, "tuf")
+		opts.tufRoot = "dev"
+		opts.lockOptions.Timeout = 0
+		opts.lockOptions.Now = now
+		cmd, err := newMetadataCmd(opts)
+		require.NoError(t, err)
+		cmd.SetOut(io.Discard)
+		_ = cmd.PersistentFlags().Set("source", server.URL+"/metadata")
+		_ = cmd.PersistentFlags().Set("destination", destination)
+		ctx, _ := testLogContext(t)
+		return cmd.ExecuteContext(ctx)
+	}
+	// a clock past the expiry of every lease taken below
+	later := func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+	// a run holding the registry lock blocks other runs until released
+	lk, err := lock.AcquireRegistry(context.Background(), repo, lock.Options{StaleAfter: time.Minute})
+	require.NoError(t, err)
+	err = run(RegistryPrefix+repo, nil)
+	require.ErrorIs(t, err, lock.ErrTimeout)
+	require.NoError(t, lk.Release(context.Background()))
+	require.NoError(t, run(RegistryPrefix+repo, nil))
+
+	// a lock left behind by a run that died is taken over once stale
+	layout := filepath.Join(tempDir, "metadata")
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = lock.AcquireFile(ctx, layout, lock.Options{StaleAfter: time.Minute})
+	require.NoError(t, err)
+	cancel()
+	err = run(OCIPrefix+layout, nil)
+	require.ErrorIs(t, err, lock.ErrTimeout)
+	require.NoError(t, run(OCIPrefix+layout, later))
+	assert.NoFileExists(t, layout+lock.FileSuffix)
+
+	// stale bucket leases are replaced only if still the lease that was read
+	store := test.NewS3Server(t)
+	b, err := bucket.New("s3://tuf/metadata")
+	require.NoError(t, err)
+	ctx, cancel = context.WithCancel(context.Background())
+	_, err = lock.AcquireBucket(ctx, b, lock.Options{StaleAfter: time.Minute})
+	require.NoError(t, err)
+	cancel()
+	err = run("s3://tuf/metadata", nil)
+	require.ErrorIs(t, err, lock.ErrTimeout)
+	require.NoError(t, run("s3://tuf/metadata", later))
+	_, ok := store.Object("tuf/metadata/" + lock.BucketObject)
+	assert.False(t, ok)
+}
+
+func TestLockLost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metadata")
+	logs := &syncBuffer{}
+	logger, err := logging.New(logs, "info", logging.TextFormat)
+	require.NoError(t, err)
+	ctx := logging.WithLogger(context.Background(), logger)
+
+	// a run stalled past the expiry of its lease loses it to another run, and stops renewing
+	stalled, err := lock.AcquireFile(ctx, path, lock.Options{StaleAfter: 30 * time.Millisecond})
+	require.NoError(t, err)
+	later := func() time.Time { return time.Now().Add(time.Minute) }
+	taken, err := lock.AcquireFile(context.Background(), path, lock.Options{StaleAfter: time.Minute, Now: later})
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), "Lost lock to another run")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// releasing a lost lock leaves the new holder's lock in place
+	require.NoError(t, stalled.Release(context.Background()))
+	assert.FileExists(t, path+lock.FileSuffix)
+	require.NoError(t, taken.Release(context.Background()))
+	assert.NoFileExists(t, path+lock.FileSuffix)
+}
+
+// syncBuffer is a buffer safe to write from a goroutine while it's read.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
@@ -0,0 +1,82 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	attestmirror "github.com/docker/attest/mirror"
+	"github.com/docker/go-tuf-mirror/pkg/mirror"
+	"github.com/spf13/cobra"
+)
+
+type rootChainOptions struct {
+	targets      string
+	source       string
+	destinations []string
+	rootOptions  *rootOptions
+}
+
+func defaultRootChainOptions(opts *rootOptions) *rootChainOptions {
+	return &rootChainOptions{
+		rootOptions: opts,
+	}
+}
+
+func newRootChainCmd(opts *rootOptions) (*cobra.Command, error) {
+	o := defaultRootChainOptions(opts)
+
+	cmd := &cobra.Command{
+		Use:          "root",
+		Short:        "Publish every TUF root version as a root chain artifact, to pin with --root-digest",
+		SilenceUsage: false,
+		RunE:         o.run,
+	}
+	cmd.PersistentFlags().StringVarP((&o.targets), "targets", "m", attestmirror.DefaultTargetsURL, fmt.Sprintf("Source targets location %s", schemes(mirror.SourceSchemes())))
+	cmd.PersistentFlags().StringVarP(&o.source, "source", "s", attestmirror.DefaultMetadataURL, fmt.Sprintf("Source metadata location %s", schemes(mirror.SourceSchemes())))
+	cmd.PersistentFlags().StringArrayVarP(&o.destinations, "destination", "d", nil, fmt.Sprintf("Destination root chain location %s, may be repeated", schemes(mirror.DestinationSchemes())))
+	o.rootOptions.addMirrorFlags(cmd.Flags())
+
+	err := markFlagsRequired(cmd.PersistentFlags(), "source", "destination")
+	if err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+func (o *rootChainOptions) run(cmd *cobra.Command, args []string) error {
+	source, err := mirror.NewSource(o.source, o.targets)
+	if err != nil {
+		return err
+	}
+	destinations, err := o.rootOptions.destinations(o.destinations)
+	if err != nil {
+		return err
+	}
+	cfg, err := o.rootOptions.config(cmd)
+	if err != nil {
+		return err
+	}
+	cfg.Source = source
+	cfg.RootDestinations = destinations
+	result, err := o.rootOptions.mirror(cmd, *cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Root chain digest: %s\n", result.RootChain)
+	return nil
+}
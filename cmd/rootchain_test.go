@@ -0,0 +1,114 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/attest/tuf"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRootChainCmd(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	reg := httptest.NewServer(registry.New(registry.WithReferrersSupport(false)))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	repository := "localhost:" + url.Port() + "/test/metadata"
+
+	b := bytes.NewBufferString("")
+	opts := defaultRootOptions()
+	opts.tufPath = filepath.Join(tempDir, "tuf")
+	opts.tufRoot = "dev"
+	cmd, err := newRootChainCmd(opts)
+	require.NoError(t, err)
+	cmd.SetOut(b)
+	cmd.SetArgs([]string{"--source", server.URL + "/metadata", "--destination", RegistryPrefix + repository})
+	ctx, logs := testLogContext(t)
+	require.NoError(t, cmd.ExecuteContext(ctx))
+	assert.Contains(t, logs.String(), "msg=\"Mirroring TUF root chain\"")
+
+	ref, err := name.ParseReference(repository + ":root-chain")
+	require.NoError(t, err)
+	img, err := remote.Image(ref)
+	require.NoError(t, err)
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	out, err := io.ReadAll(b)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("Root chain manifest pushed to %s\nRoot chain digest: %s\n", ref.Name(), digest), string(out))
+
+	// every root version, oldest first
+	roots, err := mirrortuf.RootChain(img)
+	require.NoError(t, err)
+	require.Len(t, roots, 2)
+	dev, err := tuf.GetEmbeddedRoot("dev")
+	require.NoError(t, err)
+	assert.JSONEq(t, string(dev.Data), string(roots[0]))
+	root, err := mirrortuf.VerifyRootChain(roots)
+	require.NoError(t, err)
+	latest, err := os.ReadFile(filepath.Join("..", "internal", "test", "testdata", "test-repo", "metadata", "2.root.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, string(latest), string(root))
+
+	mirrorMetadata := func(rootDigest string) error {
+		opts := defaultRootOptions()
+		opts.tufPath = filepath.Join(tempDir, "pinned")
+		opts.rootDigest = rootDigest
+		cmd, err := newMetadataCmd(opts)
+		require.NoError(t, err)
+		cmd.SetOut(io.Discard)
+		cmd.SetArgs([]string{"--source", server.URL + "/metadata", "--destination", OCIPrefix + filepath.Join(tempDir, "metadata")})
+		ctx, _ := testLogContext(t)
+		return cmd.ExecuteContext(ctx)
+	}
+
+	// the pinned root chain bootstraps trust without an embedded root
+	require.NoError(t, mirrorMetadata(RegistryPrefix+repository+"@"+digest.String()))
+
+	// another artifact, or a tag, isn't trusted
+	other := strings.Replace(digest.String(), digest.Hex[:8], "00000000", 1)
+	err = mirrorMetadata(repository + "@" + other)
+	assert.Equal(t, ExitSourceUnreachable, ExitCode(err), err.Error())
+	err = mirrorMetadata(repository + ":root-chain")
+	assert.Equal(t, ExitInvalidArgument, ExitCode(err), err.Error())
+
+	opts = defaultRootOptions()
+	opts.tufRoot = "dev"
+	opts.rootDigest = repository + "@" + digest.String()
+	_, err = opts.root(ctx)
+	assert.Equal(t, ExitInvalidArgument, ExitCode(err), err.Error())
+}
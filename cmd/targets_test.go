@@ -17,7 +17,6 @@
 package cmd
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"net/http"
@@ -26,10 +25,19 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
 
+	"github.com/docker/attest/oci"
+	"github.com/docker/attest/tuf"
+	"github.com/docker/go-tuf-mirror/internal/journal"
+	"github.com/docker/go-tuf-mirror/internal/lock"
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -68,12 +76,11 @@ func TestTargetsCmd(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			expectedOutput := fmt.Sprintf("Mirroring TUF targets %s to %s\n", tc.source, tc.destination)
-
 			opts := defaultRootOptions()
 			opts.full = tc.full
 			opts.tufRoot = "dev"
-			cmd := newTargetsCmd(opts)
+			cmd, err := newTargetsCmd(opts)
+			require.NoError(t, err)
 			if cmd == nil {
 				t.Fatal("newTargetsCmd returned nil")
 			}
@@ -83,13 +90,11 @@ func TestTargetsCmd(t *testing.T) {
 			_ = cmd.PersistentFlags().Set("destination", tc.destination)
 			_ = cmd.PersistentFlags().Set("metadata", tc.metadata)
 
-			err := cmd.Execute()
-			require.NoError(t, err)
-
-			reader := bufio.NewReader(b)
-			out, err := reader.ReadString('\n')
+			ctx, logs := testLogContext(t)
+			err = cmd.ExecuteContext(ctx)
 			require.NoError(t, err)
-			assert.Equal(t, expectedOutput, out)
+			assert.Contains(t, logs.String(), fmt.Sprintf("msg=\"Mirroring TUF targets\" source=%s destination=%s\n", tc.source, tc.destination))
+			assert.Contains(t, b.String(), "Target manifest ")
 
 			// check that index was saved to oci layout
 			if strings.HasPrefix(tc.destination, OCIPrefix) {
@@ -113,3 +118,293 @@ func TestTargetsCmd(t *testing.T) {
 		})
 	}
 }
+
+func TestTargetsResume(t *testing.T) {
+	tempDir := t.TempDir()
+	tufPath := filepath.Join(tempDir, "tuf")
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	// fail manifest pushes after the first two, as if the run was interrupted
+	var failing atomic.Bool
+	var pushed atomic.Int32
+	failing.Store(true)
+	handler := registry.New(registry.WithReferrersSupport(false))
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") && !strings.HasSuffix(r.URL.Path, lock.Tag) {
+			if failing.Load() && pushed.Load() >= 2 {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			pushed.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	destination := RegistryPrefix + "localhost:" + url.Port() + "/test/targets"
+
+	run := func(destination string, referrers bool) (string, error) {
+		opts := defaultRootOptions()
+		opts.tufPath = tufPath
+		opts.tufRoot = "dev"
+		cmd, err := newTargetsCmd(opts)
+		require.NoError(t, err)
+		b := bytes.NewBufferString("")
+		cmd.SetOut(b)
+		_ = cmd.PersistentFlags().Set("source", server.URL+"/targets")
+		_ = cmd.PersistentFlags().Set("destination", destination)
+		_ = cmd.PersistentFlags().Set("metadata", server.URL+"/metadata")
+		if referrers {
+			_ = cmd.PersistentFlags().Set("referrers", "true")
+		}
+		ctx, _ := testLogContext(t)
+		err = cmd.ExecuteContext(ctx)
+		return b.String(), err
+	}
+
+	out, err := run(destination, false)
+	require.Error(t, err)
+	assert.Equal(t, 2, strings.Count(out, "Target manifest pushed to"))
+	journals, err := os.ReadDir(filepath.Join(tufPath, journal.Dir))
+	require.NoError(t, err)
+	assert.Len(t, journals, 1)
+
+	// the second run only pushes the remaining targets
+	failing.Store(false)
+	out, err = run(destination, false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(out, "Target manifest already pushed to"))
+	assert.Equal(t, TopLevelTargetsLength-2, strings.Count(out, "Target manifest pushed to"))
+	// each target once, then the snapshot index tagged for the snapshot version and latest
+	assert.EqualValues(t, TopLevelTargetsLength+2, pushed.Load())
+
+	// the journal is removed once the run completes
+	journals, err = os.ReadDir(filepath.Join(tufPath, journal.Dir))
+	require.NoError(t, err)
+	assert.Empty(t, journals)
+
+	// targets written without referrers aren't skipped once referrers are enabled
+	failing.Store(true)
+	pushed.Store(0)
+	_, err = run(destination+"-referrers", false)
+	require.Error(t, err)
+	failing.Store(false)
+	out, err = run(destination+"-referrers", true)
+	require.NoError(t, err)
+	assert.Zero(t, strings.Count(out, "Target manifest already pushed to"))
+	assert.Equal(t, TopLevelTargetsLength, strings.Count(out, "Target manifest pushed to"))
+}
+
+func TestTargetsDestinationFailure(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	// a registry rejecting every target push
+	handler := registry.New(registry.WithReferrersSupport(false))
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/manifests/") && !strings.HasSuffix(r.URL.Path, lock.Tag) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	failing := RegistryPrefix + "localhost:" + url.Port() + "/test/targets"
+
+	opts := defaultRootOptions()
+	opts.tufPath = filepath.Join(tempDir, "tuf")
+	opts.tufRoot = "dev"
+	cmd, err := newTargetsCmd(opts)
+	require.NoError(t, err)
+	b := bytes.NewBufferString("")
+	cmd.SetOut(b)
+	cmd.SetArgs([]string{
+		"--metadata", server.URL + "/metadata",
+		"--source", server.URL + "/targets",
+		"--destination", failing,
+		"--destination", OCIPrefix + filepath.Join(tempDir, "targets"),
+	})
+	ctx, _ := testLogContext(t)
+	err = cmd.ExecuteContext(ctx)
+	require.ErrorContains(t, err, "failed to mirror to 1 of 2 destinations")
+	assert.ErrorContains(t, err, failing)
+	assert.ErrorIs(t, err, ErrDestinationAuth)
+	assert.Equal(t, ExitPartialPush, ExitCode(err))
+
+	// the failing destination doesn't stop the others
+	assert.Equal(t, TopLevelTargetsLength, strings.Count(b.String(), "Target manifest layout saved to"))
+}
+
+func TestTargetsSnapshotIndex(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	reg := httptest.NewServer(registry.New(registry.WithReferrersSupport(false)))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	repo := "localhost:" + url.Port() + "/test/targets"
+
+	opts := defaultRootOptions()
+	opts.tufPath = filepath.Join(tempDir, "tuf")
+	opts.tufRoot = "dev"
+	opts.full = true
+	cmd, err := newTargetsCmd(opts)
+	require.NoError(t, err)
+	b := bytes.NewBufferString("")
+	cmd.SetOut(b)
+	cmd.SetArgs([]string{
+		"--metadata", server.URL + "/metadata",
+		"--source", server.URL + "/targets",
+		"--destination", RegistryPrefix + repo,
+	})
+	ctx, _ := testLogContext(t)
+	require.NoError(t, cmd.ExecuteContext(ctx))
+	assert.Contains(t, b.String(), "Snapshot index pushed to "+repo+":snapshot-7\n")
+	assert.Contains(t, b.String(), "Snapshot index pushed to "+repo+":latest\n")
+
+	for _, tag := range []string{"snapshot-7", "latest"} {
+		ref, err := name.ParseReference(repo + ":" + tag)
+		require.NoError(t, err)
+		idx, err := remote.Index(ref)
+		require.NoError(t, err)
+		mf, err := idx.IndexManifest()
+		require.NoError(t, err)
+		assert.Equal(t, "7", mf.Annotations[mirrortuf.SnapshotVersionAnnotation])
+		require.Len(t, mf.Manifests, TopLevelTargetsLength+len(DelegatedTargetNames))
+		refs := map[string]map[string]string{}
+		for _, desc := range mf.Manifests {
+			refs[desc.Annotations[oci.OCIReferenceTarget]] = desc.Annotations
+		}
+		require.Contains(t, refs, targetFile)
+		assert.Equal(t, "test.txt", refs[targetFile][mirrortuf.TargetAnnotation])
+		assert.Equal(t, "targets", refs[targetFile][tags.RoleAnnotation])
+		require.Contains(t, refs, DelegatedTargetNames[0])
+		assert.Equal(t, DelegatedTargetNames[0], refs[DelegatedTargetNames[0]][tags.RoleAnnotation])
+	}
+	// latest is tagged, not pushed again
+	snapshotRef, err := name.ParseReference(repo + ":snapshot-7")
+	require.NoError(t, err)
+	snapshot, err := remote.Head(snapshotRef)
+	require.NoError(t, err)
+	latestRef, err := name.ParseReference(repo + ":latest")
+	require.NoError(t, err)
+	latest, err := remote.Head(latestRef)
+	require.NoError(t, err)
+	assert.Equal(t, snapshot.Digest, latest.Digest)
+
+	// layouts link latest to the snapshot layout rather than copying it
+	layoutDir := filepath.Join(tempDir, "targets")
+	for range 2 {
+		cmd, err = newTargetsCmd(opts)
+		require.NoError(t, err)
+		b.Reset()
+		cmd.SetOut(b)
+		cmd.SetArgs([]string{
+			"--metadata", server.URL + "/metadata",
+			"--source", server.URL + "/targets",
+			"--destination", OCIPrefix + layoutDir,
+		})
+		require.NoError(t, cmd.ExecuteContext(ctx))
+		assert.Contains(t, b.String(), "Snapshot index layout saved to "+filepath.Join(layoutDir, "latest")+"\n")
+		link, err := os.Readlink(filepath.Join(layoutDir, "latest"))
+		require.NoError(t, err)
+		assert.Equal(t, "snapshot-7", link)
+	}
+	idx, err := layout.ImageIndexFromPath(filepath.Join(layoutDir, "latest"))
+	require.NoError(t, err)
+	mf, err := idx.IndexManifest()
+	require.NoError(t, err)
+	assert.Len(t, mf.Manifests, TopLevelTargetsLength+len(DelegatedTargetNames))
+}
+
+func TestTargetsReferrers(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	reg := httptest.NewServer(registry.New(registry.WithReferrersSupport(false)))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+	repo := "localhost:" + url.Port() + "/test/targets"
+	layoutPath := filepath.Join(tempDir, "targets")
+
+	opts := defaultRootOptions()
+	opts.tufPath = filepath.Join(tempDir, "tuf")
+	opts.tufRoot = "dev"
+	opts.full = true
+	cmd, err := newTargetsCmd(opts)
+	require.NoError(t, err)
+	cmd.SetOut(bytes.NewBufferString(""))
+	cmd.SetArgs([]string{
+		"--metadata", server.URL + "/metadata",
+		"--source", server.URL + "/targets",
+		"--destination", RegistryPrefix + repo,
+		"--destination", OCIPrefix + layoutPath,
+		"--referrers",
+	})
+	ctx, _ := testLogContext(t)
+	require.NoError(t, cmd.ExecuteContext(ctx))
+
+	// the registry has no referrers API, so referrers are found through the referrers tag schema
+	r, err := name.NewRepository(repo)
+	require.NoError(t, err)
+	referrers := func(subject v1.Hash, files int) {
+		idx, err := remote.Referrers(r.Digest(subject.String()))
+		require.NoError(t, err)
+		mf, err := idx.IndexManifest()
+		require.NoError(t, err)
+		require.Len(t, mf.Manifests, 1)
+		assert.Equal(t, mirrortuf.MetadataArtifactType, mf.Manifests[0].ArtifactType)
+		img, err := remote.Image(r.Digest(mf.Manifests[0].Digest.String()))
+		require.NoError(t, err)
+		imf, err := img.Manifest()
+		require.NoError(t, err)
+		require.Len(t, imf.Layers, files)
+		assert.Equal(t, "8.targets.json", imf.Layers[0].Annotations[tuf.TUFFileNameAnnotation])
+	}
+	desc, err := remote.Get(r.Tag(targetFile))
+	require.NoError(t, err)
+	referrers(desc.Digest, 1)
+
+	desc, err = remote.Get(r.Tag(DelegatedTargetNames[0]))
+	require.NoError(t, err)
+	delegated, err := desc.ImageIndex()
+	require.NoError(t, err)
+	dmf, err := delegated.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, dmf.Manifests, DelegatedTargetsFiles)
+	for _, m := range dmf.Manifests {
+		// the delegated role metadata follows the top-level targets metadata delegating to it
+		referrers(m.Digest, 2)
+	}
+
+	// OCI layouts hold referrers next to the target image
+	idx, err := layout.ImageIndexFromPath(filepath.Join(layoutPath, targetFile))
+	require.NoError(t, err)
+	mf, err := idx.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, mf.Manifests, 2)
+	img, err := idx.Image(mf.Manifests[1].Digest)
+	require.NoError(t, err)
+	imf, err := img.Manifest()
+	require.NoError(t, err)
+	require.NotNil(t, imf.Subject)
+	assert.Equal(t, mf.Manifests[0].Digest, imf.Subject.Digest)
+
+	// inspect ignores referrers and their fallback tags
+	found, err := mirrortuf.ListRegistryTargets(ctx, repo)
+	require.NoError(t, err)
+	assert.Len(t, found, TopLevelTargetsLength+DelegatedTargetsFiles)
+}
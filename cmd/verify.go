@@ -0,0 +1,228 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/docker/go-tuf-mirror/internal/signing"
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	"github.com/docker/go-tuf-mirror/pkg/mirror"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"github.com/spf13/cobra"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+type verifyOptions struct {
+	key         string
+	metadata    string
+	targets     string
+	rootOptions *rootOptions
+}
+
+func defaultVerifyOptions(opts *rootOptions) *verifyOptions {
+	return &verifyOptions{
+		rootOptions: opts,
+	}
+}
+
+func newVerifyCmd(opts *rootOptions) (*cobra.Command, error) {
+	o := defaultVerifyOptions(opts)
+
+	cmd := &cobra.Command{
+		Use:          "verify",
+		Short:        "Verify the signatures of mirrored metadata and target manifests",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE:         o.run,
+	}
+	cmd.Flags().StringVar(&o.key, "key", "", "PEM encoded ECDSA or ed25519 public key of the mirror signing key")
+	cmd.Flags().StringVar(&o.metadata, "metadata", "", fmt.Sprintf("Mirrored metadata location %s", schemes(mirror.DestinationSchemes())))
+	cmd.Flags().StringVar(&o.targets, "targets", "", fmt.Sprintf("Mirrored targets location %s", schemes(mirror.DestinationSchemes())))
+	err := markFlagsRequired(cmd.Flags(), "key")
+	if err != nil {
+		return nil, err
+	}
+	cmd.MarkFlagsOneRequired("metadata", "targets")
+	return cmd, nil
+}
+
+func (o *verifyOptions) run(cmd *cobra.Command, args []string) error {
+	verifier, err := signing.LoadVerifier(o.key)
+	if err != nil {
+		return invalidArgumentf("%w", err)
+	}
+	ctx := cmd.Context()
+	var verified, failed int
+
+	if o.metadata != "" {
+		d, err := mirror.NewDestination(o.metadata)
+		if err != nil {
+			return err
+		}
+		defer closeLocation(d)
+		store, err := d.Signatures()
+		if err != nil {
+			return err
+		}
+		tags, err := o.metadataTags(ctx)
+		if err != nil {
+			return err
+		}
+		v, f, err := verifyTags(ctx, cmd.OutOrStdout(), verifier, store, o.metadata, tags)
+		if err != nil {
+			return err
+		}
+		verified, failed = verified+v, failed+f
+	}
+
+	if o.targets != "" {
+		d, err := mirror.NewDestination(o.targets)
+		if err != nil {
+			return err
+		}
+		defer closeLocation(d)
+		store, err := d.Signatures()
+		if err != nil {
+			return err
+		}
+		targets, err := d.Targets(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list targets in %s: %w", o.targets, err)
+		}
+		seen := map[string]bool{}
+		var targetTags []string
+		for _, t := range targets {
+			if !seen[t.Tag] {
+				seen[t.Tag] = true
+				targetTags = append(targetTags, t.Tag)
+			}
+		}
+		sort.Strings(targetTags)
+		v, f, err := verifyTags(ctx, cmd.OutOrStdout(), verifier, store, o.targets, targetTags)
+		if err != nil {
+			return err
+		}
+		verified, failed = verified+v, failed+f
+
+		// the snapshot index referencing the whole target set is signed itself
+		subject, err := store.Index(ctx, tags.LatestTag)
+		if err != nil {
+			return err
+		}
+		ok, err := verifySubject(ctx, cmd.OutOrStdout(), verifier, store, fmt.Sprintf("%s (%s)", o.targets, tags.LatestTag), tags.LatestTag, subject)
+		if err != nil {
+			return err
+		}
+		if ok {
+			verified++
+		} else {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d manifests failed verification", signing.ErrNoSignature, failed, verified+failed)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Verified %d manifests\n", verified)
+	return nil
+}
+
+// metadataTags returns the tags of the metadata image (the empty tag) and each delegated metadata image,
+// tagged by the scheme recorded on the metadata image.
+func (o *verifyOptions) metadataTags(ctx context.Context) ([]string, error) {
+	f, err := mirror.NewFetcher(o.metadata)
+	if err != nil {
+		return nil, err
+	}
+	defer closeLocation(f)
+	repo, err := mirrortuf.LoadRepository(ctx, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata from %s: %w", o.metadata, err)
+	}
+	tags := []string{""}
+	roles := make([]string, 0, len(repo.Targets))
+	for role := range repo.Targets {
+		if role != metadata.TARGETS {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	imageFetcher, ok := f.(*mirrortuf.ImageFetcher)
+	if !ok {
+		return nil, invalidArgumentf("metadata location not implemented: %s", o.metadata)
+	}
+	scheme := imageFetcher.Scheme()
+	for _, role := range roles {
+		tag, err := scheme.Delegated(role)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// verifyTags verifies each manifest tagged tags in store, reporting the results to w.
+func verifyTags(ctx context.Context, w io.Writer, verifier dsse.Verifier, store signing.Store, location string, tags []string) (verified int, failed int, err error) {
+	for _, tag := range tags {
+		name := location
+		if tag != "" {
+			name = fmt.Sprintf("%s (%s)", location, tag)
+		}
+		subjects, err := store.Subjects(ctx, tag)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, subject := range subjects {
+			ok, err := verifySubject(ctx, w, verifier, store, name, tag, subject)
+			if err != nil {
+				return 0, 0, err
+			}
+			if ok {
+				verified++
+			} else {
+				failed++
+			}
+		}
+	}
+	return verified, failed, nil
+}
+
+// verifySubject verifies the manifest subject tagged tag in store, reporting the result to w as name.
+func verifySubject(ctx context.Context, w io.Writer, verifier dsse.Verifier, store signing.Store, name, tag string, subject v1.Descriptor) (bool, error) {
+	signatures, err := store.Signatures(ctx, tag, subject)
+	if err != nil {
+		return false, err
+	}
+	err = signing.Verify(ctx, verifier, subject, signatures)
+	if errors.Is(err, signing.ErrNoSignature) {
+		fmt.Fprintf(w, "FAILED %s@%s: %v\n", name, subject.Digest, err)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	fmt.Fprintf(w, "Verified %s@%s\n", name, subject.Digest)
+	return true, nil
+}
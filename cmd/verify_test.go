@@ -0,0 +1,176 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/attest/attestation"
+	"github.com/docker/go-tuf-mirror/internal/signing"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyPair writes the PEM encoded private and public keys of key to dir.
+func writeKeyPair(t *testing.T, dir string, key crypto.Signer) (string, string) {
+	priv, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	privPath, pubPath := filepath.Join(dir, "key.pem"), filepath.Join(dir, "key.pub")
+	require.NoError(t, os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: priv}), 0o600))
+	require.NoError(t, os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}), 0o600))
+	return privPath, pubPath
+}
+
+func TestVerify(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	reg := httptest.NewServer(registry.New(registry.WithReferrersSupport(false)))
+	defer reg.Close()
+	url, err := url.Parse(reg.URL)
+	require.NoError(t, err)
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, otherPub := writeKeyPair(t, t.TempDir(), otherKey)
+
+	testCases := []struct {
+		name string
+		key  crypto.Signer
+		dest string
+	}{
+		{"ecdsa to oci", ecdsaKey, "oci"},
+		{"ed25519 to oci", ed25519Key, "oci"},
+		{"ecdsa to registry", ecdsaKey, "registry"},
+		{"ed25519 to registry", ed25519Key, "registry"},
+		{"ecdsa to archive", ecdsaKey, "archive"},
+		{"ecdsa to single layout", ecdsaKey, "single"},
+	}
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			dstMeta := OCIPrefix + filepath.Join(tempDir, "metadata")
+			dstTargets := OCIPrefix + filepath.Join(tempDir, "targets")
+			switch tc.dest {
+			case "registry":
+				repo := fmt.Sprintf("localhost:%s/test/verify-%d", url.Port(), i)
+				dstMeta = RegistryPrefix + repo + "-metadata:latest"
+				dstTargets = RegistryPrefix + repo + "-targets"
+			case "archive":
+				dstMeta = OCIArchivePrefix + filepath.Join(tempDir, "metadata.tar")
+				dstTargets = DockerArchivePrefix + filepath.Join(tempDir, "targets.tar")
+			}
+			priv, pub := writeKeyPair(t, tempDir, tc.key)
+
+			opts := defaultRootOptions()
+			opts.tufPath = filepath.Join(tempDir, "tuf")
+			opts.tufRoot = "dev"
+			opts.full = true
+			opts.signingKey = priv
+			opts.singleLayout = tc.dest == "single"
+			cmd, err := newAllCmd(opts)
+			require.NoError(t, err)
+			cmd.SetOut(bytes.NewBufferString(""))
+			cmd.SetArgs([]string{
+				"--source-metadata", server.URL + "/metadata",
+				"--source-targets", server.URL + "/targets",
+				"--dest-metadata", dstMeta,
+				"--dest-targets", dstTargets,
+			})
+			ctx, _ := testLogContext(t)
+			require.NoError(t, cmd.ExecuteContext(ctx))
+
+			verify := func(key string) (string, error) {
+				cmd, err := newVerifyCmd(defaultRootOptions())
+				if err != nil {
+					return "", err
+				}
+				b := bytes.NewBufferString("")
+				cmd.SetOut(b)
+				cmd.SetArgs([]string{"--key", key, "--metadata", dstMeta, "--targets", dstTargets})
+				err = cmd.ExecuteContext(ctx)
+				return b.String(), err
+			}
+
+			// the metadata image, each delegated metadata image, each target image and the snapshot index
+			manifests := 1 + len(DelegatedTargetNames) + TopLevelTargetsLength + DelegatedTargetsFiles + 1
+			out, err := verify(pub)
+			require.NoError(t, err)
+			assert.Contains(t, out, fmt.Sprintf("Verified %d manifests\n", manifests))
+			assert.Contains(t, out, "(latest)@sha256:")
+
+			out, err = verify(otherPub)
+			require.ErrorIs(t, err, signing.ErrNoSignature)
+			assert.Contains(t, out, "FAILED ")
+		})
+	}
+}
+
+func TestSignEd25519(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	priv, _ := writeKeyPair(t, t.TempDir(), key)
+	signer, err := signing.LoadSigner(context.Background(), priv)
+	require.NoError(t, err)
+
+	subject := v1.Descriptor{MediaType: types.OCIManifestSchema1, Digest: v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("0", 64)}, Size: 1}
+	img, err := signing.Sign(context.Background(), signer, subject)
+	require.NoError(t, err)
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+	rc, err := layers[0].Uncompressed()
+	require.NoError(t, err)
+	defer rc.Close()
+	env := &attestation.Envelope{}
+	require.NoError(t, json.NewDecoder(rc).Decode(env))
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	require.NoError(t, err)
+	sig, err := base64.StdEncoding.DecodeString(env.Signatures[0].Sig)
+	require.NoError(t, err)
+
+	// ed25519 signs the pre-authentication encoding itself, not a digest of it
+	assert.True(t, ed25519.Verify(key.Public().(ed25519.PublicKey), dsse.PAE(env.PayloadType, payload), sig))
+}
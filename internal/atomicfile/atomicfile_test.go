@@ -0,0 +1,71 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	testCases := []struct {
+		name     string
+		existing string
+	}{
+		{name: "new file"},
+		{name: "replaced file", existing: "old contents, longer than the new ones"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "records")
+			path := filepath.Join(dir, "record.json")
+			if tc.existing != "" {
+				require.NoError(t, os.MkdirAll(dir, 0o755))
+				require.NoError(t, os.WriteFile(path, []byte(tc.existing), 0o644))
+			}
+
+			require.NoError(t, Write(path, []byte("new")))
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, "new", string(data))
+			// no temporary file is left behind
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Len(t, entries, 1)
+		})
+	}
+}
+
+func TestWriteFailure(t *testing.T) {
+	// the directory can't be created under a file
+	parent := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(parent, nil, 0o644))
+	require.Error(t, Write(filepath.Join(parent, "record.json"), []byte("new")))
+
+	// a failed rename removes the temporary file
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "record.json"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "record.json", "child"), nil, 0o644))
+	require.Error(t, Write(filepath.Join(dir, "record.json"), []byte("new")))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,272 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package bucket
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/docker/go-tuf-mirror/internal/logging"
+	"github.com/docker/go-tuf-mirror/internal/tracing"
+)
+
+// Prefix is the scheme prefix of bucket locations, s3://<bucket>/<prefix>.
+const Prefix = "s3://"
+
+// defaultRegion is used when no region is configured, S3-compatible stores generally ignore it.
+const defaultRegion = "us-east-1"
+
+var (
+	// ErrNotFound is returned when an object doesn't exist.
+	ErrNotFound = errors.New("object not found")
+	// ErrExists is returned when an object written with PutOptions.IfNotExists already exists.
+	ErrExists = errors.New("object already exists")
+	// ErrModified is returned when an object written with PutOptions.IfMatch was replaced or deleted.
+	ErrModified = errors.New("object modified")
+	// ErrConfig is returned when the AWS configuration (credentials, region, endpoint) can't be loaded.
+	ErrConfig = errors.New("invalid AWS configuration")
+)
+
+// Bucket reads and writes objects under a prefix of an S3 bucket. Credentials, region and endpoint are
+// configured the same way as the AWS CLI, e.g. AWS_ENDPOINT_URL_S3 for an S3-compatible store, and loaded
+// on first use.
+type Bucket struct {
+	name   string
+	prefix string
+
+	mu     sync.Mutex
+	client *s3.Client
+}
+
+// PutOptions configures an object written with Put.
+type PutOptions struct {
+	ContentType  string
+	CacheControl string
+	// IfNotExists fails the write with ErrExists if the object already exists.
+	IfNotExists bool
+	// IfMatch fails the write with ErrModified unless the object still has this ETag, as returned by Read.
+	IfMatch string
+}
+
+// Object is an object listed under a directory of the bucket.
+type Object struct {
+	// Name is the object key relative to the bucket prefix.
+	Name string
+	Size int64
+}
+
+// Parse returns the bucket name and key prefix of an s3://<bucket>/<prefix> location.
+func Parse(location string) (string, string, error) {
+	rest, ok := strings.CutPrefix(location, Prefix)
+	if !ok {
+		return "", "", fmt.Errorf("not a bucket location: %s", location)
+	}
+	name, prefix, _ := strings.Cut(rest, "/")
+	if name == "" {
+		return "", "", fmt.Errorf("missing bucket name: %s", location)
+	}
+	return name, strings.Trim(prefix, "/"), nil
+}
+
+// New returns the bucket at an s3://<bucket>/<prefix> location.
+func New(location string) (*Bucket, error) {
+	name, prefix, err := Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	return &Bucket{name: name, prefix: prefix}, nil
+}
+
+// s3Client returns the client of the bucket, loading the AWS configuration with ctx on first use.
+func (b *Bucket) s3Client(ctx context.Context) (*s3.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.client != nil {
+		return b.client, nil
+	}
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+	b.client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+		// S3-compatible stores behind a custom endpoint rarely support virtual-hosted buckets
+		o.UsePathStyle = o.BaseEndpoint != nil
+		o.HTTPClient = &http.Client{Transport: tracing.Transport(logging.Transport(http.DefaultTransport))}
+	})
+	return b.client, nil
+}
+
+func (b *Bucket) String() string {
+	return Prefix + path.Join(b.name, b.prefix)
+}
+
+// key returns the object key of name, relative to the bucket prefix.
+func (b *Bucket) key(name string) string {
+	return path.Join(b.prefix, name)
+}
+
+// Put writes data to the object name.
+func (b *Bucket) Put(ctx context.Context, name string, data []byte, opts PutOptions) error {
+	client, err := b.s3Client(ctx)
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(b.name),
+		Key:           aws.String(b.key(name)),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if opts.IfNotExists {
+		input.IfNoneMatch = aws.String("*")
+	}
+	var optFns []func(*s3.Options)
+	if opts.IfMatch != "" {
+		// conditional writes on ETag aren't modelled by this SDK version, but are sent as is
+		optFns = append(optFns, func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("If-Match", opts.IfMatch))
+		})
+	}
+	_, err = client.PutObject(ctx, input, optFns...)
+	switch {
+	case statusCode(err) == http.StatusPreconditionFailed && opts.IfMatch != "":
+		return fmt.Errorf("%s: %w", name, ErrModified)
+	case statusCode(err) == http.StatusPreconditionFailed:
+		return fmt.Errorf("%s: %w", name, ErrExists)
+	// a conditional write on a deleted object
+	case isNotFound(err) && opts.IfMatch != "":
+		return fmt.Errorf("%s: %w", name, ErrModified)
+	case err != nil:
+		return fmt.Errorf("failed to put %s: %w", name, err)
+	}
+	return nil
+}
+
+// Get reads the object name, returning ErrNotFound if it doesn't exist.
+func (b *Bucket) Get(ctx context.Context, name string) ([]byte, error) {
+	data, _, err := b.Read(ctx, name)
+	return data, err
+}
+
+// Read reads the object name along with its ETag, returning ErrNotFound if it doesn't exist.
+func (b *Bucket) Read(ctx context.Context, name string) ([]byte, string, error) {
+	client, err := b.s3Client(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(b.key(name)),
+	})
+	if isNotFound(err) {
+		return nil, "", fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get %s: %w", name, err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return data, aws.ToString(out.ETag), nil
+}
+
+// Delete removes the object name, deleting an object that doesn't exist succeeds.
+func (b *Bucket) Delete(ctx context.Context, name string) error {
+	client, err := b.s3Client(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(b.key(name)),
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete %s: %w", name, err)
+	}
+	return nil
+}
+
+// List returns the objects under dir, recursively, "." lists everything under the bucket prefix.
+func (b *Bucket) List(ctx context.Context, dir string) ([]Object, error) {
+	client, err := b.s3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix := b.key(dir)
+	if prefix == "." || prefix == "" {
+		// the whole bucket
+		prefix = ""
+	} else {
+		prefix += "/"
+	}
+	var objects []Object
+	p := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.name),
+		Prefix: aws.String(prefix),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+		for _, o := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(o.Key), b.prefix)
+			objects = append(objects, Object{Name: strings.TrimPrefix(name, "/"), Size: aws.ToInt64(o.Size)})
+		}
+	}
+	return objects, nil
+}
+
+// statusCode returns the HTTP status code of a failed S3 request, 0 if err isn't a response error.
+func statusCode(err error) int {
+	var rerr *smithyhttp.ResponseError
+	if errors.As(err, &rerr) {
+		return rerr.HTTPStatusCode()
+	}
+	return 0
+}
+
+func isNotFound(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NoSuchKey" || apiErr.ErrorCode() == "NotFound") {
+		return true
+	}
+	return statusCode(err) == http.StatusNotFound
+}
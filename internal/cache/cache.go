@@ -0,0 +1,469 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package cache reads and maintains the local TUF cache of the mirroring commands.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/docker/go-tuf-mirror/internal/freshness"
+	"github.com/docker/go-tuf-mirror/internal/journal"
+	"github.com/docker/go-tuf-mirror/internal/rotation"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"github.com/theupdateframework/go-tuf/v2/metadata/trustedmetadata"
+)
+
+const (
+	// downloadDir is the directory holding downloaded target files.
+	downloadDir = "download"
+	// sourceFile records the metadata location of a namespace directory.
+	sourceFile = "source"
+)
+
+// ErrNotFound is returned when the cache holds no repository of the name or source.
+var ErrNotFound = errors.New("repository not cached")
+
+// Repository is the cached metadata of a TUF repository. The cache is namespaced by metadata location, with
+// a directory per location holding the TUF client's directory per initial root, named by its fingerprint.
+type Repository struct {
+	// Name identifies the repository, from the fingerprints of its metadata location and initial root.
+	Name string `json:"name"`
+	// Source is the metadata location, empty for repositories cached before the cache was namespaced.
+	Source string `json:"source,omitempty"`
+	// Root is the fingerprint of the initial root.
+	Root string `json:"root"`
+	Path string `json:"path"`
+	// Size is the size in bytes of every file cached for the repository.
+	Size  int64  `json:"size"`
+	Roles []Role `json:"roles"`
+}
+
+// Role is a cached metadata file.
+type Role struct {
+	Role    string    `json:"role"`
+	Version int64     `json:"version"`
+	Expires time.Time `json:"expires"`
+}
+
+// Check is the result of verifying a cached file.
+type Check struct {
+	File string
+	// Version is the version of a metadata file, zero for target files.
+	Version int64
+	Err     error
+}
+
+// Fingerprint returns the SHA-256 of a metadata location or initial root, naming its cache directory.
+func Fingerprint(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Namespace returns the directory under dir caching the TUF metadata of source, creating it.
+func Namespace(dir, source string) (string, error) {
+	path := filepath.Join(dir, Fingerprint([]byte(source)))
+	err := os.MkdirAll(path, 0o755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	data, err := os.ReadFile(filepath.Join(path, sourceFile))
+	if err == nil && string(data) == source {
+		return path, nil
+	}
+	err = os.WriteFile(filepath.Join(path, sourceFile), []byte(source), 0o644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write cache directory source: %w", err)
+	}
+	return path, nil
+}
+
+// List returns the repositories cached under dir.
+func List(dir string) ([]Repository, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	var repos []Repository
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		// cached before the cache was namespaced
+		if isRepository(path) {
+			repo, err := load(Repository{Name: short(e.Name()), Root: e.Name(), Path: path})
+			if err != nil {
+				return nil, err
+			}
+			repos = append(repos, repo)
+			continue
+		}
+		source, err := os.ReadFile(filepath.Join(path, sourceFile))
+		if err != nil {
+			continue
+		}
+		roots, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cache directory: %w", err)
+		}
+		for _, r := range roots {
+			if !r.IsDir() || !isRepository(filepath.Join(path, r.Name())) {
+				continue
+			}
+			repo, err := load(Repository{
+				Name:   short(e.Name()) + "-" + short(r.Name()),
+				Source: string(source),
+				Root:   r.Name(),
+				Path:   filepath.Join(path, r.Name()),
+			})
+			if err != nil {
+				return nil, err
+			}
+			repos = append(repos, repo)
+		}
+	}
+	return repos, nil
+}
+
+// short abbreviates a fingerprint.
+func short(fingerprint string) string {
+	if len(fingerprint) > 12 {
+		return fingerprint[:12]
+	}
+	return fingerprint
+}
+
+// isRepository reports whether the TUF client cached a repository at path.
+func isRepository(path string) bool {
+	return exists(filepath.Join(path, metadata.ROOT+".json"))
+}
+
+// load reads the roles and size of repo.
+func load(repo Repository) (Repository, error) {
+	path := repo.Path
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		repo.Size += info.Size()
+		if filepath.Dir(p) != path || filepath.Ext(p) != ".json" {
+			return nil
+		}
+		role, ok := readRole(p)
+		if ok {
+			repo.Roles = append(repo.Roles, role)
+		}
+		return nil
+	})
+	if err != nil {
+		return repo, fmt.Errorf("failed to read cached repository %s: %w", repo.Name, err)
+	}
+	// top-level roles in TUF order, then delegated roles by name
+	order := map[string]int{metadata.ROOT: 0, metadata.TIMESTAMP: 1, metadata.SNAPSHOT: 2, metadata.TARGETS: 3}
+	rank := func(role string) int {
+		if r, ok := order[role]; ok {
+			return r
+		}
+		return len(order)
+	}
+	sort.Slice(repo.Roles, func(i, j int) bool {
+		ri, rj := rank(repo.Roles[i].Role), rank(repo.Roles[j].Role)
+		return ri < rj || (ri == rj && repo.Roles[i].Role < repo.Roles[j].Role)
+	})
+	return repo, nil
+}
+
+// readRole reads the role, version and expiry of a metadata file, files that aren't TUF metadata are
+// ignored.
+func readRole(path string) (Role, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Role{}, false
+	}
+	var md struct {
+		Signed struct {
+			Type    string    `json:"_type"`
+			Version int64     `json:"version"`
+			Expires time.Time `json:"expires"`
+		} `json:"signed"`
+	}
+	if json.Unmarshal(data, &md) != nil || md.Signed.Type == "" {
+		return Role{}, false
+	}
+	return Role{Role: roleName(path), Version: md.Signed.Version, Expires: md.Signed.Expires}, true
+}
+
+// roleName returns the role of a metadata file named <role>.json, with the role query escaped.
+func roleName(path string) string {
+	name := filepath.Base(path)
+	name = name[:len(name)-len(".json")]
+	role, err := url.QueryUnescape(name)
+	if err != nil {
+		return name
+	}
+	return role
+}
+
+// Remove removes the repositories cached under dir for name, a repository name or a metadata location.
+func Remove(dir, name string) ([]Repository, error) {
+	repos, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []Repository
+	for _, r := range repos {
+		if r.Name != name && r.Source != name {
+			continue
+		}
+		err = remove(r)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, r)
+	}
+	if len(removed) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return removed, nil
+}
+
+// remove removes a cached repository, and its namespace directory once it's the last one in it.
+func remove(r Repository) error {
+	err := os.RemoveAll(r.Path)
+	if err != nil {
+		return fmt.Errorf("failed to remove cached repository %s: %w", r.Name, err)
+	}
+	if r.Source == "" {
+		return nil
+	}
+	namespace := filepath.Dir(r.Path)
+	entries, err := os.ReadDir(namespace)
+	if err != nil {
+		return fmt.Errorf("failed to remove cached repository %s: %w", r.Name, err)
+	}
+	for _, e := range entries {
+		if e.IsDir() && isRepository(filepath.Join(namespace, e.Name())) {
+			return nil
+		}
+	}
+	err = os.RemoveAll(namespace)
+	if err != nil {
+		return fmt.Errorf("failed to remove cached repository %s: %w", r.Name, err)
+	}
+	return nil
+}
+
+// Clean removes every repository cached under dir, along with the freshness and root records, journals and
+// downloads. Anything else under dir is left alone, in case it isn't only a cache.
+func Clean(dir string) error {
+	repos, err := List(dir)
+	if err != nil {
+		return err
+	}
+	for _, r := range repos {
+		err = remove(r)
+		if err != nil {
+			return err
+		}
+	}
+	paths := []string{freshness.Dir, rotation.Dir, journal.Dir, downloadDir}
+	// namespace directories left without a repository, e.g. by a run that failed
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to clean cache: %w", err)
+	}
+	for _, e := range entries {
+		if e.IsDir() && exists(filepath.Join(dir, e.Name(), sourceFile)) {
+			paths = append(paths, e.Name())
+		}
+	}
+	for _, name := range paths {
+		err := os.RemoveAll(filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Errorf("failed to clean cache: %w", err)
+		}
+	}
+	return nil
+}
+
+// Verify verifies the repository cached at path for the trusted root, as the TUF client would load it: the
+// cached root against root, then each role against the roles before it, then downloaded target files
+// against the targets metadata. Expiry isn't checked, a run refreshes expired metadata. It stops at the
+// first file that fails or isn't cached.
+func Verify(path string, root []byte) ([]Check, error) {
+	trusted, err := trustedmetadata.New(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load trusted root: %w", err)
+	}
+	// expiry is up to the next run
+	trusted.RefTime = time.Time{}
+
+	var checks []Check
+	// check verifies a cached metadata file, reporting whether the next can be checked
+	check := func(role string, update func([]byte) (int64, error)) bool {
+		file := url.QueryEscape(role) + ".json"
+		data, err := os.ReadFile(filepath.Join(path, file))
+		if errors.Is(err, fs.ErrNotExist) {
+			return false
+		}
+		c := Check{File: file}
+		if err == nil {
+			c.Version, err = update(data)
+		}
+		c.Err = err
+		checks = append(checks, c)
+		return err == nil
+	}
+
+	ok := check(metadata.ROOT, func(data []byte) (int64, error) {
+		return verifyRoot(trusted, data)
+	})
+	ok = ok && check(metadata.TIMESTAMP, func(data []byte) (int64, error) {
+		m, err := trusted.UpdateTimestamp(data)
+		if err != nil {
+			return 0, err
+		}
+		return m.Signed.Version, nil
+	})
+	ok = ok && check(metadata.SNAPSHOT, func(data []byte) (int64, error) {
+		m, err := trusted.UpdateSnapshot(data, false)
+		if err != nil {
+			return 0, err
+		}
+		return m.Signed.Version, nil
+	})
+	ok = ok && check(metadata.TARGETS, func(data []byte) (int64, error) {
+		m, err := trusted.UpdateTargets(data)
+		if err != nil {
+			return 0, err
+		}
+		return m.Signed.Version, nil
+	})
+	if !ok {
+		return checks, nil
+	}
+	// delegated roles, breadth first from the top-level targets
+	queue := []string{metadata.TARGETS}
+	for len(queue) > 0 {
+		delegator := queue[0]
+		queue = queue[1:]
+		delegations := trusted.Targets[delegator].Signed.Delegations
+		if delegations == nil {
+			continue
+		}
+		for _, r := range delegations.Roles {
+			if _, ok := trusted.Targets[r.Name]; ok {
+				continue
+			}
+			ok := check(r.Name, func(data []byte) (int64, error) {
+				m, err := trusted.UpdateDelegatedTargets(data, r.Name, delegator)
+				if err != nil {
+					return 0, err
+				}
+				return m.Signed.Version, nil
+			})
+			if ok {
+				queue = append(queue, r.Name)
+			}
+		}
+	}
+	return append(checks, verifyTargets(trusted, filepath.Join(path, downloadDir))...), nil
+}
+
+// verifyRoot verifies the cached root against the trusted root, which it either is or directly follows.
+// The TUF client doesn't cache the intermediate roots of longer chains.
+func verifyRoot(trusted *trustedmetadata.TrustedMetadata, data []byte) (int64, error) {
+	cached, err := metadata.Root().FromBytes(data)
+	if err != nil {
+		return 0, err
+	}
+	current := trusted.Root.Signed.Version
+	switch v := cached.Signed.Version; {
+	case v == current:
+		err = trusted.Root.VerifyDelegate(metadata.ROOT, cached)
+		if err != nil {
+			return v, err
+		}
+		trusted.Root = cached
+		return v, nil
+	case v == current+1:
+		_, err = trusted.UpdateRoot(data)
+		return v, err
+	case v < current:
+		return v, fmt.Errorf("cached root version %d is older than trusted root version %d", v, current)
+	default:
+		return v, fmt.Errorf("cached root version %d can't be verified against trusted root version %d, the roots between aren't cached", v, current)
+	}
+}
+
+// verifyTargets verifies the target files downloaded to dir against the trusted targets metadata.
+func verifyTargets(trusted *trustedmetadata.TrustedMetadata, dir string) []Check {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var checks []Check
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		c := Check{File: filepath.Join(downloadDir, e.Name())}
+		path, err := url.QueryUnescape(e.Name())
+		if err != nil {
+			path = e.Name()
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			c.Err = err
+			checks = append(checks, c)
+			continue
+		}
+		c.Err = fmt.Errorf("target %s isn't in the cached targets metadata", path)
+		for _, t := range trusted.Targets {
+			if tf, ok := t.Signed.Targets[path]; ok {
+				c.Err = tf.VerifyLengthHashes(data)
+				if c.Err == nil {
+					break
+				}
+			}
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,303 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-tuf-mirror/internal/freshness"
+	"github.com/docker/go-tuf-mirror/internal/journal"
+	"github.com/docker/go-tuf-mirror/internal/rotation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	source      = "https://example.com/metadata"
+	otherSource = "https://example.com/other/metadata"
+)
+
+var testRepo = filepath.Join("..", "test", "testdata", "test-repo", "metadata")
+
+// cacheRepository caches the test repository at path, as the TUF client names its files.
+func cacheRepository(t *testing.T, path string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(path, 0o755))
+	for name, file := range map[string]string{
+		"root.json":      "2.root.json",
+		"timestamp.json": "timestamp.json",
+		"snapshot.json":  "7.snapshot.json",
+		"targets.json":   "8.targets.json",
+		"test-role.json": "2.test-role.json",
+	} {
+		data, err := os.ReadFile(filepath.Join(testRepo, file))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(path, name), data, 0o644))
+	}
+}
+
+// cacheNamespaced caches the test repository for source under dir, as the mirroring commands do.
+func cacheNamespaced(t *testing.T, dir, source, root string) string {
+	t.Helper()
+	namespace, err := Namespace(dir, source)
+	require.NoError(t, err)
+	path := filepath.Join(namespace, Fingerprint([]byte(root)))
+	cacheRepository(t, path)
+	return path
+}
+
+func TestFingerprint(t *testing.T) {
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", Fingerprint([]byte("abc")))
+}
+
+func TestNamespace(t *testing.T) {
+	dir := t.TempDir()
+	path, err := Namespace(dir, source)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, Fingerprint([]byte(source))), path)
+	data, err := os.ReadFile(filepath.Join(path, sourceFile))
+	require.NoError(t, err)
+	assert.Equal(t, source, string(data))
+
+	// the namespace is reused
+	again, err := Namespace(dir, source)
+	require.NoError(t, err)
+	assert.Equal(t, path, again)
+	other, err := Namespace(dir, otherSource)
+	require.NoError(t, err)
+	assert.NotEqual(t, path, other)
+}
+
+func TestList(t *testing.T) {
+	root := Fingerprint([]byte("root"))
+	testCases := []struct {
+		name     string
+		setup    func(t *testing.T, dir string)
+		expected []Repository
+	}{
+		{name: "empty", setup: func(*testing.T, string) {}},
+		{
+			name:  "namespaced",
+			setup: func(t *testing.T, dir string) { cacheNamespaced(t, dir, source, "root") },
+			expected: []Repository{{
+				Name:   Fingerprint([]byte(source))[:12] + "-" + root[:12],
+				Source: source,
+				Root:   root,
+				Path:   filepath.Join(Fingerprint([]byte(source)), root),
+			}},
+		},
+		{
+			name:     "legacy",
+			setup:    func(t *testing.T, dir string) { cacheRepository(t, filepath.Join(dir, root)) },
+			expected: []Repository{{Name: root[:12], Root: root, Path: root}},
+		},
+		{
+			name: "namespace without a repository",
+			setup: func(t *testing.T, dir string) {
+				_, err := Namespace(dir, source)
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "records and journals",
+			setup: func(t *testing.T, dir string) {
+				for _, name := range []string{freshness.Dir, rotation.Dir, journal.Dir, downloadDir} {
+					require.NoError(t, os.MkdirAll(filepath.Join(dir, name), 0o755))
+				}
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tc.setup(t, dir)
+
+			repos, err := List(dir)
+			require.NoError(t, err)
+			require.Len(t, repos, len(tc.expected))
+			for i, expected := range tc.expected {
+				repo := repos[i]
+				assert.Equal(t, expected.Name, repo.Name)
+				assert.Equal(t, expected.Source, repo.Source)
+				assert.Equal(t, expected.Root, repo.Root)
+				assert.Equal(t, filepath.Join(dir, expected.Path), repo.Path)
+				assert.Positive(t, repo.Size)
+				// top-level roles in TUF order, then delegated roles
+				var roles []string
+				var versions []int64
+				for _, r := range repo.Roles {
+					roles = append(roles, r.Role)
+					versions = append(versions, r.Version)
+				}
+				assert.Equal(t, []string{"root", "timestamp", "snapshot", "targets", "test-role"}, roles)
+				assert.Equal(t, []int64{2, 7, 7, 8, 2}, versions)
+			}
+		})
+	}
+
+	repos, err := List(filepath.Join(t.TempDir(), "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, repos)
+}
+
+func TestRemove(t *testing.T) {
+	legacy := Fingerprint([]byte("legacy"))
+	testCases := []struct {
+		name      string
+		remove    func(repos []Repository) string
+		removed   int
+		remaining int
+		err       error
+	}{
+		{name: "by name", remove: func(repos []Repository) string { return repos[0].Name }, removed: 1, remaining: 3},
+		{name: "by source", remove: func([]Repository) string { return source }, removed: 2, remaining: 2},
+		{name: "legacy by name", remove: func([]Repository) string { return legacy[:12] }, removed: 1, remaining: 3},
+		{name: "not cached", remove: func([]Repository) string { return "https://example.com/missing" }, remaining: 4, err: ErrNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cacheNamespaced(t, dir, source, "root")
+			cacheNamespaced(t, dir, source, "other root")
+			cacheNamespaced(t, dir, otherSource, "root")
+			cacheRepository(t, filepath.Join(dir, legacy))
+			repos, err := List(dir)
+			require.NoError(t, err)
+			require.Len(t, repos, 4)
+
+			name := tc.remove(repos)
+			removed, err := Remove(dir, name)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Len(t, removed, tc.removed)
+			for _, r := range removed {
+				assert.True(t, r.Name == name || r.Source == name)
+				assert.NoDirExists(t, r.Path)
+			}
+			repos, err = List(dir)
+			require.NoError(t, err)
+			assert.Len(t, repos, tc.remaining)
+		})
+	}
+}
+
+func TestRemoveNamespace(t *testing.T) {
+	dir := t.TempDir()
+	first := cacheNamespaced(t, dir, source, "root")
+	second := cacheNamespaced(t, dir, source, "other root")
+	namespace := filepath.Dir(first)
+
+	// the namespace is kept while it caches another repository, and removed with the last one
+	_, err := Remove(dir, filepath.Base(namespace)[:12]+"-"+filepath.Base(first)[:12])
+	require.NoError(t, err)
+	assert.DirExists(t, namespace)
+	_, err = Remove(dir, filepath.Base(namespace)[:12]+"-"+filepath.Base(second)[:12])
+	require.NoError(t, err)
+	assert.NoDirExists(t, namespace)
+}
+
+func TestClean(t *testing.T) {
+	dir := t.TempDir()
+	cacheNamespaced(t, dir, source, "root")
+	cacheRepository(t, filepath.Join(dir, Fingerprint([]byte("legacy"))))
+	orphan, err := Namespace(dir, otherSource)
+	require.NoError(t, err)
+	for _, name := range []string{freshness.Dir, rotation.Dir, journal.Dir, downloadDir} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, name), 0o755))
+	}
+	// anything else is left alone
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "other"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.txt"), nil, 0o644))
+
+	require.NoError(t, Clean(dir))
+	assert.NoDirExists(t, orphan)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.ElementsMatch(t, []string{"other", "other.txt"}, names)
+
+	// cleaning a missing cache succeeds
+	require.NoError(t, Clean(filepath.Join(dir, "missing")))
+}
+
+func TestVerify(t *testing.T) {
+	root, err := os.ReadFile(filepath.Join(testRepo, "1.root.json"))
+	require.NoError(t, err)
+	testCases := []struct {
+		name   string
+		tamper func(t *testing.T, path string)
+		failed string
+		files  int
+	}{
+		{name: "valid", files: 5},
+		{
+			name: "tampered timestamp",
+			tamper: func(t *testing.T, path string) {
+				data, err := os.ReadFile(filepath.Join(testRepo, "timestamp.json"))
+				require.NoError(t, err)
+				data = []byte(strings.Replace(string(data), `"version": 7`, `"version": 70`, 1))
+				require.NoError(t, os.WriteFile(filepath.Join(path, "timestamp.json"), data, 0o644))
+			},
+			failed: "timestamp.json",
+			files:  2,
+		},
+		{
+			name: "target not in targets metadata",
+			tamper: func(t *testing.T, path string) {
+				require.NoError(t, os.MkdirAll(filepath.Join(path, downloadDir), 0o755))
+				require.NoError(t, os.WriteFile(filepath.Join(path, downloadDir, "unknown.txt"), nil, 0o644))
+			},
+			failed: filepath.Join(downloadDir, "unknown.txt"),
+			files:  6,
+		},
+		{
+			name: "root not cached",
+			tamper: func(t *testing.T, path string) {
+				require.NoError(t, os.Remove(filepath.Join(path, "root.json")))
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "repo")
+			cacheRepository(t, path)
+			if tc.tamper != nil {
+				tc.tamper(t, path)
+			}
+
+			checks, err := Verify(path, root)
+			require.NoError(t, err)
+			assert.Len(t, checks, tc.files)
+			for _, c := range checks {
+				if c.File == tc.failed {
+					assert.Error(t, c.Err, c.File)
+				} else {
+					assert.NoError(t, c.Err, c.File)
+				}
+			}
+		})
+	}
+}
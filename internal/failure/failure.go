@@ -0,0 +1,59 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package failure classifies errors so that callers can tell classes of failure apart without parsing
+// messages, e.g. to choose an exit code.
+package failure
+
+// Failure is a class of failure. Classified errors match their class with errors.Is.
+type Failure struct {
+	name string
+}
+
+func (f *Failure) Error() string {
+	return f.name
+}
+
+var (
+	InvalidArgument   = &Failure{"invalid argument"}
+	SourceUnreachable = &Failure{"source unreachable"}
+	Verification      = &Failure{"verification failed"}
+	Expired           = &Failure{"metadata expired"}
+	DestinationAuth   = &Failure{"destination access denied"}
+	PartialPush       = &Failure{"partial push"}
+)
+
+// classified is an error of a failure class, with the message of the underlying error.
+type classified struct {
+	failure *Failure
+	err     error
+}
+
+func (e *classified) Error() string {
+	return e.err.Error()
+}
+
+func (e *classified) Unwrap() []error {
+	return []error{e.err, e.failure}
+}
+
+// Classify marks err as of the failure class f, a nil err stays nil.
+func Classify(f *Failure, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classified{failure: f, err: err}
+}
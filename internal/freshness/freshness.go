@@ -0,0 +1,128 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package freshness guards against mirroring frozen metadata from a source.
+package freshness
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/go-tuf-mirror/internal/atomicfile"
+)
+
+const (
+	// Dir is the directory under the TUF cache path recording when each source's timestamp version last advanced.
+	Dir = "freshness"
+	// SinceAnnotation records on a mirrored metadata image when its timestamp version was first seen at the
+	// source, so the guard holds for runs that don't keep the cache.
+	SinceAnnotation = "dev.docker.go-tuf-mirror.timestamp.since"
+)
+
+// ErrStale is returned when the source timestamp has stopped advancing or is close to expiry.
+var ErrStale = errors.New("stale source metadata")
+
+// Options configures the guard, a zero value disables a check.
+type Options struct {
+	// MaxAge is how long the source timestamp version may go without advancing.
+	MaxAge time.Duration
+	// MinValidity is how long the source timestamp must remain valid for.
+	MinValidity time.Duration
+	// Now returns the current time, time.Now if nil.
+	Now func() time.Time
+}
+
+func (o Options) now() time.Time {
+	if o.Now == nil {
+		return time.Now()
+	}
+	return o.Now()
+}
+
+// Observation is when a timestamp version was first seen, as recorded at a destination.
+type Observation struct {
+	Version int64
+	Since   time.Time
+}
+
+type record struct {
+	Source  string    `json:"source"`
+	Version int64     `json:"version"`
+	Since   time.Time `json:"since"`
+}
+
+// Check returns when the timestamp version served by source was first seen: the earliest of the record
+// under dir and the observations recorded at destinations, or now for a version not seen before. The
+// record under dir is updated to match. It returns ErrStale if the version hasn't advanced for longer
+// than opts.MaxAge, or expires within opts.MinValidity.
+func Check(dir, source string, version int64, expires time.Time, observed []Observation, opts Options) (time.Time, error) {
+	now := opts.now()
+	if opts.MinValidity > 0 && expires.Sub(now) < opts.MinValidity {
+		return time.Time{}, fmt.Errorf("%w: timestamp version %d expires at %s, within %s", ErrStale, version, expires.UTC().Format(time.RFC3339), opts.MinValidity)
+	}
+
+	sum := sha256.Sum256([]byte(source))
+	path := filepath.Join(dir, Dir, hex.EncodeToString(sum[:])+".json")
+	var r record
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return time.Time{}, fmt.Errorf("failed to read timestamp record: %w", err)
+	default:
+		// a corrupt record is replaced below
+		_ = json.Unmarshal(data, &r)
+	}
+	since := now
+	if r.Source == source && r.Version == version && r.Since.Before(since) {
+		since = r.Since
+	}
+	for _, o := range observed {
+		if o.Version == version && o.Since.Before(since) {
+			since = o.Since
+		}
+	}
+	if r.Source != source || r.Version != version || !r.Since.Equal(since) {
+		err = write(path, record{Source: source, Version: version, Since: since})
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if age := now.Sub(since); opts.MaxAge > 0 && age > opts.MaxAge {
+		return time.Time{}, fmt.Errorf("%w: timestamp version %d unchanged since %s (%s, more than %s)", ErrStale, version, since.UTC().Format(time.RFC3339), age.Round(time.Second), opts.MaxAge)
+	}
+	return since, nil
+}
+
+// write replaces the record at path.
+func write(path string, r record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal timestamp record: %w", err)
+	}
+	err = atomicfile.Write(path, data)
+	if err != nil {
+		return fmt.Errorf("failed to write timestamp record: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package freshness
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	const source = "https://example.com/metadata"
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires := now.Add(24 * time.Hour)
+	testCases := []struct {
+		name     string
+		record   string
+		observed []Observation
+		opts     Options
+		expected time.Time
+		stale    bool
+	}{
+		{name: "first seen", expected: now},
+		{name: "recorded", record: `{"source":"` + source + `","version":7,"since":"2025-12-31T00:00:00Z"}`, expected: now.Add(-24 * time.Hour)},
+		{name: "advanced", record: `{"source":"` + source + `","version":6,"since":"2025-12-01T00:00:00Z"}`, expected: now},
+		{name: "other source", record: `{"source":"other","version":7,"since":"2025-12-01T00:00:00Z"}`, expected: now},
+		{name: "corrupt record", record: `{"source":"`, expected: now},
+		{name: "observed at a destination", observed: []Observation{{Version: 7, Since: now.Add(-time.Hour)}, {Version: 6, Since: now.Add(-48 * time.Hour)}}, expected: now.Add(-time.Hour)},
+		{
+			name:     "earliest of record and destinations",
+			record:   `{"source":"` + source + `","version":7,"since":"2025-12-31T22:00:00Z"}`,
+			observed: []Observation{{Version: 7, Since: now.Add(-time.Hour)}},
+			expected: now.Add(-2 * time.Hour),
+		},
+		{
+			name:   "unchanged for too long",
+			record: `{"source":"` + source + `","version":7,"since":"2025-12-31T00:00:00Z"}`,
+			opts:   Options{MaxAge: 12 * time.Hour},
+			stale:  true,
+		},
+		{
+			name:     "unchanged for too long at a destination",
+			observed: []Observation{{Version: 7, Since: now.Add(-13 * time.Hour)}},
+			opts:     Options{MaxAge: 12 * time.Hour},
+			stale:    true,
+		},
+		{
+			name:     "unchanged within the max age",
+			record:   `{"source":"` + source + `","version":7,"since":"2025-12-31T13:00:00Z"}`,
+			opts:     Options{MaxAge: 12 * time.Hour},
+			expected: now.Add(-11 * time.Hour),
+		},
+		{name: "expiring", opts: Options{MinValidity: 48 * time.Hour}, stale: true},
+		{name: "valid long enough", opts: Options{MinValidity: 12 * time.Hour}, expected: now},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sum := sha256.Sum256([]byte(source))
+			path := filepath.Join(dir, Dir, hex.EncodeToString(sum[:])+".json")
+			if tc.record != "" {
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+				require.NoError(t, os.WriteFile(path, []byte(tc.record), 0o644))
+			}
+			tc.opts.Now = func() time.Time { return now }
+
+			since, err := Check(dir, source, 7, expires, tc.observed, tc.opts)
+			if tc.stale {
+				require.ErrorIs(t, err, ErrStale)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tc.expected.Equal(since), "since %s, expected %s", since, tc.expected)
+
+			// the record is updated to match
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			var r record
+			require.NoError(t, json.Unmarshal(data, &r))
+			assert.Equal(t, source, r.Source)
+			assert.EqualValues(t, 7, r.Version)
+			assert.True(t, since.Equal(r.Since))
+		})
+	}
+}
@@ -0,0 +1,211 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package journal records the progress of a mirror run so that an interrupted run can be resumed.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Dir is the directory under the TUF cache path holding journals.
+const Dir = "journal"
+
+// Journal records the tags written to a destination along with their keys, identifying the manifest and
+// referrers written. It's an append-only log of JSON lines, a header followed by an entry per tag, synced
+// to disk as each entry is recorded so a crash loses at most the entry being written.
+type Journal struct {
+	path   string
+	mu     sync.Mutex
+	header header
+	file   *os.File
+	tags   map[string]string
+}
+
+type header struct {
+	Source         string `json:"source"`
+	Destination    string `json:"destination"`
+	TargetsVersion int64  `json:"targetsVersion"`
+}
+
+type entry struct {
+	Tag string `json:"tag"`
+	Key string `json:"key"`
+}
+
+// Open opens the journal for mirroring source to destination under dir. An existing journal recorded
+// for a different targets metadata version is discarded, since the targets it records may be stale.
+func Open(dir, source, destination string, targetsVersion int64) (*Journal, error) {
+	sum := sha256.Sum256([]byte(source + "\x00" + destination))
+	j := &Journal{
+		path: filepath.Join(dir, Dir, hex.EncodeToString(sum[:])+".jsonl"),
+		header: header{
+			Source:         source,
+			Destination:    destination,
+			TargetsVersion: targetsVersion,
+		},
+		tags: map[string]string{},
+	}
+	data, err := os.ReadFile(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	j.load(data)
+	return j, nil
+}
+
+// load reads the entries of a journal matching the header of j, up to the first line that was torn by a
+// crash. A journal with a different header only costs a full run.
+func (j *Journal) load(data []byte) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	if !scanner.Scan() {
+		return
+	}
+	var h header
+	if err := json.Unmarshal(scanner.Bytes(), &h); err != nil || h != j.header {
+		return
+	}
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Tag == "" {
+			break
+		}
+		j.tags[e.Tag] = e.Key
+	}
+}
+
+// Len returns the number of tags recorded.
+func (j *Journal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.tags)
+}
+
+// Done reports whether tag was already written with key.
+func (j *Journal) Done(tag, key string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	k, ok := j.tags[tag]
+	return ok && k == key
+}
+
+// Record appends that tag was written with key to the journal and syncs it to disk.
+func (j *Journal) Record(tag, key string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		err := j.create()
+		if err != nil {
+			return err
+		}
+	}
+	err := j.append(entry{Tag: tag, Key: key})
+	if err != nil {
+		return err
+	}
+	j.tags[tag] = key
+	return nil
+}
+
+// create starts the log afresh, with the header and the entries loaded from an earlier run. Rewriting them
+// drops any torn line and any journal of another targets version.
+func (j *Journal) create() error {
+	err := os.MkdirAll(filepath.Dir(j.path), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	// write to a temporary file and rename so an interrupted write never corrupts the journal
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create journal: %w", err)
+	}
+	j.file = tmp
+	err = j.append(j.header)
+	for tag, key := range j.tags {
+		if err != nil {
+			break
+		}
+		err = j.append(entry{Tag: tag, Key: key})
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), j.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		j.file = nil
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
+
+// append writes v as a line of the log and syncs it to disk.
+func (j *Journal) append(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal entry: %w", err)
+	}
+	_, err = j.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	err = j.file.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	return nil
+}
+
+// Close closes the journal, keeping it for the next run to resume from.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to close journal: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes the journal once a run has completed.
+func (j *Journal) Remove() error {
+	err := j.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(j.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	j, err := Open(dir, "source", "destination", 1)
+	require.NoError(t, err)
+	assert.Zero(t, j.Len())
+	// nothing is written until a tag is recorded
+	assert.NoDirExists(t, filepath.Join(dir, Dir))
+
+	require.NoError(t, j.Record("a", "1"))
+	require.NoError(t, j.Record("b", "2"))
+	require.NoError(t, j.Record("a", "3"))
+	assert.True(t, j.Done("a", "3"))
+	assert.False(t, j.Done("a", "1"))
+	assert.False(t, j.Done("c", ""))
+	require.NoError(t, j.Close())
+
+	// the next run resumes, and appends to what it resumed
+	j, err = Open(dir, "source", "destination", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, j.Len())
+	assert.True(t, j.Done("a", "3"))
+	assert.True(t, j.Done("b", "2"))
+	require.NoError(t, j.Record("c", "4"))
+	require.NoError(t, j.Close())
+	j, err = Open(dir, "source", "destination", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, j.Len())
+
+	require.NoError(t, j.Remove())
+	j, err = Open(dir, "source", "destination", 1)
+	require.NoError(t, err)
+	assert.Zero(t, j.Len())
+	// removing a journal that was never written succeeds
+	require.NoError(t, j.Remove())
+}
+
+func TestJournalLoad(t *testing.T) {
+	header := `{"source":"source","destination":"destination","targetsVersion":1}` + "\n"
+	testCases := []struct {
+		name     string
+		contents string
+		expected map[string]string
+	}{
+		{
+			name:     "entries",
+			contents: header + `{"tag":"a","key":"1"}` + "\n" + `{"tag":"b","key":"2"}` + "\n",
+			expected: map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name:     "later entries win",
+			contents: header + `{"tag":"a","key":"1"}` + "\n" + `{"tag":"a","key":"2"}` + "\n",
+			expected: map[string]string{"a": "2"},
+		},
+		{
+			name:     "torn line",
+			contents: header + `{"tag":"a","key":"1"}` + "\n" + `{"tag":"b","ke`,
+			expected: map[string]string{"a": "1"},
+		},
+		{
+			name:     "entries after a torn line",
+			contents: header + `{"tag":"a","key":"1"}` + "\n" + `{"tag":` + "\n" + `{"tag":"c","key":"3"}` + "\n",
+			expected: map[string]string{"a": "1"},
+		},
+		{
+			name:     "entry without tag",
+			contents: header + `{"key":"1"}` + "\n" + `{"tag":"b","key":"2"}` + "\n",
+			expected: map[string]string{},
+		},
+		{
+			name:     "other targets version",
+			contents: `{"source":"source","destination":"destination","targetsVersion":2}` + "\n" + `{"tag":"a","key":"1"}` + "\n",
+			expected: map[string]string{},
+		},
+		{
+			name:     "other source",
+			contents: `{"source":"other","destination":"destination","targetsVersion":1}` + "\n" + `{"tag":"a","key":"1"}` + "\n",
+			expected: map[string]string{},
+		},
+		{
+			name:     "torn header",
+			contents: `{"source":"source","desti`,
+			expected: map[string]string{},
+		},
+		{
+			name:     "empty",
+			contents: "",
+			expected: map[string]string{},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			j, err := Open(dir, "source", "destination", 1)
+			require.NoError(t, err)
+			require.NoError(t, os.MkdirAll(filepath.Dir(j.path), 0o755))
+			require.NoError(t, os.WriteFile(j.path, []byte(tc.contents), 0o644))
+
+			j, err = Open(dir, "source", "destination", 1)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, j.tags)
+
+			// recording rewrites the log without what was dropped
+			require.NoError(t, j.Record("z", "9"))
+			require.NoError(t, j.Close())
+			j, err = Open(dir, "source", "destination", 1)
+			require.NoError(t, err)
+			tc.expected["z"] = "9"
+			assert.Equal(t, tc.expected, j.tags)
+		})
+	}
+}
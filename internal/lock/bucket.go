@@ -0,0 +1,121 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/go-tuf-mirror/internal/bucket"
+)
+
+// BucketObject is the name of the lock object under a bucket prefix.
+const BucketObject = ".go-tuf-mirror.lock"
+
+// AcquireBucket locks the prefix of bucket b using a lease object named BucketObject.
+func AcquireBucket(ctx context.Context, b *bucket.Bucket, opts Options) (*Lock, error) {
+	return acquire(ctx, b.String(), &bucketStore{bucket: b}, opts)
+}
+
+type bucketStore struct {
+	bucket *bucket.Bucket
+}
+
+func (s *bucketStore) read(ctx context.Context) (*lease, error) {
+	data, etag, err := s.bucket.Read(ctx, BucketObject)
+	if errors.Is(err, bucket.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &lease{}
+	if err := json.Unmarshal(data, l); err != nil {
+		// not a lease we understand, treat as stale
+		l = &lease{}
+	}
+	l.version = etag
+	return l, nil
+}
+
+func (s *bucketStore) claim(ctx context.Context, l lease, current *lease) (bool, error) {
+	// creating the lease object is atomic, and so is replacing a stale lease that is still the one read
+	opts := bucket.PutOptions{ContentType: "application/json", IfNotExists: current == nil}
+	if current != nil {
+		opts.IfMatch = current.version
+	}
+	err := s.put(ctx, l, opts)
+	if errors.Is(err, bucket.ErrExists) || errors.Is(err, bucket.ErrModified) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if opts.IfNotExists || opts.IfMatch != "" {
+		return true, nil
+	}
+	// stores without ETags are only trusted if the claim is still in place after settling
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-time.After(settleDelay):
+	}
+	got, err := s.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	return got != nil && got.Holder == l.Holder, nil
+}
+
+func (s *bucketStore) renew(ctx context.Context, l lease) error {
+	current, err := s.read(ctx)
+	if err != nil {
+		return err
+	}
+	err = checkHolder(current, l)
+	if err != nil {
+		return err
+	}
+	err = s.put(ctx, l, bucket.PutOptions{ContentType: "application/json", IfMatch: current.version})
+	if errors.Is(err, bucket.ErrModified) {
+		return fmt.Errorf("%w: %w", ErrLost, err)
+	}
+	return err
+}
+
+func (s *bucketStore) release(ctx context.Context, l lease) error {
+	got, err := s.read(ctx)
+	if err != nil {
+		return err
+	}
+	if got == nil || got.Holder != l.Holder {
+		// taken over by another run
+		return nil
+	}
+	return s.bucket.Delete(ctx, BucketObject)
+}
+
+func (s *bucketStore) put(ctx context.Context, l lease, opts bucket.PutOptions) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return s.bucket.Put(ctx, BucketObject, data, opts)
+}
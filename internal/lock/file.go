@@ -0,0 +1,172 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// FileSuffix is appended to an OCI layout path to name its lock file.
+	FileSuffix = ".lock"
+	// guardSuffix is appended to the lock file to name the file guarding its updates.
+	guardSuffix = ".guard"
+)
+
+// AcquireFile locks the OCI layout at path using a lock file next to it.
+func AcquireFile(ctx context.Context, path string, opts Options) (*Lock, error) {
+	path = filepath.Clean(path) + FileSuffix
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	return acquire(ctx, path, &fileStore{path: path}, opts)
+}
+
+type fileStore struct {
+	path string
+}
+
+func (s *fileStore) read(_ context.Context) (*lease, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &lease{}
+	if err := json.Unmarshal(data, l); err != nil {
+		// a partially written lock file is treated as stale
+		return &lease{}, nil
+	}
+	return l, nil
+}
+
+func (s *fileStore) claim(ctx context.Context, l lease, current *lease) (bool, error) {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return false, err
+	}
+	var claimed bool
+	err = s.guard(func() error {
+		// replace current, unless another run got there first
+		got, err := s.read(ctx)
+		if err != nil || !sameLease(got, current) {
+			return err
+		}
+		claimed = true
+		return s.write(data)
+	})
+	return claimed && err == nil, err
+}
+
+// sameLease reports whether a and b are the same lease, or both missing.
+func sameLease(a, b *lease) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Holder == b.Holder && a.Expires.Equal(b.Expires)
+}
+
+func (s *fileStore) renew(ctx context.Context, l lease) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return s.guard(func() error {
+		current, err := s.read(ctx)
+		if err != nil {
+			return err
+		}
+		err = checkHolder(current, l)
+		if err != nil {
+			return err
+		}
+		return s.write(data)
+	})
+}
+
+func (s *fileStore) release(ctx context.Context, l lease) error {
+	return s.guard(func() error {
+		got, err := s.read(ctx)
+		if err != nil {
+			return err
+		}
+		if got == nil || got.Holder != l.Holder {
+			// taken over by another run
+			return nil
+		}
+		err = os.Remove(s.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
+}
+
+// guard runs fn holding an exclusive lock on a file next to the lock file, so that reading the lease and
+// replacing it is atomic among runs on the host. The guard file is never removed, a run removing it could
+// let the next run guard a different file than one still held.
+func (s *fileStore) guard(fn func() error) error {
+	f, err := os.OpenFile(s.path+guardSuffix, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = lockFile(f)
+	if err != nil {
+		return fmt.Errorf("failed to lock %s: %w", f.Name(), err)
+	}
+	defer func() {
+		_ = unlockFile(f)
+	}()
+	return fn()
+}
+
+// write replaces the lock file atomically.
+func (s *fileStore) write(data []byte) error {
+	tmp, err := s.temp(data)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+// temp writes data to a temporary file next to the lock file.
+func (s *fileStore) temp(data []byte) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
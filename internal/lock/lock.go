@@ -0,0 +1,208 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package lock provides advisory locks preventing concurrent mirror runs against the same destination.
+//
+// A lock is a lease: it expires unless renewed by its holder, so a lock left behind by a run that died
+// can be taken over once it is stale.
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/go-tuf-mirror/internal/logging"
+)
+
+var (
+	// ErrTimeout is returned when a lock is still held by another run after the timeout.
+	ErrTimeout = errors.New("timed out waiting for lock")
+	// ErrLost is returned when renewing a lock that another run has taken over.
+	ErrLost = errors.New("lock lost")
+)
+
+const pollInterval = time.Second
+
+// Options configures lock acquisition.
+type Options struct {
+	// Timeout is how long to wait for a lock held by another run, zero fails immediately.
+	Timeout time.Duration
+	// StaleAfter is how long a lock lasts without being renewed before it can be taken over.
+	StaleAfter time.Duration
+	// Now returns the current time leases are compared with, time.Now if nil.
+	Now func() time.Time
+	// OnLost is called with an error wrapping ErrLost when another run takes over a held lock, the holder
+	// must then stop writing to what the lock guards.
+	OnLost func(error)
+}
+
+func (o Options) now() time.Time {
+	if o.Now == nil {
+		return time.Now()
+	}
+	return o.Now()
+}
+
+type lease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+	// version identifies the stored lease for stores with compare-and-swap, e.g. an object ETag
+	version string
+}
+
+func (l *lease) held(now time.Time) bool {
+	return l != nil && l.Holder != "" && l.Expires.After(now)
+}
+
+// store persists a lease.
+type store interface {
+	// read returns the current lease, nil if there is none.
+	read(ctx context.Context) (*lease, error)
+	// claim tries to replace current (nil if there is no lease) with l, reporting whether l was claimed.
+	claim(ctx context.Context, l lease, current *lease) (bool, error)
+	// renew overwrites the lease held by the caller, failing with ErrLost if another holder took it over.
+	renew(ctx context.Context, l lease) error
+	// release gives up the lease held by the caller.
+	release(ctx context.Context, l lease) error
+}
+
+// Lock is a held lock, renewed in the background until released.
+type Lock struct {
+	name  string
+	store store
+	mu    sync.Mutex
+	lease lease
+	stop  context.CancelFunc
+	done  chan struct{}
+}
+
+func acquire(ctx context.Context, name string, s store, opts Options) (*Lock, error) {
+	logger := logging.FromContext(ctx)
+	holder, err := newHolder()
+	if err != nil {
+		return nil, err
+	}
+	deadline := opts.now().Add(opts.Timeout)
+	for {
+		current, err := s.read(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read lock %s: %w", name, err)
+		}
+		now := opts.now()
+		if !current.held(now) {
+			if current != nil && current.Holder != "" {
+				logger.WarnContext(ctx, "Taking over stale lock", "lock", name, "holder", current.Holder, "expired", current.Expires)
+			}
+			l := lease{Holder: holder, Expires: now.Add(opts.StaleAfter)}
+			ok, err := s.claim(ctx, l, current)
+			if err != nil {
+				return nil, fmt.Errorf("failed to claim lock %s: %w", name, err)
+			}
+			if ok {
+				logger.DebugContext(ctx, "acquired lock", "lock", name, "holder", holder)
+				return start(ctx, name, s, l, opts), nil
+			}
+			continue
+		}
+		if now.After(deadline) {
+			return nil, fmt.Errorf("%w %s held by %s until %s", ErrTimeout, name, current.Holder, current.Expires.Format(time.RFC3339))
+		}
+		logger.InfoContext(ctx, "Waiting for lock", "lock", name, "holder", current.Holder)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(min(pollInterval, deadline.Sub(now)+time.Millisecond)):
+		}
+	}
+}
+
+// start renews the lease until ctx is done, the lock is released or another run takes it over.
+func start(ctx context.Context, name string, s store, l lease, opts Options) *Lock {
+	ctx, stop := context.WithCancel(ctx)
+	lk := &Lock{name: name, store: s, lease: l, stop: stop, done: make(chan struct{})}
+	go func() {
+		defer close(lk.done)
+		ticker := time.NewTicker(opts.StaleAfter / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				lk.mu.Lock()
+				lk.lease.Expires = opts.now().Add(opts.StaleAfter)
+				err := s.renew(ctx, lk.lease)
+				lk.mu.Unlock()
+				if errors.Is(err, ErrLost) {
+					logging.FromContext(ctx).ErrorContext(ctx, "Lost lock to another run", "lock", name, "error", err)
+					if opts.OnLost != nil {
+						opts.OnLost(fmt.Errorf("lock %s: %w", name, err))
+					}
+					return
+				}
+				if err != nil && ctx.Err() == nil {
+					logging.FromContext(ctx).WarnContext(ctx, "Failed to renew lock", "lock", name, "error", err)
+				}
+			}
+		}
+	}()
+	return lk
+}
+
+// checkHolder returns ErrLost unless current is held by the holder of l.
+func checkHolder(current *lease, l lease) error {
+	if current == nil || current.Holder != l.Holder {
+		holder := ""
+		if current != nil {
+			holder = current.Holder
+		}
+		return fmt.Errorf("%w to %q", ErrLost, holder)
+	}
+	return nil
+}
+
+// Release stops renewing the lock and releases it.
+func (lk *Lock) Release(ctx context.Context) error {
+	lk.stop()
+	<-lk.done
+	lk.mu.Lock()
+	defer lk.mu.Unlock()
+	err := lk.store.release(ctx, lk.lease)
+	if err != nil {
+		return fmt.Errorf("failed to release lock %s: %w", lk.name, err)
+	}
+	return nil
+}
+
+// newHolder returns an identifier for this run.
+func newHolder() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	b := make([]byte, 4)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate lock holder: %w", err)
+	}
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(b)), nil
+}
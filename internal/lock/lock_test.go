@@ -0,0 +1,202 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquireFile(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name     string
+		contents *lease
+		raw      string
+		err      error
+	}{
+		{name: "no lock"},
+		{name: "held", contents: &lease{Holder: "other", Expires: now.Add(time.Minute)}, err: ErrTimeout},
+		{name: "stale", contents: &lease{Holder: "other", Expires: now.Add(-time.Second)}},
+		{name: "released", contents: &lease{}},
+		{name: "partially written", raw: `{"holder":"oth`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "layout")
+			raw := []byte(tc.raw)
+			if tc.contents != nil {
+				var err error
+				raw, err = json.Marshal(tc.contents)
+				require.NoError(t, err)
+			}
+			if len(raw) > 0 {
+				require.NoError(t, os.WriteFile(path+FileSuffix, raw, 0o644))
+			}
+
+			lk, err := AcquireFile(context.Background(), path, Options{StaleAfter: time.Minute})
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				// the lock is left to its holder
+				data, err := os.ReadFile(path + FileSuffix)
+				require.NoError(t, err)
+				assert.Equal(t, raw, data)
+				return
+			}
+			require.NoError(t, err)
+			s := &fileStore{path: path + FileSuffix}
+			got, err := s.read(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, lk.lease.Holder, got.Holder)
+			require.NoError(t, lk.Release(context.Background()))
+			assert.NoFileExists(t, path+FileSuffix)
+		})
+	}
+}
+
+func TestAcquireFileTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "layout")
+	held, err := AcquireFile(context.Background(), path, Options{StaleAfter: time.Minute})
+	require.NoError(t, err)
+	defer held.Release(context.Background())
+
+	start := time.Now()
+	_, err = AcquireFile(context.Background(), path, Options{Timeout: 50 * time.Millisecond, StaleAfter: time.Minute})
+	require.ErrorIs(t, err, ErrTimeout)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestStaleTakeover(t *testing.T) {
+	// runs racing to take over the same stale lock, only one of them gets it
+	path := filepath.Join(t.TempDir(), "layout")
+	stale, err := json.Marshal(lease{Holder: "dead", Expires: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path+FileSuffix, stale, 0o644))
+
+	const runs = 10
+	var wg sync.WaitGroup
+	locks := make(chan *Lock, runs)
+	errs := make(chan error, runs)
+	for range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lk, err := AcquireFile(context.Background(), path, Options{StaleAfter: time.Minute})
+			if err != nil {
+				errs <- err
+				return
+			}
+			locks <- lk
+		}()
+	}
+	wg.Wait()
+	close(locks)
+	close(errs)
+	require.Len(t, locks, 1)
+	for err := range errs {
+		assert.ErrorIs(t, err, ErrTimeout)
+	}
+	lk := <-locks
+	require.NoError(t, lk.Release(context.Background()))
+}
+
+func TestClaim(t *testing.T) {
+	// two runs that both read the same stale lease, the second to claim it finds it taken over
+	s := &fileStore{path: filepath.Join(t.TempDir(), "layout") + FileSuffix}
+	stale := lease{Holder: "dead", Expires: time.Now().Add(-time.Second)}
+	data, err := json.Marshal(stale)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(s.path, data, 0o644))
+	current, err := s.read(context.Background())
+	require.NoError(t, err)
+
+	first := lease{Holder: "first", Expires: time.Now().Add(time.Minute)}
+	ok, err := s.claim(context.Background(), first, current)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = s.claim(context.Background(), lease{Holder: "second", Expires: time.Now().Add(time.Minute)}, current)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	got, err := s.read(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "first", got.Holder)
+
+	// the same goes for runs that both found no lock
+	require.NoError(t, os.Remove(s.path))
+	ok, err = s.claim(context.Background(), first, nil)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = s.claim(context.Background(), lease{Holder: "second"}, nil)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestLost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "layout")
+	lost := make(chan error, 1)
+	stalled, err := AcquireFile(context.Background(), path, Options{
+		StaleAfter: 30 * time.Millisecond,
+		OnLost: func(err error) {
+			lost <- err
+		},
+	})
+	require.NoError(t, err)
+	later := func() time.Time { return time.Now().Add(time.Minute) }
+	taken, err := AcquireFile(context.Background(), path, Options{StaleAfter: time.Minute, Now: later})
+	require.NoError(t, err)
+
+	select {
+	case err := <-lost:
+		assert.ErrorIs(t, err, ErrLost)
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock not lost")
+	}
+	// releasing a lost lock leaves the new holder's lock in place
+	require.NoError(t, stalled.Release(context.Background()))
+	assert.FileExists(t, path+FileSuffix)
+	require.NoError(t, taken.Release(context.Background()))
+	assert.NoFileExists(t, path+FileSuffix)
+}
+
+func TestCheckHolder(t *testing.T) {
+	l := lease{Holder: "me"}
+	testCases := []struct {
+		name    string
+		current *lease
+		lost    bool
+	}{
+		{name: "held", current: &lease{Holder: "me"}},
+		{name: "taken over", current: &lease{Holder: "other"}, lost: true},
+		{name: "released", current: &lease{}, lost: true},
+		{name: "removed", lost: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkHolder(tc.current, l)
+			assert.Equal(t, tc.lost, errors.Is(err, ErrLost), err)
+		})
+	}
+}
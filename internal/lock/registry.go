@@ -0,0 +1,143 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/go-tuf-mirror/internal/registry"
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// Tag is the tag of the lock artifact in a destination repository.
+	Tag = tags.LockTag
+	// ArtifactType is the artifact type of the lock artifact.
+	ArtifactType = "application/vnd.docker.go-tuf-mirror.lock.v1+json"
+	// LeaseAnnotation holds the lease of the lock artifact.
+	LeaseAnnotation = "dev.docker.go-tuf-mirror.lock.lease"
+)
+
+// registries have no compare-and-swap, so a claim is only trusted if it is still in place after settling
+var settleDelay = 500 * time.Millisecond
+
+// AcquireRegistry locks the registry repository repo using a lease artifact tagged Tag.
+func AcquireRegistry(ctx context.Context, repo string, opts Options) (*Lock, error) {
+	r, err := name.NewRepository(repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repository: %w", err)
+	}
+	ref := r.Tag(Tag)
+	return acquire(ctx, ref.Name(), &registryStore{ref: ref}, opts)
+}
+
+type registryStore struct {
+	ref name.Tag
+}
+
+func (s *registryStore) read(ctx context.Context) (*lease, error) {
+	desc, err := remote.Get(s.ref, registry.Options(ctx)...)
+	if err != nil {
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	img, err := desc.Image()
+	if err != nil {
+		return nil, err
+	}
+	mf, err := img.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	l := &lease{}
+	if err := json.Unmarshal([]byte(mf.Annotations[LeaseAnnotation]), l); err != nil {
+		// not a lease we understand, treat as stale
+		return &lease{}, nil
+	}
+	return l, nil
+}
+
+func (s *registryStore) claim(ctx context.Context, l lease, _ *lease) (bool, error) {
+	err := s.write(ctx, l)
+	if err != nil {
+		return false, err
+	}
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-time.After(settleDelay):
+	}
+	got, err := s.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	return got != nil && got.Holder == l.Holder, nil
+}
+
+func (s *registryStore) renew(ctx context.Context, l lease) error {
+	current, err := s.read(ctx)
+	if err != nil {
+		return err
+	}
+	err = checkHolder(current, l)
+	if err != nil {
+		return err
+	}
+	return s.write(ctx, l)
+}
+
+func (s *registryStore) release(ctx context.Context, l lease) error {
+	got, err := s.read(ctx)
+	if err != nil {
+		return err
+	}
+	if got == nil || got.Holder != l.Holder {
+		// taken over by another run
+		return nil
+	}
+	// registries don't all allow deleting tags, so release by writing an empty lease
+	return s.write(ctx, lease{Expires: time.Now()})
+}
+
+func (s *registryStore) write(ctx context.Context, l lease) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ArtifactType(img, ArtifactType)
+	img, ok := mutate.Annotations(img, map[string]string{LeaseAnnotation: string(data)}).(v1.Image)
+	if !ok {
+		return fmt.Errorf("failed to annotate lock artifact")
+	}
+	return remote.Write(s.ref, img, registry.Options(ctx)...)
+}
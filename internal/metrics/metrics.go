@@ -0,0 +1,275 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/theupdateframework/go-tuf/v2/metadata/trustedmetadata"
+)
+
+const (
+	namespace = "go_tuf_mirror"
+
+	// MetadataJob and TargetsJob label metrics recorded by the metadata and targets mirroring code paths. The
+	// label is "phase", "job" being reserved for the scrape job or Pushgateway grouping.
+	MetadataJob = "metadata"
+	TargetsJob  = "targets"
+
+	// JobLabel names the mirror job a run belongs to, so the series of jobs sharing a textfile directory or
+	// Pushgateway don't overwrite each other.
+	JobLabel = "mirror_job"
+
+	pushed  = "pushed"
+	skipped = "skipped"
+)
+
+// Metrics records mirror runs to a Prometheus registerer, a nil *Metrics records nothing.
+type Metrics struct {
+	lastSuccess  *prometheus.GaugeVec
+	artifacts    *prometheus.CounterVec
+	bytes        *prometheus.CounterVec
+	pushDuration *prometheus.HistogramVec
+	roleVersion  *prometheus.GaugeVec
+	roleExpiry   *expiryCollector
+}
+
+// WithJob returns reg labelling the metrics registered through it with the mirror job.
+func WithJob(reg prometheus.Registerer, job string) prometheus.Registerer {
+	return prometheus.WrapRegistererWith(prometheus.Labels{JobLabel: job}, reg)
+}
+
+// New returns the metrics registered with reg, sharing the collectors registered by an earlier call with
+// the same registerer. A nil reg returns nil.
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	if reg == nil {
+		return nil, nil
+	}
+	var err error
+	m := &Metrics{}
+	if m.lastSuccess, err = register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful mirror run.",
+	}, []string{"phase"})); err != nil {
+		return nil, err
+	}
+	if m.artifacts, err = register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "artifacts_total",
+		Help:      "Number of images and indexes written to a destination or skipped.",
+	}, []string{"phase", "result"})); err != nil {
+		return nil, err
+	}
+	if m.bytes, err = register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_total",
+		Help:      "Size of the images and indexes written to a destination or skipped.",
+	}, []string{"phase", "result"})); err != nil {
+		return nil, err
+	}
+	if m.pushDuration, err = register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "push_duration_seconds",
+		Help:      "Time taken to write an image or index to a destination.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"phase"})); err != nil {
+		return nil, err
+	}
+	if m.roleVersion, err = register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tuf_role_version",
+		Help:      "Version of the trusted TUF role metadata last mirrored.",
+	}, []string{"role"})); err != nil {
+		return nil, err
+	}
+	if m.roleExpiry, err = register(reg, &expiryCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "tuf_role_expiry_seconds"),
+			"Seconds until the trusted TUF role metadata last mirrored expires.",
+			[]string{"role"}, nil),
+		expires: map[string]time.Time{},
+	}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// register registers c with reg, returning the collector already registered in its place if any.
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) (C, error) {
+	err := reg.Register(c)
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(C); ok {
+			return existing, nil
+		}
+	}
+	if err != nil {
+		return c, fmt.Errorf("failed to register metrics: %w", err)
+	}
+	return c, nil
+}
+
+// expiryCollector reports the time remaining until each role expires at scrape time.
+type expiryCollector struct {
+	desc    *prometheus.Desc
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+func (c *expiryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *expiryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for role, expires := range c.expires {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Until(expires).Seconds(), role)
+	}
+}
+
+func (c *expiryCollector) set(role string, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expires[role] = expires
+}
+
+// ObservePush records an artifact of size bytes written for job, started at start.
+func (m *Metrics) ObservePush(job string, size int64, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.pushDuration.WithLabelValues(job).Observe(time.Since(start).Seconds())
+	m.artifacts.WithLabelValues(job, pushed).Inc()
+	m.bytes.WithLabelValues(job, pushed).Add(float64(size))
+}
+
+// ObserveSkip records an artifact of size bytes that job did not need to write.
+func (m *Metrics) ObserveSkip(job string, size int64) {
+	if m == nil {
+		return
+	}
+	m.artifacts.WithLabelValues(job, skipped).Inc()
+	m.bytes.WithLabelValues(job, skipped).Add(float64(size))
+}
+
+// RecordSuccess records the completion of a mirror run for job.
+func (m *Metrics) RecordSuccess(job string) {
+	if m == nil {
+		return
+	}
+	m.lastSuccess.WithLabelValues(job).SetToCurrentTime()
+}
+
+// RecordRoles records the version and expiry of each role in the trusted metadata.
+func (m *Metrics) RecordRoles(md trustedmetadata.TrustedMetadata) {
+	if m == nil {
+		return
+	}
+	if md.Root != nil {
+		m.recordRole(md.Root.Signed.Type, md.Root.Signed.Version, md.Root.Signed.Expires)
+	}
+	if md.Timestamp != nil {
+		m.recordRole(md.Timestamp.Signed.Type, md.Timestamp.Signed.Version, md.Timestamp.Signed.Expires)
+	}
+	if md.Snapshot != nil {
+		m.recordRole(md.Snapshot.Signed.Type, md.Snapshot.Signed.Version, md.Snapshot.Signed.Expires)
+	}
+	for role, t := range md.Targets {
+		m.recordRole(role, t.Signed.Version, t.Signed.Expires)
+	}
+}
+
+func (m *Metrics) recordRole(role string, version int64, expires time.Time) {
+	m.roleVersion.WithLabelValues(role).Set(float64(version))
+	m.roleExpiry.set(role, expires)
+}
+
+// Serve starts a listener on addr serving the metrics gathered from g, along with the Go runtime and process
+// metrics, and returns a function to shut it down. The runtime metrics are only served while the process
+// runs, they'd be stale once exported.
+func Serve(addr string, g prometheus.Gatherer, logger *slog.Logger) (func(context.Context) error, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	runtime := prometheus.NewRegistry()
+	runtime.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.Gatherers{g, runtime}, promhttp.HandlerOpts{}))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics listener stopped", "error", err)
+		}
+	}()
+	logger.Info("serving metrics", "addr", lis.Addr().String())
+	return srv.Shutdown, nil
+}
+
+// WriteTextfile writes the metrics gathered from g to path in the Prometheus text format, replacing it
+// atomically so the node exporter textfile collector never reads a partial file.
+func WriteTextfile(path string, g prometheus.Gatherer) error {
+	err := prometheus.WriteToTextfile(path, g)
+	if err != nil {
+		return fmt.Errorf("failed to write metrics to %s: %w", path, err)
+	}
+	return nil
+}
+
+// Push replaces the metrics of the mirror job in the Prometheus Pushgateway at url with those gathered from
+// g. The job is the grouping key, the Pushgateway adds it back to the series as their JobLabel.
+func Push(ctx context.Context, url, job string, g prometheus.Gatherer) error {
+	err := push.New(url, namespace).Grouping(JobLabel, job).Gatherer(withoutLabel(g, JobLabel)).PushContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics to %s: %w", url, err)
+	}
+	return nil
+}
+
+// withoutLabel returns g dropping the label name from the metrics gathered, since a pushed metric can't
+// carry a label of its grouping key.
+func withoutLabel(g prometheus.Gatherer, name string) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+		for _, mf := range mfs {
+			for _, m := range mf.GetMetric() {
+				m.Label = slices.DeleteFunc(m.Label, func(l *dto.LabelPair) bool {
+					return l.GetName() == name
+				})
+			}
+		}
+		return mfs, err
+	})
+}
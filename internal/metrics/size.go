@@ -0,0 +1,60 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package metrics
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ImageSize returns the size of the manifest, config and layers of img.
+// Errors are ignored since the size is only used for reporting.
+func ImageSize(img v1.Image) int64 {
+	mf, err := img.Manifest()
+	if err != nil {
+		return 0
+	}
+	raw, err := img.RawManifest()
+	if err != nil {
+		return 0
+	}
+	size := int64(len(raw)) + mf.Config.Size
+	for _, l := range mf.Layers {
+		size += l.Size
+	}
+	return size
+}
+
+// IndexSize returns the size of the manifest of idx and each of its images.
+func IndexSize(idx v1.ImageIndex) int64 {
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return 0
+	}
+	raw, err := idx.RawManifest()
+	if err != nil {
+		return 0
+	}
+	size := int64(len(raw))
+	for _, desc := range mf.Manifests {
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			continue
+		}
+		size += ImageSize(img)
+	}
+	return size
+}
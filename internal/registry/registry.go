@@ -0,0 +1,67 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/attest/oci"
+	"github.com/docker/go-tuf-mirror/internal/logging"
+	"github.com/docker/go-tuf-mirror/internal/tracing"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Options returns the remote options for registry requests, with credentials from the docker config
+// and each request traced and logged.
+func Options(ctx context.Context) []remote.Option {
+	return append(oci.WithOptions(ctx, nil), remote.WithTransport(tracing.Transport(logging.Transport(remote.DefaultTransport))))
+}
+
+// PushImage pushes an image to the registry with the specified name.
+func PushImage(ctx context.Context, image v1.Image, imageName string) error {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return fmt.Errorf("failed to parse image name '%s': %w", imageName, err)
+	}
+	return remote.Write(ref, image, Options(ctx)...)
+}
+
+// PushIndex pushes an index to the registry with the specified name.
+func PushIndex(ctx context.Context, index v1.ImageIndex, imageName string) error {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return fmt.Errorf("failed to parse image name '%s': %w", imageName, err)
+	}
+	return remote.WriteIndex(ref, index, Options(ctx)...)
+}
+
+// Tag tags the manifest imageName refers to with tag, in the same repository, without pushing it again.
+func Tag(ctx context.Context, imageName, tag string) error {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return fmt.Errorf("failed to parse image name '%s': %w", imageName, err)
+	}
+	options := Options(ctx)
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", imageName, err)
+	}
+	return remote.Tag(ref.Context().Tag(tag), desc, options...)
+}
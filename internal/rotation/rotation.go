@@ -0,0 +1,108 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package rotation reports rotations of a source's TUF root between runs.
+package rotation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/go-tuf-mirror/internal/atomicfile"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+// Dir is the directory under the TUF cache path holding the last accepted root of each source.
+const Dir = "roots"
+
+// ErrRotation is returned when the source root was rotated and the rotation wasn't accepted.
+var ErrRotation = errors.New("root rotation")
+
+// Rotation is a root rotated since the last run.
+type Rotation struct {
+	// From and To are the previous and current root versions.
+	From int64
+	To   int64
+	// Changes are the keys, and role thresholds and key IDs, that differ between the two roots.
+	Changes []mirrortuf.Difference
+}
+
+func (r *Rotation) String() string {
+	lines := []string{fmt.Sprintf("Root rotated from version %d to %d", r.From, r.To)}
+	for _, c := range r.Changes {
+		lines = append(lines, fmt.Sprintf("  %s: %s -> %s", c.Field, c.A, c.B))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Check compares root, the trusted root of source, with the newest of the root accepted by the last run,
+// recorded under dir, and the roots observed at destinations. It returns the rotation, or nil if the root
+// is unchanged or no previous root is known. A rotation that isn't accepted returns ErrRotation and keeps
+// the record, so later runs refuse it too until it's accepted.
+func Check(dir, source string, root *metadata.Metadata[metadata.RootType], observed []*metadata.Metadata[metadata.RootType], accept bool) (*Rotation, error) {
+	sum := sha256.Sum256([]byte(source))
+	path := filepath.Join(dir, Dir, hex.EncodeToString(sum[:])+".json")
+	var previous *metadata.Metadata[metadata.RootType]
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read root record: %w", err)
+	default:
+		// a corrupt record is replaced below
+		previous, _ = metadata.Root().FromBytes(data)
+	}
+	recorded := previous
+	for _, o := range observed {
+		if previous == nil || o.Signed.Version > previous.Signed.Version {
+			previous = o
+		}
+	}
+
+	var rotation *Rotation
+	if previous != nil && previous.Signed.Version < root.Signed.Version {
+		rotation = &Rotation{From: previous.Signed.Version, To: root.Signed.Version, Changes: mirrortuf.DiffKeys(previous, root)}
+		if !accept {
+			return rotation, fmt.Errorf("%w: refusing to mirror %s (accept root rotation to override):\n%s", ErrRotation, source, rotation)
+		}
+	}
+	if recorded == nil || recorded.Signed.Version < root.Signed.Version {
+		err = write(path, root)
+		if err != nil {
+			return rotation, err
+		}
+	}
+	return rotation, nil
+}
+
+// write replaces the record at path.
+func write(path string, root *metadata.Metadata[metadata.RootType]) error {
+	data, err := root.ToBytes(false)
+	if err != nil {
+		return fmt.Errorf("failed to marshal root record: %w", err)
+	}
+	err = atomicfile.Write(path, data)
+	if err != nil {
+		return fmt.Errorf("failed to write root record: %w", err)
+	}
+	return nil
+}
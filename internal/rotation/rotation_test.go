@@ -0,0 +1,103 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package rotation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+func TestCheck(t *testing.T) {
+	const source = "https://example.com/metadata"
+	roots := map[int64]*metadata.Metadata[metadata.RootType]{}
+	for _, version := range []int64{1, 2} {
+		root, err := metadata.Root().FromFile(filepath.Join("..", "test", "testdata", "test-repo", "metadata", strconv.FormatInt(version, 10)+".root.json"))
+		require.NoError(t, err)
+		roots[version] = root
+	}
+	testCases := []struct {
+		name     string
+		record   string
+		observed []int64
+		root     int64
+		accept   bool
+		rotated  bool
+		refused  bool
+		recorded int64
+	}{
+		{name: "first run", root: 2, recorded: 2},
+		{name: "unchanged", record: "2", root: 2, recorded: 2},
+		{name: "rotated", record: "1", root: 2, rotated: true, refused: true, recorded: 1},
+		{name: "rotation accepted", record: "1", root: 2, accept: true, rotated: true, recorded: 2},
+		{name: "rotated since a destination", observed: []int64{1}, root: 2, rotated: true, refused: true},
+		{name: "destination up to date", record: "1", observed: []int64{2}, root: 2, recorded: 2},
+		{name: "older root", record: "2", root: 1, recorded: 2},
+		{name: "corrupt record", record: "corrupt", root: 2, recorded: 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sum := sha256.Sum256([]byte(source))
+			path := filepath.Join(dir, Dir, hex.EncodeToString(sum[:])+".json")
+			if tc.record != "" {
+				data := []byte(`{"signed":`)
+				if version, err := strconv.ParseInt(tc.record, 10, 64); err == nil {
+					data, err = roots[version].ToBytes(false)
+					require.NoError(t, err)
+				}
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+				require.NoError(t, os.WriteFile(path, data, 0o644))
+			}
+			var observed []*metadata.Metadata[metadata.RootType]
+			for _, version := range tc.observed {
+				observed = append(observed, roots[version])
+			}
+
+			rotation, err := Check(dir, source, roots[tc.root], observed, tc.accept)
+			if tc.refused {
+				require.ErrorIs(t, err, ErrRotation)
+			} else {
+				require.NoError(t, err)
+			}
+			if tc.rotated {
+				require.NotNil(t, rotation)
+				assert.EqualValues(t, 1, rotation.From)
+				assert.EqualValues(t, 2, rotation.To)
+				assert.NotEmpty(t, rotation.Changes)
+			} else {
+				assert.Nil(t, rotation)
+			}
+
+			// a refused rotation keeps the record, so later runs refuse it too
+			if tc.recorded == 0 {
+				assert.NoFileExists(t, path)
+				return
+			}
+			recorded, err := metadata.Root().FromFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, tc.recorded, recorded.Signed.Version)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/attest/signerverifier"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+const (
+	// AWSKMSPrefix prefixes the ARN of an AWS KMS signing key.
+	AWSKMSPrefix = "awskms:///"
+	// GCPKMSPrefix prefixes the resource name of a GCP KMS signing key version.
+	GCPKMSPrefix = "gcpkms://"
+)
+
+// LoadSigner returns the signer for key, either a PEM encoded ECDSA or ed25519 private key file,
+// awskms:///<key ARN> or gcpkms://<key version resource name>.
+func LoadSigner(ctx context.Context, key string) (dsse.SignerVerifier, error) {
+	switch {
+	case strings.HasPrefix(key, AWSKMSPrefix):
+		arn := strings.TrimPrefix(key, AWSKMSPrefix)
+		// arn:aws:kms:<region>:<account>:key/<id>
+		parts := strings.Split(arn, ":")
+		if len(parts) < 6 {
+			return nil, fmt.Errorf("invalid AWS KMS key ARN: %s", arn)
+		}
+		return signerverifier.GetAWSSigner(ctx, arn, parts[3])
+	case strings.HasPrefix(key, GCPKMSPrefix):
+		return signerverifier.GetGCPSigner(ctx, strings.TrimPrefix(key, GCPKMSPrefix))
+	}
+	data, err := os.ReadFile(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	p, _ := pem.Decode(data)
+	if p == nil {
+		return nil, fmt.Errorf("signing key %s does not contain PEM data", key)
+	}
+	var priv any
+	switch p.Type {
+	case "EC PRIVATE KEY":
+		priv, err = x509.ParseECPrivateKey(p.Bytes)
+	case "PRIVATE KEY":
+		priv, err = x509.ParsePKCS8PrivateKey(p.Bytes)
+	default:
+		return nil, fmt.Errorf("signing key %s is not a private key: %s", key, p.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	switch k := priv.(type) {
+	case *ecdsa.PrivateKey:
+		return signerverifier.NewECDSASignerVerifier(k)
+	case ed25519.PrivateKey:
+		return &ed25519SignerVerifier{ed25519Verifier: ed25519Verifier{public: k.Public().(ed25519.PublicKey)}, private: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", priv)
+	}
+}
+
+// LoadVerifier returns the verifier for the PEM encoded ECDSA or ed25519 public key file key.
+func LoadVerifier(key string) (dsse.Verifier, error) {
+	data, err := os.ReadFile(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read verification key: %w", err)
+	}
+	p, _ := pem.Decode(data)
+	if p == nil || p.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("verification key %s does not contain a PEM public key", key)
+	}
+	pub, err := x509.ParsePKIXPublicKey(p.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse verification key: %w", err)
+	}
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		return signerverifier.NewECDSAVerifier(k)
+	case ed25519.PublicKey:
+		return &ed25519Verifier{public: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported verification key type %T", pub)
+	}
+}
+
+// ed25519Verifier verifies ed25519 signatures of data itself, which ed25519 hashes as part of the scheme.
+type ed25519Verifier struct {
+	public ed25519.PublicKey
+}
+
+func (v *ed25519Verifier) Verify(_ context.Context, data, sig []byte) error {
+	if !ed25519.Verify(v.public, data, sig) {
+		return fmt.Errorf("payload signature is not valid")
+	}
+	return nil
+}
+
+func (v *ed25519Verifier) KeyID() (string, error) {
+	return signerverifier.KeyID(v.public)
+}
+
+func (v *ed25519Verifier) Public() crypto.PublicKey {
+	return v.public
+}
+
+type ed25519SignerVerifier struct {
+	ed25519Verifier
+	private ed25519.PrivateKey
+}
+
+// Sign signs data itself, unlike the ECDSA signers, which are passed a digest.
+func (s *ed25519SignerVerifier) Sign(_ context.Context, data []byte) ([]byte, error) {
+	return ed25519.Sign(s.private, data), nil
+}
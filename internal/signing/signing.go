@@ -0,0 +1,198 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package signing signs mirrored manifests with a mirror operator key, storing each signature as a DSSE
+// envelope in an artifact that refers to the signed manifest.
+package signing
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/docker/attest/attestation"
+	"github.com/docker/attest/oci"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+)
+
+const (
+	// ArtifactType is the artifact type of signature artifacts.
+	ArtifactType = "application/vnd.docker.go-tuf-mirror.signature.v1+json"
+	// PayloadType is the DSSE payload type of signed subjects.
+	PayloadType = "application/vnd.docker.go-tuf-mirror.subject.v1+json"
+	// EnvelopeMediaType is the media type of the DSSE envelope layer of a signature artifact.
+	EnvelopeMediaType = "application/vnd.dsse.envelope.v1+json"
+	// KeyIDAnnotation records the ID of the signing key on a signature artifact.
+	KeyIDAnnotation = "dev.docker.go-tuf-mirror.signature.keyid"
+
+	// maxEnvelopeLength caps the size of a signature envelope read from a location.
+	maxEnvelopeLength = 1 << 20
+)
+
+// ErrNoSignature is returned when a manifest has no signature from the verification key.
+var ErrNoSignature = errors.New("no valid signature")
+
+// Payload is the signed statement about a manifest.
+type Payload struct {
+	// Subject describes the signed manifest.
+	Subject v1.Descriptor `json:"subject"`
+}
+
+// Sign returns a signature artifact for the manifest described by subject.
+func Sign(ctx context.Context, signer dsse.SignerVerifier, subject v1.Descriptor) (v1.Image, error) {
+	subject = subjectOf(subject)
+	payload, err := json.Marshal(Payload{Subject: subject})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal signature payload: %w", err)
+	}
+	sig, err := signer.Sign(ctx, message(signer, dsse.PAE(PayloadType, payload)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign %s: %w", subject.Digest, err)
+	}
+	keyID, err := signer.KeyID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key ID: %w", err)
+	}
+	env, err := json.Marshal(&attestation.Envelope{
+		PayloadType: PayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures:  []*attestation.Signature{{KeyID: keyID, Sig: base64.StdEncoding.EncodeToString(sig)}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal signature envelope: %w", err)
+	}
+
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ArtifactType(img, ArtifactType)
+	img, err = mutate.Append(img, mutate.Addendum{Layer: static.NewLayer(env, EnvelopeMediaType)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to append signature layer: %w", err)
+	}
+	img, ok := mutate.Annotations(img, map[string]string{KeyIDAnnotation: keyID}).(v1.Image)
+	if !ok {
+		return nil, fmt.Errorf("failed to annotate signature")
+	}
+	img, ok = mutate.Subject(img, subject).(v1.Image)
+	if !ok {
+		return nil, fmt.Errorf("failed to set signature subject")
+	}
+	return &oci.EmptyConfigImage{Image: img}, nil
+}
+
+// message returns what signer signs for the DSSE pre-authentication encoding pae. ECDSA and KMS signers
+// sign its sha256 digest, as attestations are signed, while ed25519 signs the message itself.
+func message(signer dsse.SignerVerifier, pae []byte) []byte {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return pae
+	}
+	digest := sha256.Sum256(pae)
+	return digest[:]
+}
+
+// Verify checks that one of signatures is a valid signature of subject by verifier.
+func Verify(ctx context.Context, verifier dsse.Verifier, subject v1.Descriptor, signatures []v1.Image) error {
+	keyID, err := verifier.KeyID()
+	if err != nil {
+		return fmt.Errorf("failed to get key ID: %w", err)
+	}
+	var errs []error
+	for _, img := range signatures {
+		err := verify(ctx, verifier, keyID, subject, img)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("%w for %s: not signed", ErrNoSignature, subject.Digest)
+	}
+	return fmt.Errorf("%w for %s: %w", ErrNoSignature, subject.Digest, errors.Join(errs...))
+}
+
+func verify(ctx context.Context, verifier dsse.Verifier, keyID string, subject v1.Descriptor, img v1.Image) error {
+	env, err := envelope(img)
+	if err != nil {
+		return err
+	}
+	if env.PayloadType != PayloadType {
+		return fmt.Errorf("unexpected payload type %s", env.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to decode payload: %w", err)
+	}
+	p := &Payload{}
+	if err := json.Unmarshal(payload, p); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+	if p.Subject.Digest != subject.Digest {
+		return fmt.Errorf("signature is for %s", p.Subject.Digest)
+	}
+	for _, s := range env.Signatures {
+		if s.KeyID != keyID {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			return fmt.Errorf("failed to decode signature: %w", err)
+		}
+		return verifier.Verify(ctx, dsse.PAE(env.PayloadType, payload), sig)
+	}
+	return fmt.Errorf("not signed by key %s", keyID)
+}
+
+// envelope returns the DSSE envelope of a signature artifact.
+func envelope(img v1.Image) (*attestation.Envelope, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signature layers: %w", err)
+	}
+	for _, layer := range layers {
+		mt, err := layer.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if mt != EnvelopeMediaType {
+			continue
+		}
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signature: %w", err)
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxEnvelopeLength))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signature: %w", err)
+		}
+		env := &attestation.Envelope{}
+		if err := json.NewDecoder(bytes.NewReader(data)).Decode(env); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal signature: %w", err)
+		}
+		return env, nil
+	}
+	return nil, fmt.Errorf("signature artifact has no envelope")
+}
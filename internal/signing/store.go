@@ -0,0 +1,279 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package signing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/docker/go-tuf-mirror/internal/registry"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Store reads signed manifests and their signatures from a mirror location.
+type Store interface {
+	// Subjects returns the signed manifests tagged tag (the location itself if tag is empty): the image,
+	// or each image of an index.
+	Subjects(ctx context.Context, tag string) ([]v1.Descriptor, error)
+	// Index returns the signed index tagged tag itself, such as a snapshot index.
+	Index(ctx context.Context, tag string) (v1.Descriptor, error)
+	// Signatures returns the signature artifacts referring to subject, which is tagged tag.
+	Signatures(ctx context.Context, tag string, subject v1.Descriptor) ([]v1.Image, error)
+}
+
+// RegistryStore reads from a registry repository, finding signatures with the referrers API or the
+// referrers tag schema.
+type RegistryStore struct {
+	ref name.Reference
+}
+
+// NewRegistryStore returns a store for imageName, other tags are read from the same repository.
+func NewRegistryStore(imageName string) (*RegistryStore, error) {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image name: %w", err)
+	}
+	return &RegistryStore{ref: ref}, nil
+}
+
+func (s *RegistryStore) Subjects(ctx context.Context, tag string) ([]v1.Descriptor, error) {
+	ref := s.ref
+	if tag != "" {
+		ref = s.ref.Context().Tag(tag)
+	}
+	desc, err := remote.Get(ref, registry.Options(ctx)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", ref.Name(), err)
+	}
+	if !desc.MediaType.IsIndex() {
+		return []v1.Descriptor{subjectOf(desc.Descriptor)}, nil
+	}
+	idx, err := desc.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index %s: %w", ref.Name(), err)
+	}
+	return indexSubjects(idx)
+}
+
+func (s *RegistryStore) Index(ctx context.Context, tag string) (v1.Descriptor, error) {
+	ref := s.ref.Context().Tag(tag)
+	desc, err := remote.Get(ref, registry.Options(ctx)...)
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to get %s: %w", ref.Name(), err)
+	}
+	if !desc.MediaType.IsIndex() {
+		return v1.Descriptor{}, fmt.Errorf("%s is not an index: %s", ref.Name(), desc.MediaType)
+	}
+	return subjectOf(desc.Descriptor), nil
+}
+
+func (s *RegistryStore) Signatures(ctx context.Context, _ string, subject v1.Descriptor) ([]v1.Image, error) {
+	options := append(registry.Options(ctx), remote.WithFilter("artifactType", ArtifactType))
+	idx, err := remote.Referrers(s.ref.Context().Digest(subject.Digest.String()), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get referrers of %s: %w", subject.Digest, err)
+	}
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get referrers of %s: %w", subject.Digest, err)
+	}
+	var signatures []v1.Image
+	for _, desc := range mf.Manifests {
+		img, err := remote.Image(s.ref.Context().Digest(desc.Digest.String()), registry.Options(ctx)...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get signature %s: %w", desc.Digest, err)
+		}
+		signatures = append(signatures, img)
+	}
+	return signatures, nil
+}
+
+// LayoutStore reads from OCI layouts, where signatures are held in the layout of the manifest they sign.
+type LayoutStore struct {
+	path string
+}
+
+// NewLayoutStore returns a store for the OCI layout at path, other tags are layouts under path.
+func NewLayoutStore(path string) *LayoutStore {
+	return &LayoutStore{path: path}
+}
+
+func (s *LayoutStore) Subjects(_ context.Context, tag string) ([]v1.Descriptor, error) {
+	idx, err := layout.ImageIndexFromPath(filepath.Join(s.path, tag))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OCI layout: %w", err)
+	}
+	return indexSubjects(idx)
+}
+
+// Index returns the index the layout tagged tag was written from. Referrers are appended to the layout
+// after the index, so it's the layout's index without them, marshalled as indexes are when written.
+func (s *LayoutStore) Index(_ context.Context, tag string) (v1.Descriptor, error) {
+	idx, err := layout.ImageIndexFromPath(filepath.Join(s.path, tag))
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to load OCI layout: %w", err)
+	}
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	written := *mf
+	written.Manifests = nil
+	for _, desc := range mf.Manifests {
+		_, rmf, err := referrer(idx, desc)
+		if err != nil {
+			return v1.Descriptor{}, err
+		}
+		if rmf == nil {
+			written.Manifests = append(written.Manifests, desc)
+		}
+	}
+	raw, err := json.Marshal(written)
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to marshal index manifest: %w", err)
+	}
+	digest, size, err := v1.SHA256(bytes.NewReader(raw))
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to digest index manifest: %w", err)
+	}
+	return v1.Descriptor{MediaType: mf.MediaType, Digest: digest, Size: size}, nil
+}
+
+func (s *LayoutStore) Signatures(_ context.Context, tag string, subject v1.Descriptor) ([]v1.Image, error) {
+	idx, err := layout.ImageIndexFromPath(filepath.Join(s.path, tag))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OCI layout: %w", err)
+	}
+	return indexSignatures(idx, subject)
+}
+
+// indexSignatures returns the signature artifacts of idx referring to subject.
+func indexSignatures(idx v1.ImageIndex, subject v1.Descriptor) ([]v1.Image, error) {
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	var signatures []v1.Image
+	for _, desc := range mf.Manifests {
+		img, mf, err := referrer(idx, desc)
+		if err != nil {
+			return nil, err
+		}
+		if mf != nil && mf.Subject.Digest == subject.Digest && mf.ArtifactType == ArtifactType {
+			signatures = append(signatures, img)
+		}
+	}
+	return signatures, nil
+}
+
+// indexSubjects returns the images of idx which don't refer to another manifest.
+func indexSubjects(idx v1.ImageIndex) ([]v1.Descriptor, error) {
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	var subjects []v1.Descriptor
+	for _, desc := range mf.Manifests {
+		if !desc.MediaType.IsImage() {
+			continue
+		}
+		_, rmf, err := referrer(idx, desc)
+		if err != nil {
+			return nil, err
+		}
+		if rmf == nil {
+			subjects = append(subjects, subjectOf(desc))
+		}
+	}
+	return subjects, nil
+}
+
+// referrer returns the image desc of idx and its manifest if it refers to another manifest.
+func referrer(idx v1.ImageIndex, desc v1.Descriptor) (v1.Image, *v1.Manifest, error) {
+	if !desc.MediaType.IsImage() {
+		return nil, nil, nil
+	}
+	img, err := idx.Image(desc.Digest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get image %s: %w", desc.Digest, err)
+	}
+	mf, err := img.Manifest()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get manifest %s: %w", desc.Digest, err)
+	}
+	if mf.Subject == nil {
+		return img, nil, nil
+	}
+	return img, mf, nil
+}
+
+// subjectOf returns the fields of desc identifying a manifest.
+func subjectOf(desc v1.Descriptor) v1.Descriptor {
+	return v1.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}
+}
+
+// RefLayoutStore reads from a consolidated OCI layout, where manifests are named by their ref name
+// annotation and signatures are held untagged in the same layout.
+type RefLayoutStore struct {
+	path string
+}
+
+// NewRefLayoutStore returns a store for the consolidated OCI layout at path.
+func NewRefLayoutStore(path string) *RefLayoutStore {
+	return &RefLayoutStore{path: path}
+}
+
+func (s *RefLayoutStore) Subjects(_ context.Context, tag string) ([]v1.Descriptor, error) {
+	idx, desc, err := mirrortuf.RefLayoutManifest(s.path, tag)
+	if err != nil {
+		return nil, err
+	}
+	if !desc.MediaType.IsIndex() {
+		return []v1.Descriptor{subjectOf(desc)}, nil
+	}
+	child, err := idx.ImageIndex(desc.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index %s: %w", tag, err)
+	}
+	return indexSubjects(child)
+}
+
+func (s *RefLayoutStore) Index(_ context.Context, tag string) (v1.Descriptor, error) {
+	_, desc, err := mirrortuf.RefLayoutManifest(s.path, tag)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	if !desc.MediaType.IsIndex() {
+		return v1.Descriptor{}, fmt.Errorf("%s is not an index: %s", tag, desc.MediaType)
+	}
+	return subjectOf(desc), nil
+}
+
+func (s *RefLayoutStore) Signatures(_ context.Context, _ string, subject v1.Descriptor) ([]v1.Image, error) {
+	idx, err := layout.ImageIndexFromPath(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OCI layout: %w", err)
+	}
+	return indexSignatures(idx, subject)
+}
@@ -0,0 +1,182 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package tags maps TUF targets and delegated roles to the tags of their mirrored images.
+package tags
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+const (
+	// DefaultTargetTemplate tags top-level targets <sha256>.<path>, as the TUF client expects.
+	DefaultTargetTemplate = "{{.Hash}}.{{.Name}}"
+	// DefaultDelegatedTemplate tags delegated metadata and target indexes with the role name.
+	DefaultDelegatedTemplate = "{{.Role}}"
+
+	// TargetTemplateAnnotation records the target tag template on the metadata image.
+	TargetTemplateAnnotation = "dev.docker.go-tuf-mirror.tag.target"
+	// DelegatedTemplateAnnotation records the delegated role tag template on the metadata image.
+	DelegatedTemplateAnnotation = "dev.docker.go-tuf-mirror.tag.delegated"
+	// RoleAnnotation records the role of a delegated metadata image or target index.
+	RoleAnnotation = "dev.docker.go-tuf-mirror.role"
+
+	// LatestTag tags the most recent snapshot index, and is reserved.
+	LatestTag = "latest"
+	// LockTag tags the lock artifact in a destination repository, and is reserved.
+	LockTag = "go-tuf-mirror-lock"
+	// RootChainTag tags the root chain artifact, and is reserved.
+	RootChainTag = "root-chain"
+)
+
+var (
+	// see https://github.com/opencontainers/distribution-spec/blob/main/spec.md#pulling-manifests
+	validTag = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+	// snapshot indexes are tagged snapshot-<version>
+	snapshotTag = regexp.MustCompile(`^snapshot-[0-9]+$`)
+)
+
+// Reserved reports whether tag is used by the mirror itself, for snapshot indexes, locks or root chains, so target
+// images and delegated roles can't be tagged with it.
+func Reserved(tag string) bool {
+	return tag == LatestTag || tag == LockTag || tag == RootChainTag || snapshotTag.MatchString(tag)
+}
+
+var funcs = template.FuncMap{
+	"trunc": func(n int, s string) string {
+		if n < len(s) {
+			return s[:n]
+		}
+		return s
+	},
+	"replace": func(old, new, s string) string {
+		return strings.ReplaceAll(s, old, new)
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// Fields are the values available to tag templates.
+type Fields struct {
+	// Role is the targets role, "targets" for top-level targets.
+	Role string
+	// Hash is the hex encoded sha256 of a target file, empty for delegated roles.
+	Hash string
+	// Name is the target path, empty for delegated roles.
+	Name string
+}
+
+// Scheme computes tags from templates.
+type Scheme struct {
+	targetText    string
+	delegatedText string
+	target        *template.Template
+	delegated     *template.Template
+}
+
+// NewScheme parses the target and delegated role tag templates.
+func NewScheme(target, delegated string) (*Scheme, error) {
+	t, err := template.New("target").Funcs(funcs).Option("missingkey=error").Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse target tag template: %w", err)
+	}
+	d, err := template.New("delegated").Funcs(funcs).Option("missingkey=error").Parse(delegated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse delegated tag template: %w", err)
+	}
+	return &Scheme{targetText: target, delegatedText: delegated, target: t, delegated: d}, nil
+}
+
+// Default returns the scheme used when none is configured.
+func Default() *Scheme {
+	s, err := NewScheme(DefaultTargetTemplate, DefaultDelegatedTemplate)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// FromAnnotations returns the scheme recorded in the annotations of a metadata image, or the default
+// scheme for images mirrored before schemes were recorded.
+func FromAnnotations(annotations map[string]string) (*Scheme, error) {
+	target, ok := annotations[TargetTemplateAnnotation]
+	if !ok {
+		target = DefaultTargetTemplate
+	}
+	delegated, ok := annotations[DelegatedTemplateAnnotation]
+	if !ok {
+		delegated = DefaultDelegatedTemplate
+	}
+	return NewScheme(target, delegated)
+}
+
+// Annotations returns the annotations recording the scheme.
+func (s *Scheme) Annotations() map[string]string {
+	return map[string]string{
+		TargetTemplateAnnotation:    s.targetText,
+		DelegatedTemplateAnnotation: s.delegatedText,
+	}
+}
+
+// Target returns the tag of a top-level target image.
+func (s *Scheme) Target(hash, name string) (string, error) {
+	return execute(s.target, Fields{Role: metadata.TARGETS, Hash: hash, Name: name})
+}
+
+// TargetFromFilename returns the tag of a top-level target image from its TUF filename (<sha256>.<path>).
+func (s *Scheme) TargetFromFilename(filename string) (string, error) {
+	hash, name, ok := strings.Cut(filename, ".")
+	if !ok {
+		return "", fmt.Errorf("invalid target filename: %s", filename)
+	}
+	return s.Target(hash, name)
+}
+
+// Snapshot returns the reserved tag of the snapshot index for a TUF snapshot version.
+func (s *Scheme) Snapshot(version int64) string {
+	return fmt.Sprintf("snapshot-%d", version)
+}
+
+// Latest returns the reserved tag of the most recent snapshot index.
+func (s *Scheme) Latest() string {
+	return LatestTag
+}
+
+// Delegated returns the tag of the metadata image or target index of a delegated role.
+func (s *Scheme) Delegated(role string) (string, error) {
+	return execute(s.delegated, Fields{Role: role})
+}
+
+func execute(t *template.Template, fields Fields) (string, error) {
+	var b strings.Builder
+	err := t.Execute(&b, fields)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute %s tag template: %w", t.Name(), err)
+	}
+	tag := b.String()
+	if !validTag.MatchString(tag) {
+		return "", fmt.Errorf("invalid %s tag %q, tags must match %s", t.Name(), tag, validTag)
+	}
+	if Reserved(tag) {
+		return "", fmt.Errorf("invalid %s tag %q, %s, %s, %s and snapshot-<version> are reserved", t.Name(), tag, LatestTag, LockTag, RootChainTag)
+	}
+	return tag, nil
+}
@@ -0,0 +1,107 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tags
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const hash = "02119a076ec3878c736c3a95e20794f5a8d5bce3d7ecc264681bb7334ca2e24b"
+
+func TestScheme(t *testing.T) {
+	testCases := []struct {
+		name      string
+		target    string
+		delegated string
+		role      string
+		filename  string
+		expected  string
+		err       string
+	}{
+		{name: "default target", target: DefaultTargetTemplate, filename: hash + ".test.txt", expected: hash + ".test.txt"},
+		{name: "default delegated", delegated: DefaultDelegatedTemplate, role: "test-role", expected: "test-role"},
+		{name: "functions", target: `{{.Role | upper}}-{{.Hash | trunc 8}}-{{.Name | replace "/" "_" | lower}}`, filename: hash + ".Dir/Test.txt", expected: "TARGETS-02119a07-dir_test.txt"},
+		{name: "target path in tag", target: DefaultTargetTemplate, filename: hash + ".dir/test.txt", err: `invalid target tag "` + hash + `.dir/test.txt"`},
+		{name: "too long", target: "{{.Hash}}{{.Hash}}{{.Hash}}", filename: hash + ".test.txt", err: "invalid target tag"},
+		{name: "empty", delegated: "{{if false}}x{{end}}", role: "test-role", err: `invalid delegated tag ""`},
+		{name: "unknown field", delegated: "{{.Version}}", role: "test-role", err: "failed to execute delegated tag template"},
+		{name: "parse error", target: "{{.Hash", filename: hash + ".test.txt", err: "failed to parse target tag template"},
+		{name: "invalid filename", target: DefaultTargetTemplate, filename: "test", err: "invalid target filename: test"},
+		{name: "latest", target: "latest", filename: hash + ".test.txt", err: `invalid target tag "latest", latest, go-tuf-mirror-lock, root-chain and snapshot-<version> are reserved`},
+		{name: "snapshot", target: "snapshot-{{len .Hash}}", filename: hash + ".test.txt", err: `invalid target tag "snapshot-64"`},
+		{name: "lock", delegated: LockTag, role: "test-role", err: `invalid delegated tag "go-tuf-mirror-lock"`},
+		{name: "root chain", delegated: RootChainTag, role: "test-role", err: `invalid delegated tag "root-chain"`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			target, delegated := tc.target, tc.delegated
+			if target == "" {
+				target = DefaultTargetTemplate
+			}
+			if delegated == "" {
+				delegated = DefaultDelegatedTemplate
+			}
+			s, err := NewScheme(target, delegated)
+			var tag string
+			if err == nil && tc.role != "" {
+				tag, err = s.Delegated(tc.role)
+			} else if err == nil {
+				tag, err = s.TargetFromFilename(tc.filename)
+			}
+			if tc.err != "" {
+				require.ErrorContains(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, tag)
+		})
+	}
+}
+
+func TestReserved(t *testing.T) {
+	for tag, reserved := range map[string]bool{
+		LatestTag:       true,
+		LockTag:         true,
+		RootChainTag:    true,
+		"snapshot-7":    true,
+		"snapshot-":     false,
+		"snapshot-v7":   false,
+		"latest-7":      false,
+		"test-role":     false,
+		hash + ".a.txt": false,
+	} {
+		assert.Equal(t, reserved, Reserved(tag), tag)
+	}
+}
+
+func TestFromAnnotations(t *testing.T) {
+	s, err := FromAnnotations(nil)
+	require.NoError(t, err)
+	assert.Equal(t, Default().Annotations(), s.Annotations())
+
+	recorded, err := NewScheme("{{.Hash}}", "role-{{.Role}}")
+	require.NoError(t, err)
+	s, err = FromAnnotations(recorded.Annotations())
+	require.NoError(t, err)
+	assert.Equal(t, recorded.Annotations(), s.Annotations())
+	tag, err := s.Delegated("test-role")
+	require.NoError(t, err)
+	assert.Equal(t, "role-test-role", tag)
+}
@@ -0,0 +1,74 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/require"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+// NewRepository writes a TUF repository with no targets to a temporary directory, under metadata and
+// targets, with a single key for every role and a timestamp expiring at timestampExpires. It returns the
+// directory and the root metadata to trust it with.
+func NewRepository(t *testing.T, timestampExpires time.Time) (string, []byte) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := metadata.KeyFromPublicKey(public)
+	require.NoError(t, err)
+	signer, err := signature.LoadSigner(private, crypto.Hash(0))
+	require.NoError(t, err)
+
+	expires := time.Now().AddDate(1, 0, 0)
+	root := metadata.Root(expires)
+	for _, role := range []string{metadata.ROOT, metadata.TIMESTAMP, metadata.SNAPSHOT, metadata.TARGETS} {
+		require.NoError(t, root.Signed.AddKey(key, role))
+	}
+	targets := metadata.Targets(expires)
+	snapshot := metadata.Snapshot(expires)
+	timestamp := metadata.Timestamp(timestampExpires)
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "metadata"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "targets"), 0o755))
+	write := func(name string, md interface {
+		Sign(signature.Signer) (*metadata.Signature, error)
+		ToFile(string, bool) error
+	},
+	) {
+		_, err := md.Sign(signer)
+		require.NoError(t, err)
+		require.NoError(t, md.ToFile(filepath.Join(dir, "metadata", name), true))
+	}
+	write("1.root.json", root)
+	write("1.targets.json", targets)
+	write("1.snapshot.json", snapshot)
+	write("timestamp.json", timestamp)
+
+	data, err := root.ToBytes(false)
+	require.NoError(t, err)
+	return dir, data
+}
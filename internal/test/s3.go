@@ -0,0 +1,159 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package test holds helpers shared by tests.
+package test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Object is an object stored by S3Server.
+type Object struct {
+	Data         []byte
+	ContentType  string
+	CacheControl string
+}
+
+// S3Server is an in-memory stand-in for an S3-compatible store such as MinIO, serving path-style requests
+// to get, put (with If-None-Match: * or If-Match: <etag>), delete and list (ListObjectsV2) objects.
+type S3Server struct {
+	*httptest.Server
+	mu      sync.Mutex
+	objects map[string]Object
+	puts    []string
+}
+
+// NewS3Server starts an S3Server and points the AWS SDK at it for the rest of the test.
+func NewS3Server(t *testing.T) *S3Server {
+	s := &S3Server{objects: map[string]Object{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	t.Setenv("AWS_ENDPOINT_URL_S3", s.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+	// ignore any AWS config of the user running the tests
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	return s
+}
+
+// Object returns the object at <bucket>/<key>.
+func (s *S3Server) Object(path string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[path]
+	return o, ok
+}
+
+// Puts returns the paths of the objects written, in order.
+func (s *S3Server) Puts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.puts...)
+}
+
+type listBucketResult struct {
+	XMLName     xml.Name        `xml:"ListBucketResult"`
+	Name        string          `xml:"Name"`
+	Prefix      string          `xml:"Prefix"`
+	KeyCount    int             `xml:"KeyCount"`
+	MaxKeys     int             `xml:"MaxKeys"`
+	IsTruncated bool            `xml:"IsTruncated"`
+	Contents    []listedContent `xml:"Contents"`
+}
+
+type listedContent struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+func (s *S3Server) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		prefix := r.URL.Query().Get("prefix")
+		result := listBucketResult{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+		for p, o := range s.objects {
+			if k, ok := strings.CutPrefix(p, bucket+"/"); ok && strings.HasPrefix(k, prefix) {
+				result.Contents = append(result.Contents, listedContent{Key: k, Size: int64(len(o.Data))})
+			}
+		}
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		result.KeyCount = len(result.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		o, ok := s.objects[path]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", o.ContentType)
+		w.Header().Set("Cache-Control", o.CacheControl)
+		w.Header().Set("ETag", etag(o.Data))
+		_, _ = w.Write(o.Data)
+	case r.Method == http.MethodPut:
+		o, ok := s.objects[path]
+		match := r.Header.Get("If-Match")
+		switch {
+		case ok && r.Header.Get("If-None-Match") == "*", ok && match != "" && match != etag(o.Data):
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		case !ok && match != "":
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.objects[path] = Object{Data: data, ContentType: r.Header.Get("Content-Type"), CacheControl: r.Header.Get("Cache-Control")}
+		s.puts = append(s.puts, path)
+		w.Header().Set("ETag", etag(data))
+	case r.Method == http.MethodDelete:
+		delete(s.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// etag returns the quoted MD5 of data, as S3 tags objects written in one part.
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, "<Error><Code>"+code+"</Code></Error>")
+}
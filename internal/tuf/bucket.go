@@ -0,0 +1,85 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tuf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/docker/go-tuf-mirror/internal/bucket"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+// BucketFetcher fetches metadata files from a plain TUF metadata directory in a bucket.
+type BucketFetcher struct {
+	bucket *bucket.Bucket
+}
+
+func NewBucketFetcher(b *bucket.Bucket) *BucketFetcher {
+	return &BucketFetcher{bucket: b}
+}
+
+func (f *BucketFetcher) Fetch(ctx context.Context, _, name string) ([]byte, error) {
+	data, err := f.bucket.Get(ctx, name)
+	if errors.Is(err, bucket.ErrNotFound) {
+		return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	return data, err
+}
+
+// ListBucketTargets returns the targets in a plain TUF targets directory in a bucket, see treeTarget.
+func ListBucketTargets(ctx context.Context, b *bucket.Bucket) ([]TargetEntry, error) {
+	objects, err := b.List(ctx, ".")
+	if err != nil {
+		return nil, err
+	}
+	var targets []TargetEntry
+	for _, o := range objects {
+		t, ok := treeTarget(o.Name, o.Size)
+		if ok {
+			targets = append(targets, t)
+		}
+	}
+	sortTargets(targets)
+	return targets, nil
+}
+
+// treeTarget returns the target stored as name in a plain TUF targets directory, under its consistent
+// snapshot name [<dir>/]<sha256>.<name>. Only top-level targets (without a directory) are attributed to a
+// role, a plain repository doesn't record which delegated role a target belongs to.
+func treeTarget(name string, size int64) (TargetEntry, bool) {
+	dir, file := path.Split(name)
+	hash, targetName, ok := strings.Cut(file, ".")
+	if !ok || len(hash) != 64 {
+		// not a consistent snapshot target, e.g. a lock
+		return TargetEntry{}, false
+	}
+	role := ""
+	if dir == "" {
+		role = metadata.TARGETS
+	}
+	return TargetEntry{
+		Role:   role,
+		Tag:    name,
+		Path:   path.Join(dir, targetName),
+		Size:   size,
+		SHA256: hash,
+	}, true
+}
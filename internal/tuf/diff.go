@@ -0,0 +1,192 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tuf
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+// Difference is a single mismatch between two repositories.
+type Difference struct {
+	// Role is the TUF role the difference was found in.
+	Role string
+	// Field describes what differs, e.g. "version" or "target test.txt length".
+	Field string
+	A     string
+	B     string
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: %s: %s != %s", d.Role, d.Field, d.A, d.B)
+}
+
+const absent = "<none>"
+
+// Diff compares the metadata of two repositories, reporting differences in role versions and expiry,
+// keys and thresholds, target hashes and lengths, and delegated roles present on one side only.
+func Diff(a, b *Repository) []Difference {
+	var diffs []Difference
+	add := func(role, field, va, vb string) {
+		if va != vb {
+			diffs = append(diffs, Difference{Role: role, Field: field, A: va, B: vb})
+		}
+	}
+
+	add(metadata.ROOT, "version", version(a.Root.Signed.Version), version(b.Root.Signed.Version))
+	add(metadata.ROOT, "expires", expires(a.Root.Signed.Expires), expires(b.Root.Signed.Expires))
+	diffs = append(diffs, DiffKeys(a.Root, b.Root)...)
+
+	add(metadata.TIMESTAMP, "version", version(a.Timestamp.Signed.Version), version(b.Timestamp.Signed.Version))
+	add(metadata.TIMESTAMP, "expires", expires(a.Timestamp.Signed.Expires), expires(b.Timestamp.Signed.Expires))
+	add(metadata.SNAPSHOT, "version", version(a.Snapshot.Signed.Version), version(b.Snapshot.Signed.Version))
+	add(metadata.SNAPSHOT, "expires", expires(a.Snapshot.Signed.Expires), expires(b.Snapshot.Signed.Expires))
+
+	for _, role := range unionKeys(a.Targets, b.Targets) {
+		ta, okA := a.Targets[role]
+		tb, okB := b.Targets[role]
+		if !okA || !okB {
+			add(role, "metadata", presentBool(okA), presentBool(okB))
+			continue
+		}
+		add(role, "version", version(ta.Signed.Version), version(tb.Signed.Version))
+		add(role, "expires", expires(ta.Signed.Expires), expires(tb.Signed.Expires))
+		for _, path := range unionKeys(ta.Signed.Targets, tb.Signed.Targets) {
+			fa, okA := ta.Signed.Targets[path]
+			fb, okB := tb.Signed.Targets[path]
+			if !okA || !okB {
+				add(role, "target "+path, presentBool(okA), presentBool(okB))
+				continue
+			}
+			add(role, "target "+path+" length", strconv.FormatInt(fa.Length, 10), strconv.FormatInt(fb.Length, 10))
+			for _, alg := range unionKeys(fa.Hashes, fb.Hashes) {
+				add(role, "target "+path+" "+alg, hash(fa.Hashes, alg), hash(fb.Hashes, alg))
+			}
+		}
+		da, db := delegatedRoles(ta), delegatedRoles(tb)
+		for _, name := range unionKeys(da, db) {
+			ra, okA := da[name]
+			rb, okB := db[name]
+			if !okA || !okB {
+				add(role, "delegation "+name, presentBool(okA), presentBool(okB))
+				continue
+			}
+			add(role, "delegation "+name+" threshold", strconv.Itoa(ra.Threshold), strconv.Itoa(rb.Threshold))
+			add(role, "delegation "+name+" keyids", sortedJoin(ra.KeyIDs), sortedJoin(rb.KeyIDs))
+			add(role, "delegation "+name+" paths", sortedJoin(ra.Paths), sortedJoin(rb.Paths))
+		}
+	}
+	return diffs
+}
+
+// DiffKeys compares the keys of two roots, and the threshold and key IDs of each role.
+func DiffKeys(a, b *metadata.Metadata[metadata.RootType]) []Difference {
+	var diffs []Difference
+	add := func(field, va, vb string) {
+		if va != vb {
+			diffs = append(diffs, Difference{Role: metadata.ROOT, Field: field, A: va, B: vb})
+		}
+	}
+	for _, id := range unionKeys(a.Signed.Keys, b.Signed.Keys) {
+		add("key "+id, present(a.Signed.Keys, id), present(b.Signed.Keys, id))
+	}
+	for _, role := range unionKeys(a.Signed.Roles, b.Signed.Roles) {
+		ra, rb := a.Signed.Roles[role], b.Signed.Roles[role]
+		add(role+" threshold", threshold(ra), threshold(rb))
+		add(role+" keyids", keyIDs(ra), keyIDs(rb))
+	}
+	return diffs
+}
+
+func delegatedRoles(t *metadata.Metadata[metadata.TargetsType]) map[string]metadata.DelegatedRole {
+	roles := map[string]metadata.DelegatedRole{}
+	if t.Signed.Delegations == nil {
+		return roles
+	}
+	for _, r := range t.Signed.Delegations.Roles {
+		roles[r.Name] = r
+	}
+	return roles
+}
+
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func version(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
+
+func expires(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func present[V any](m map[string]V, key string) string {
+	_, ok := m[key]
+	return presentBool(ok)
+}
+
+func presentBool(ok bool) string {
+	if ok {
+		return "present"
+	}
+	return absent
+}
+
+func threshold(r *metadata.Role) string {
+	if r == nil {
+		return absent
+	}
+	return strconv.Itoa(r.Threshold)
+}
+
+func keyIDs(r *metadata.Role) string {
+	if r == nil {
+		return absent
+	}
+	return sortedJoin(r.KeyIDs)
+}
+
+func hash(h metadata.Hashes, alg string) string {
+	v, ok := h[alg]
+	if !ok {
+		return absent
+	}
+	return v.String()
+}
+
+func sortedJoin(s []string) string {
+	s = slices.Clone(s)
+	sort.Strings(s)
+	return "[" + strings.Join(s, ",") + "]"
+}
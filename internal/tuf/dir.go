@@ -0,0 +1,71 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tuf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DirFetcher fetches metadata files from a plain TUF metadata directory on disk.
+type DirFetcher struct {
+	files fs.FS
+}
+
+func NewDirFetcher(dir string) *DirFetcher {
+	return &DirFetcher{files: os.DirFS(dir)}
+}
+
+func (f *DirFetcher) Fetch(_ context.Context, _, name string) ([]byte, error) {
+	data, err := fs.ReadFile(f.files, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	return data, err
+}
+
+// ListDirTargets returns the targets in a plain TUF targets directory on disk, see treeTarget.
+func ListDirTargets(dir string) ([]TargetEntry, error) {
+	var targets []TargetEntry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		t, ok := treeTarget(filepath.ToSlash(name), info.Size())
+		if ok {
+			targets = append(targets, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read targets directory: %w", err)
+	}
+	sortTargets(targets)
+	return targets, nil
+}
@@ -0,0 +1,267 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tuf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/attest/tuf"
+	"github.com/docker/go-tuf-mirror/internal/registry"
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+// maxMetadataLength caps the size of a single metadata file read from a location.
+const maxMetadataLength = 16 << 20
+
+// WebFetcher fetches metadata files from a TUF repository served over http(s).
+type WebFetcher struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewWebFetcher(metadataURL string) *WebFetcher {
+	return &WebFetcher{
+		baseURL: strings.TrimSuffix(metadataURL, "/"),
+		client:  http.DefaultClient,
+	}
+}
+
+func (f *WebFetcher) Fetch(ctx context.Context, _, name string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.baseURL+"/"+name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("failed to get %s: %s", name, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxMetadataLength))
+}
+
+// ImageFetcher fetches metadata files from mirrored metadata images, where the top-level roles are
+// layers of a single image and each delegated role has its own image.
+type ImageFetcher struct {
+	// load returns the image tagged tag, the top-level metadata image if tag is empty
+	load func(ctx context.Context, tag string) (v1.Image, error)
+	// scheme tags delegated role images, as recorded on the top-level metadata image
+	scheme      *tags.Scheme
+	annotations map[string]string
+	files       map[string]map[string][]byte
+	// close releases what the images are loaded from, nil if there's nothing to release
+	close func() error
+}
+
+// NewLayoutFetcher returns a fetcher for metadata saved as OCI layouts under path.
+func NewLayoutFetcher(path string) *ImageFetcher {
+	return &ImageFetcher{
+		load: func(_ context.Context, tag string) (v1.Image, error) {
+			return imageFromLayout(filepath.Join(path, tag))
+		},
+		files: map[string]map[string][]byte{},
+	}
+}
+
+// NewRegistryFetcher returns a fetcher for metadata pushed to imageName, delegated roles are read from
+// the same repository tagged with the role name.
+func NewRegistryFetcher(imageName string) (*ImageFetcher, error) {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse image name: %w", err)
+	}
+	return &ImageFetcher{
+		load: func(ctx context.Context, tag string) (v1.Image, error) {
+			r := ref
+			if tag != "" {
+				r = ref.Context().Tag(tag)
+			}
+			img, err := remote.Image(r, registry.Options(ctx)...)
+			if isNotFound(err) {
+				return nil, fmt.Errorf("%s: %w", r.Name(), ErrNotFound)
+			}
+			return img, err
+		},
+		files: map[string]map[string][]byte{},
+	}, nil
+}
+
+func (f *ImageFetcher) Fetch(ctx context.Context, role, name string) ([]byte, error) {
+	// the top-level image is loaded first, it records how delegated role images are tagged
+	files, err := f.imageFiles(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	if isDelegatedRole(role) {
+		tag, err := f.scheme.Delegated(role)
+		if err != nil {
+			return nil, err
+		}
+		files, err = f.imageFiles(ctx, tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	data, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	return data, nil
+}
+
+// Scheme returns the tag scheme recorded on the top-level metadata image, nil until metadata has been fetched.
+func (f *ImageFetcher) Scheme() *tags.Scheme {
+	return f.scheme
+}
+
+// Annotations returns the annotations of the top-level metadata image, nil until metadata has been fetched
+// or if there's no image.
+func (f *ImageFetcher) Annotations() map[string]string {
+	return f.annotations
+}
+
+// Close releases what the fetcher reads images from, such as an extracted archive.
+func (f *ImageFetcher) Close() error {
+	if f.close == nil {
+		return nil
+	}
+	return f.close()
+}
+
+// imageFiles returns the files of the image tagged tag, loading it on first use.
+func (f *ImageFetcher) imageFiles(ctx context.Context, tag string) (map[string][]byte, error) {
+	if files, ok := f.files[tag]; ok {
+		return files, nil
+	}
+	files := map[string][]byte{}
+	img, err := f.load(ctx, tag)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		// remember missing images so each file lookup doesn't go back to the location
+	case err != nil:
+		return nil, err
+	default:
+		files, err = imageFiles(img)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tag == "" {
+		f.annotations, err = imageAnnotations(img)
+		if err != nil {
+			return nil, err
+		}
+		f.scheme, err = tags.FromAnnotations(f.annotations)
+		if err != nil {
+			return nil, err
+		}
+	}
+	f.files[tag] = files
+	return files, nil
+}
+
+// imageAnnotations returns the annotations of the top-level metadata image img (which may be nil).
+func imageAnnotations(img v1.Image) (map[string]string, error) {
+	if img == nil {
+		return nil, nil
+	}
+	mf, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+	return mf.Annotations, nil
+}
+
+// imageFiles returns the contents of each layer of img keyed by its TUF filename annotation.
+func imageFiles(img v1.Image) (map[string][]byte, error) {
+	mf, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+	files := map[string][]byte{}
+	for _, desc := range mf.Layers {
+		filename, ok := desc.Annotations[tuf.TUFFileNameAnnotation]
+		if !ok {
+			continue
+		}
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer %s: %w", filename, err)
+		}
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer %s: %w", filename, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxMetadataLength))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer %s: %w", filename, err)
+		}
+		files[filename] = data
+	}
+	return files, nil
+}
+
+// imageFromLayout returns the first image of the OCI layout at path.
+func imageFromLayout(path string) (v1.Image, error) {
+	idx, err := layout.ImageIndexFromPath(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OCI layout: %w", err)
+	}
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	if len(mf.Manifests) == 0 {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+	}
+	return idx.Image(mf.Manifests[0].Digest)
+}
+
+func isDelegatedRole(role string) bool {
+	switch role {
+	case metadata.ROOT, metadata.TIMESTAMP, metadata.SNAPSHOT, metadata.TARGETS, "":
+		return false
+	}
+	return true
+}
+
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
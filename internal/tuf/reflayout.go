@@ -0,0 +1,149 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tuf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+// RefNameAnnotation names the manifests of a consolidated OCI layout, which holds every tag in a single
+// layout rather than a layout per tag.
+const RefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// IsRefLayout reports whether the OCI layout at path is a consolidated layout, naming its manifests with
+// ref name annotations, rather than the top-level layout of a layout per tag.
+func IsRefLayout(path string) bool {
+	idx, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return false
+	}
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return false
+	}
+	for _, desc := range mf.Manifests {
+		if _, ok := desc.Annotations[RefNameAnnotation]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RefLayoutManifest returns the index of the consolidated OCI layout at path and the descriptor of the
+// manifest named tag in it, the top-level metadata image (named latest) if tag is empty.
+func RefLayoutManifest(path, tag string) (v1.ImageIndex, v1.Descriptor, error) {
+	if tag == "" {
+		tag = tags.LatestTag
+	}
+	idx, err := layout.ImageIndexFromPath(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, v1.Descriptor{}, fmt.Errorf("%s: %w", path, ErrNotFound)
+	}
+	if err != nil {
+		return nil, v1.Descriptor{}, fmt.Errorf("failed to load OCI layout: %w", err)
+	}
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return nil, v1.Descriptor{}, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	for _, desc := range mf.Manifests {
+		if desc.Annotations[RefNameAnnotation] == tag {
+			return idx, desc, nil
+		}
+	}
+	return nil, v1.Descriptor{}, fmt.Errorf("%s:%s: %w", path, tag, ErrNotFound)
+}
+
+// NewRefLayoutFetcher returns a fetcher for metadata saved to the consolidated OCI layout at path, close
+// (which may be nil) is called when the fetcher is closed.
+func NewRefLayoutFetcher(path string, close func() error) *ImageFetcher {
+	return &ImageFetcher{
+		load: func(_ context.Context, tag string) (v1.Image, error) {
+			return imageFromRefLayout(path, tag)
+		},
+		files: map[string]map[string][]byte{},
+		close: close,
+	}
+}
+
+// imageFromRefLayout returns the image named tag in the consolidated OCI layout at path.
+func imageFromRefLayout(path, tag string) (v1.Image, error) {
+	idx, desc, err := RefLayoutManifest(path, tag)
+	if err != nil {
+		return nil, err
+	}
+	if !desc.MediaType.IsImage() {
+		return nil, fmt.Errorf("%s:%s is not an image: %s", path, tag, desc.MediaType)
+	}
+	return idx.Image(desc.Digest)
+}
+
+// ListRefLayoutTargets returns the targets mirrored to the consolidated OCI layout at path.
+func ListRefLayoutTargets(path string) ([]TargetEntry, error) {
+	idx, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OCI layout: %w", err)
+	}
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	var targets []TargetEntry
+	for _, desc := range mf.Manifests {
+		// referrers are written untagged
+		tag, ok := desc.Annotations[RefNameAnnotation]
+		if !ok {
+			continue
+		}
+		var found []TargetEntry
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := idx.ImageIndex(desc.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get index %s: %w", tag, err)
+			}
+			cmf, err := child.IndexManifest()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get index manifest %s: %w", tag, err)
+			}
+			found, err = indexTargets(child, cmf, tag)
+			if err != nil {
+				return nil, err
+			}
+		case desc.MediaType.IsImage():
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get image %s: %w", tag, err)
+			}
+			found, err = imageTargets(img, metadata.TARGETS, tag, "")
+			if err != nil {
+				return nil, err
+			}
+		}
+		targets = append(targets, found...)
+	}
+	sortTargets(targets)
+	return targets, nil
+}
@@ -0,0 +1,160 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tuf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+// ErrNotFound is returned by a Fetcher when a metadata file does not exist at the location.
+var ErrNotFound = errors.New("metadata file not found")
+
+// Fetcher retrieves raw TUF metadata files from a mirror location.
+type Fetcher interface {
+	// Fetch returns the contents of the metadata file name for role.
+	Fetch(ctx context.Context, role, name string) ([]byte, error)
+}
+
+// Repository holds the (unverified) TUF metadata published at a location.
+type Repository struct {
+	Root      *metadata.Metadata[metadata.RootType]
+	Timestamp *metadata.Metadata[metadata.TimestampType]
+	Snapshot  *metadata.Metadata[metadata.SnapshotType]
+	// Targets maps role names to targets metadata, including the top-level targets role.
+	Targets map[string]*metadata.Metadata[metadata.TargetsType]
+}
+
+// LoadRepository reads the latest root, timestamp, snapshot, targets and any available delegated targets metadata using f.
+// Signatures are not verified, the result is intended for reporting on what a location holds.
+func LoadRepository(ctx context.Context, f Fetcher) (*Repository, error) {
+	return loadRepository(ctx, f, false)
+}
+
+// LoadAvailable reads the metadata at a location like LoadRepository, leaving out the timestamp, snapshot
+// and targets roles that are missing, e.g. because publishing them was interrupted. It fails with
+// ErrNotFound only if there's no root.
+func LoadAvailable(ctx context.Context, f Fetcher) (*Repository, error) {
+	return loadRepository(ctx, f, true)
+}
+
+func loadRepository(ctx context.Context, f Fetcher, partial bool) (*Repository, error) {
+	root, err := LoadRoot(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	repo := &Repository{Root: root, Targets: map[string]*metadata.Metadata[metadata.TargetsType]{}}
+	missing := func(err error) bool {
+		return partial && errors.Is(err, ErrNotFound)
+	}
+
+	// without a timestamp or snapshot, the roles they list are looked up by their unversioned name
+	var meta map[string]*metadata.MetaFiles
+	data, err := f.Fetch(ctx, metadata.TIMESTAMP, fmt.Sprintf("%s.json", metadata.TIMESTAMP))
+	switch {
+	case missing(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to fetch timestamp metadata: %w", err)
+	default:
+		repo.Timestamp, err = metadata.Timestamp().FromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse timestamp metadata: %w", err)
+		}
+		meta = repo.Timestamp.Signed.Meta
+	}
+
+	data, err = fetchVersioned(ctx, f, metadata.SNAPSHOT, meta)
+	meta = nil
+	switch {
+	case missing(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to fetch snapshot metadata: %w", err)
+	default:
+		repo.Snapshot, err = metadata.Snapshot().FromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse snapshot metadata: %w", err)
+		}
+		meta = repo.Snapshot.Signed.Meta
+	}
+
+	data, err = fetchVersioned(ctx, f, metadata.TARGETS, meta)
+	if missing(err) {
+		return repo, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch targets metadata: %w", err)
+	}
+	targets, err := metadata.Targets().FromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse targets metadata: %w", err)
+	}
+	repo.Targets[metadata.TARGETS] = targets
+
+	// delegated roles are optional, a mirror without delegates simply won't have them
+	if targets.Signed.Delegations == nil {
+		return repo, nil
+	}
+	for _, role := range targets.Signed.Delegations.Roles {
+		data, err := fetchVersioned(ctx, f, role.Name, meta)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch delegated role %s metadata: %w", role.Name, err)
+		}
+		delegated, err := metadata.Targets().FromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse delegated role %s metadata: %w", role.Name, err)
+		}
+		repo.Targets[role.Name] = delegated
+	}
+	return repo, nil
+}
+
+// LoadRoot reads the latest root using f, walking the root chain until the next version is missing. The
+// signatures are not verified.
+func LoadRoot(ctx context.Context, f Fetcher) (*metadata.Metadata[metadata.RootType], error) {
+	var root *metadata.Metadata[metadata.RootType]
+	for version := 1; ; version++ {
+		data, err := f.Fetch(ctx, metadata.ROOT, fmt.Sprintf("%d.%s.json", version, metadata.ROOT))
+		if errors.Is(err, ErrNotFound) && root != nil {
+			return root, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch root metadata: %w", err)
+		}
+		root, err = metadata.Root().FromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse root metadata: %w", err)
+		}
+	}
+}
+
+// fetchVersioned fetches the consistent snapshot name (<version>.<role>.json) of a role, falling back to <role>.json.
+func fetchVersioned(ctx context.Context, f Fetcher, role string, meta map[string]*metadata.MetaFiles) ([]byte, error) {
+	name := fmt.Sprintf("%s.json", role)
+	if m, ok := meta[name]; ok {
+		data, err := f.Fetch(ctx, role, fmt.Sprintf("%d.%s", m.Version, name))
+		if !errors.Is(err, ErrNotFound) {
+			return data, err
+		}
+	}
+	return f.Fetch(ctx, role, name)
+}
@@ -0,0 +1,72 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tuf
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"github.com/theupdateframework/go-tuf/v2/metadata/trustedmetadata"
+)
+
+// ErrRollback is returned when publishing would replace metadata with an older version.
+var ErrRollback = errors.New("metadata rollback")
+
+// Rollback describes a role whose version would go backwards if published.
+type Rollback struct {
+	Role string
+	// Current is the version at the destination.
+	Current int64
+	// New is the version to be published.
+	New int64
+}
+
+func (r Rollback) String() string {
+	return fmt.Sprintf("%s: version %d at destination, %d to be published", r.Role, r.Current, r.New)
+}
+
+// CheckRollback returns the roles in md whose version is lower than in current.
+func CheckRollback(current *Repository, md trustedmetadata.TrustedMetadata) []Rollback {
+	var rollbacks []Rollback
+	check := func(role string, current, published int64) {
+		if published < current {
+			rollbacks = append(rollbacks, Rollback{Role: role, Current: current, New: published})
+		}
+	}
+	if current.Root != nil && md.Root != nil {
+		check(metadata.ROOT, current.Root.Signed.Version, md.Root.Signed.Version)
+	}
+	if current.Timestamp != nil && md.Timestamp != nil {
+		check(metadata.TIMESTAMP, current.Timestamp.Signed.Version, md.Timestamp.Signed.Version)
+	}
+	if current.Snapshot != nil && md.Snapshot != nil {
+		check(metadata.SNAPSHOT, current.Snapshot.Signed.Version, md.Snapshot.Signed.Version)
+	}
+	roles := make([]string, 0, len(md.Targets))
+	for role := range md.Targets {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		if t, ok := current.Targets[role]; ok {
+			check(role, t.Signed.Version, md.Targets[role].Signed.Version)
+		}
+	}
+	return rollbacks
+}
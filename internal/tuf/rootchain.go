@@ -0,0 +1,126 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tuf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/attest/tuf"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"github.com/theupdateframework/go-tuf/v2/metadata/trustedmetadata"
+)
+
+// RootChainArtifactType is the artifact type of the root chain artifact, holding a <version>.root.json layer
+// for each root version.
+const RootChainArtifactType = "application/vnd.docker.go-tuf-mirror.root-chain.v1+json"
+
+// ErrRootChain is returned when a root chain artifact doesn't match its pinned digest or its roots don't
+// verify.
+var ErrRootChain = errors.New("invalid root chain")
+
+// RootChain returns the roots held by the root chain artifact img, ordered by version. Each layer is checked
+// against its digest.
+func RootChain(img v1.Image) ([][]byte, error) {
+	mf, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+	if mf.ArtifactType != RootChainArtifactType {
+		return nil, fmt.Errorf("%w: unexpected artifact type %q", ErrRootChain, mf.ArtifactType)
+	}
+	versions := map[int64][]byte{}
+	for _, desc := range mf.Layers {
+		filename := desc.Annotations[tuf.TUFFileNameAnnotation]
+		version, err := rootVersion(filename)
+		if err != nil {
+			return nil, err
+		}
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer %s: %w", filename, err)
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer %s: %w", filename, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxMetadataLength))
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer %s: %w", filename, err)
+		}
+		digest, _, err := v1.SHA256(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to digest layer %s: %w", filename, err)
+		}
+		if digest != desc.Digest {
+			return nil, fmt.Errorf("%w: %s doesn't match its digest %s", ErrRootChain, filename, desc.Digest)
+		}
+		versions[version] = data
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: no roots", ErrRootChain)
+	}
+	order := make([]int64, 0, len(versions))
+	for version := range versions {
+		order = append(order, version)
+	}
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+	roots := make([][]byte, len(order))
+	for i, version := range order {
+		roots[i] = versions[version]
+	}
+	return roots, nil
+}
+
+// rootVersion returns the version of a root file named <version>.root.json.
+func rootVersion(filename string) (int64, error) {
+	v, ok := strings.CutSuffix(filename, "."+metadata.ROOT+".json")
+	if ok {
+		version, err := strconv.ParseInt(v, 10, 64)
+		if err == nil && version > 0 {
+			return version, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unexpected root file %q", ErrRootChain, filename)
+}
+
+// VerifyRootChain verifies roots, ordered by version, as a TUF client updating from the first would: each
+// root must be signed by a threshold of its own keys and of the previous root's, with consecutive versions.
+// It returns the last root.
+func VerifyRootChain(roots [][]byte) ([]byte, error) {
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("%w: no roots", ErrRootChain)
+	}
+	trusted, err := trustedmetadata.New(roots[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRootChain, err)
+	}
+	for _, data := range roots[1:] {
+		_, err = trusted.UpdateRoot(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRootChain, err)
+		}
+	}
+	return roots[len(roots)-1], nil
+}
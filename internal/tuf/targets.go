@@ -0,0 +1,214 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package tuf
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/attest/tuf"
+	"github.com/docker/go-tuf-mirror/internal/registry"
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+const (
+	TargetMediaType = "application/vnd.tuf.target"
+	// MetadataMediaType is the media type of TUF metadata layers.
+	MetadataMediaType = "application/vnd.tuf.metadata+json"
+	// MetadataArtifactType is the artifact type of the TUF metadata attached to target images as referrers.
+	MetadataArtifactType = "application/vnd.docker.go-tuf-mirror.metadata.v1+json"
+
+	// SnapshotVersionAnnotation records the TUF snapshot version on a snapshot index.
+	SnapshotVersionAnnotation = "dev.docker.go-tuf-mirror.snapshot.version"
+	// TargetAnnotation records the target path of each target image in a snapshot index.
+	TargetAnnotation = "dev.docker.go-tuf-mirror.target"
+)
+
+// isSnapshotIndex reports whether mf is a snapshot index, which references targets listed under their own tags.
+func isSnapshotIndex(mf *v1.IndexManifest) bool {
+	_, ok := mf.Annotations[SnapshotVersionAnnotation]
+	return ok
+}
+
+// TargetEntry describes a mirrored target file.
+type TargetEntry struct {
+	// Role is the targets role the file belongs to.
+	Role string `json:"role"`
+	// Tag is the tag (or OCI layout directory) of the image or delegated index holding the target.
+	Tag string `json:"tag"`
+	// Path is the TUF target path.
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ListLayoutTargets returns the targets mirrored to OCI layouts under dir.
+func ListLayoutTargets(dir string) ([]TargetEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read targets directory: %w", err)
+	}
+	var targets []TargetEntry
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		idx, err := layout.ImageIndexFromPath(filepath.Join(dir, e.Name()))
+		if err != nil {
+			// not an OCI layout
+			continue
+		}
+		mf, err := idx.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get index manifest for %s: %w", e.Name(), err)
+		}
+		found, err := indexTargets(idx, mf, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, found...)
+	}
+	sortTargets(targets)
+	return targets, nil
+}
+
+// ListRegistryTargets returns the targets mirrored to a registry repository.
+func ListRegistryTargets(ctx context.Context, repository string) ([]TargetEntry, error) {
+	repo, err := name.NewRepository(repository)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repository: %w", err)
+	}
+	options := registry.Options(ctx)
+	tags, err := remote.List(repo, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	var targets []TargetEntry
+	for _, tag := range tags {
+		desc, err := remote.Get(repo.Tag(tag), options...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", tag, err)
+		}
+		var found []TargetEntry
+		if desc.MediaType.IsIndex() {
+			idx, err := desc.ImageIndex()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get index %s: %w", tag, err)
+			}
+			mf, err := idx.IndexManifest()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get index manifest %s: %w", tag, err)
+			}
+			found, err = indexTargets(idx, mf, tag)
+			if err != nil {
+				return nil, err
+			}
+		} else if desc.MediaType.IsImage() {
+			img, err := desc.Image()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get image %s: %w", tag, err)
+			}
+			found, err = imageTargets(img, metadata.TARGETS, tag, "")
+			if err != nil {
+				return nil, err
+			}
+		}
+		targets = append(targets, found...)
+	}
+	sortTargets(targets)
+	return targets, nil
+}
+
+// indexTargets returns the targets of an index which is either a wrapper around a single top-level
+// target image (OCI layouts) or a delegated role index whose manifests are annotated with target file names.
+func indexTargets(idx v1.ImageIndex, mf *v1.IndexManifest, tag string) ([]TargetEntry, error) {
+	if isSnapshotIndex(mf) {
+		return nil, nil
+	}
+	// the delegated role is recorded on the index, older mirrors tagged the index with the role name
+	delegatedRole, ok := mf.Annotations[tags.RoleAnnotation]
+	if !ok {
+		delegatedRole = tag
+	}
+	var targets []TargetEntry
+	for _, desc := range mf.Manifests {
+		if !desc.MediaType.IsImage() {
+			continue
+		}
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get image %s: %w", desc.Digest, err)
+		}
+		role, dir := metadata.TARGETS, ""
+		if filename, ok := desc.Annotations[tuf.TUFFileNameAnnotation]; ok {
+			role, dir = delegatedRole, path.Dir(filename)
+		}
+		found, err := imageTargets(img, role, tag, dir)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, found...)
+	}
+	return targets, nil
+}
+
+// imageTargets returns the target file layers of img, dir is prepended to the target path of delegated targets.
+func imageTargets(img v1.Image, role, tag, dir string) ([]TargetEntry, error) {
+	mf, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest for %s: %w", tag, err)
+	}
+	var targets []TargetEntry
+	for _, layer := range mf.Layers {
+		if layer.MediaType != TargetMediaType {
+			continue
+		}
+		filename := layer.Annotations[tuf.TUFFileNameAnnotation]
+		// target files are named <sha256>.<path>
+		targetPath := strings.TrimPrefix(filename, layer.Digest.Hex+".")
+		if dir != "" {
+			targetPath = path.Join(dir, targetPath)
+		}
+		targets = append(targets, TargetEntry{
+			Role:   role,
+			Tag:    tag,
+			Path:   targetPath,
+			Size:   layer.Size,
+			SHA256: layer.Digest.Hex,
+		})
+	}
+	return targets, nil
+}
+
+func sortTargets(targets []TargetEntry) {
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Role != targets[j].Role {
+			return targets[i].Role < targets[j].Role
+		}
+		return targets[i].Path < targets[j].Path
+	})
+}
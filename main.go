@@ -28,6 +28,6 @@ var version = ""
 func main() {
 	if err := cmd.Execute(version); err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
@@ -0,0 +1,549 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/attest/mirror"
+	"github.com/docker/go-tuf-mirror/internal/lock"
+	"github.com/docker/go-tuf-mirror/internal/signing"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// ArchiveFormat is the format of an archive destination or source.
+type ArchiveFormat string
+
+const (
+	// OCIArchive is a tar archive of a consolidated OCI layout, oci-archive://<path> (or tar://<path>).
+	OCIArchive ArchiveFormat = "oci-archive"
+	// DockerArchive is an OCIArchive which also names its images in a docker manifest.json, as docker save
+	// does, docker-archive://<path>.
+	DockerArchive ArchiveFormat = "docker-archive"
+)
+
+// ArchiveDestination writes a single tar archive holding a consolidated OCI layout, where each manifest is
+// named by its tag with a ref name annotation and the top-level metadata image is named latest. The
+// archive is extracted to a temporary directory on first use and packed again, replacing it, when the
+// destination is closed.
+type ArchiveDestination struct {
+	format  ArchiveFormat
+	archive *archive
+	written bool
+}
+
+// NewArchiveDestination returns the destination writing the archive at path in format.
+func NewArchiveDestination(format ArchiveFormat, path string) *ArchiveDestination {
+	return &ArchiveDestination{format: format, archive: &archive{path: path}}
+}
+
+func (d *ArchiveDestination) String() string {
+	return string(d.format) + "://" + d.archive.path
+}
+
+func (d *ArchiveDestination) Location(tag string) string {
+	return refLocation(d.archive.path, tag)
+}
+
+func (d *ArchiveDestination) LockKey() string {
+	return filepath.Clean(d.archive.path)
+}
+
+func (d *ArchiveDestination) Lock(ctx context.Context, opts LockOptions) (func(context.Context) error, error) {
+	lk, err := lock.AcquireFile(ctx, d.LockKey(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return lk.Release, nil
+}
+
+func (d *ArchiveDestination) WriteImage(_ context.Context, tag string, img v1.Image, referrers []v1.Image) error {
+	dir, err := d.archive.extract()
+	if err != nil {
+		return err
+	}
+	d.written = true
+	return writeRefImage(dir, tag, img, referrers)
+}
+
+func (d *ArchiveDestination) WriteIndex(_ context.Context, tag string, idx v1.ImageIndex, referrers []v1.Image) error {
+	dir, err := d.archive.extract()
+	if err != nil {
+		return err
+	}
+	d.written = true
+	return writeRefIndex(dir, tag, idx, referrers)
+}
+
+func (d *ArchiveDestination) Tag(_ context.Context, tag, target string) error {
+	dir, err := d.archive.extract()
+	if err != nil {
+		return err
+	}
+	d.written = true
+	return tagRef(dir, tag, target)
+}
+
+func (d *ArchiveDestination) Fetcher() (Fetcher, error) {
+	dir, err := d.archive.extract()
+	if err != nil {
+		return nil, err
+	}
+	// the extracted archive is removed when the destination is closed
+	return mirrortuf.NewRefLayoutFetcher(dir, nil), nil
+}
+
+func (d *ArchiveDestination) Targets(_ context.Context) ([]TargetEntry, error) {
+	dir, err := d.archive.extract()
+	if err != nil {
+		return nil, err
+	}
+	return mirrortuf.ListRefLayoutTargets(dir)
+}
+
+func (d *ArchiveDestination) Signatures() (SignatureStore, error) {
+	dir, err := d.archive.extract()
+	if err != nil {
+		return nil, err
+	}
+	return signing.NewRefLayoutStore(dir), nil
+}
+
+// Close packs the archive if anything was written to it, and removes the extracted layout.
+func (d *ArchiveDestination) Close() error {
+	if d.archive.dir == "" {
+		return nil
+	}
+	var err error
+	if d.written {
+		err = d.pack()
+		d.written = false
+	}
+	return errors.Join(err, d.archive.remove())
+}
+
+// pack replaces the archive with a tar of the extracted layout, written to a temporary file next to it
+// first so readers never see a partial archive.
+func (d *ArchiveDestination) pack() error {
+	p, err := layout.FromPath(d.archive.dir)
+	if err != nil {
+		return fmt.Errorf("failed to open OCI layout: %w", err)
+	}
+	var manifest tarball.Manifest
+	if d.format == DockerArchive {
+		manifest, err = dockerManifest(p, dockerRepository(d.archive.path))
+		if err != nil {
+			return err
+		}
+	}
+	err = os.MkdirAll(filepath.Dir(d.archive.path), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(d.archive.path), "."+filepath.Base(d.archive.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer os.Remove(f.Name())
+	err = writeArchive(f, p, manifest)
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		return fmt.Errorf("failed to write archive %s: %w", d.archive.path, err)
+	}
+	err = os.Chmod(f.Name(), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write archive %s: %w", d.archive.path, err)
+	}
+	err = os.Rename(f.Name(), d.archive.path)
+	if err != nil {
+		return fmt.Errorf("failed to write archive %s: %w", d.archive.path, err)
+	}
+	return nil
+}
+
+// ArchiveSource is metadata and targets mirrored to archives, oci-archive://<path> or
+// docker-archive://<path>, e.g. by ArchiveDestination. The archives are extracted to temporary
+// directories while the source is open.
+type ArchiveSource struct {
+	servedSource
+	metadataArchive *archive
+	targetsArchive  *archive
+}
+
+// NewArchiveSource returns the source reading metadata and target files from the archives at the given
+// locations.
+func NewArchiveSource(metadataLocation, targetsLocation string) (*ArchiveSource, error) {
+	paths := make([]string, 2)
+	for i, location := range []string{metadataLocation, targetsLocation} {
+		path, ok := archivePath(location)
+		if !ok || path == "" {
+			return nil, invalidArgumentf("invalid source archive: %s", location)
+		}
+		paths[i] = path
+	}
+	s := &ArchiveSource{
+		servedSource: servedSource{
+			metadataLocation: metadataLocation,
+			targetsLocation:  targetsLocation,
+		},
+		metadataArchive: &archive{path: paths[0], existing: true},
+	}
+	s.targetsArchive = s.metadataArchive
+	if filepath.Clean(paths[1]) != filepath.Clean(paths[0]) {
+		s.targetsArchive = &archive{path: paths[1], existing: true}
+	}
+	return s, nil
+}
+
+// Open extracts the archives and serves them on a loopback address.
+func (s *ArchiveSource) Open(ctx context.Context, root []byte, cachePath string) (*mirror.TUFMirror, error) {
+	metadataDir, err := s.metadataArchive.extract()
+	if err != nil {
+		return nil, err
+	}
+	targetsDir, err := s.targetsArchive.extract()
+	if err != nil {
+		return nil, err
+	}
+	s.metadataFiles = imageMetadataFiles(mirrortuf.NewRefLayoutFetcher(metadataDir, nil))
+	s.targetFiles = layoutTargetFiles(targetsDir)
+	return s.servedSource.Open(ctx, root, cachePath)
+}
+
+// Close stops serving the source and removes the extracted archives.
+func (s *ArchiveSource) Close() error {
+	var err error
+	if s.loopback != nil {
+		err = s.servedSource.Close()
+	}
+	return errors.Join(err, s.metadataArchive.remove(), s.targetsArchive.remove())
+}
+
+// newArchiveFetcher returns a fetcher for the metadata in the archive at location, which removes the
+// extracted archive when closed.
+func newArchiveFetcher(location string) (Fetcher, error) {
+	path, ok := archivePath(location)
+	if !ok || path == "" {
+		return nil, invalidArgumentf("invalid metadata archive: %s", location)
+	}
+	a := &archive{path: path, existing: true}
+	dir, err := a.extract()
+	if err != nil {
+		return nil, err
+	}
+	return mirrortuf.NewRefLayoutFetcher(dir, a.remove), nil
+}
+
+// archivePath returns the path of an archive location, <format>://<path>.
+func archivePath(location string) (string, bool) {
+	scheme, path, ok := strings.Cut(location, "://")
+	switch ArchiveFormat(scheme) {
+	case OCIArchive, DockerArchive, "tar":
+		return path, ok
+	}
+	return "", false
+}
+
+// archive is a tar archive of a consolidated OCI layout, extracted to a temporary directory on first use.
+type archive struct {
+	path string
+	// existing archives must be there, missing ones are extracted as an empty layout
+	existing bool
+
+	mu  sync.Mutex
+	dir string
+}
+
+// extract returns the directory the archive is extracted to.
+func (a *archive) extract() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.dir != "" {
+		return a.dir, nil
+	}
+	dir, err := os.MkdirTemp("", "go-tuf-mirror-archive-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	err = extractArchive(a.path, dir, a.existing)
+	if err != nil {
+		return "", errors.Join(fmt.Errorf("failed to extract archive %s: %w", a.path, err), os.RemoveAll(dir))
+	}
+	a.dir = dir
+	return dir, nil
+}
+
+// remove removes the extracted archive, if it was extracted.
+func (a *archive) remove() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(a.dir)
+	a.dir = ""
+	return err
+}
+
+// extractArchive extracts the tar archive file to dir, writing an empty OCI layout there if the archive
+// doesn't exist and existing is false.
+func extractArchive(file, dir string, existing bool) error {
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) && !existing {
+		_, err = layout.Write(dir, empty.Index)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			// directories are created for the files in them, nothing else is part of a layout
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if !fs.ValidPath(name) {
+			return fmt.Errorf("invalid archive entry: %s", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(target), 0o755)
+		if err != nil {
+			return err
+		}
+		err = extractFile(tr, target)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(r io.Reader, target string) error {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return errors.Join(err, f.Close())
+}
+
+// emptyConfig is the config of artifacts, see https://github.com/opencontainers/image-spec/blob/main/manifest.md#guidance-for-an-empty-descriptor
+var (
+	emptyConfig          = []byte("{}")
+	emptyConfigDigest, _ = v1.NewHash("sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a")
+)
+
+// writeArchive writes the OCI layout p to w as a tar archive, with manifest as the docker manifest.json
+// if it isn't nil. Only the blobs reachable from the layout's index are written, so manifests replaced by
+// later runs don't accumulate.
+func writeArchive(w io.Writer, p layout.Path, manifest tarball.Manifest) error {
+	blobs, err := reachableBlobs(p)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	files := []string{"oci-layout", "index.json"}
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(string(p), name))
+		if err != nil {
+			return err
+		}
+		err = writeArchiveFile(tw, name, data)
+		if err != nil {
+			return err
+		}
+	}
+	if manifest != nil {
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			return fmt.Errorf("failed to marshal docker manifest: %w", err)
+		}
+		err = writeArchiveFile(tw, "manifest.json", data)
+		if err != nil {
+			return err
+		}
+	}
+	for _, hash := range blobs {
+		data, err := os.ReadFile(filepath.Join(string(p), filepath.FromSlash(blobPath(hash))))
+		if errors.Is(err, fs.ErrNotExist) && hash == emptyConfigDigest {
+			// the layout writer doesn't store the empty config of artifacts
+			data, err = emptyConfig, nil
+		}
+		if err != nil {
+			return err
+		}
+		err = writeArchiveFile(tw, blobPath(hash), data)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func writeArchiveFile(tw *tar.Writer, name string, data []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o644,
+		Size:     int64(len(data)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// reachableBlobs returns the blobs of the manifests in the index of p and the manifests they reference,
+// sorted.
+func reachableBlobs(p layout.Path) ([]v1.Hash, error) {
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OCI layout: %w", err)
+	}
+	seen := map[v1.Hash]bool{}
+	err = indexBlobs(idx, seen)
+	if err != nil {
+		return nil, err
+	}
+	blobs := make([]v1.Hash, 0, len(seen))
+	for hash := range seen {
+		blobs = append(blobs, hash)
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].String() < blobs[j].String() })
+	return blobs, nil
+}
+
+func indexBlobs(idx v1.ImageIndex, seen map[v1.Hash]bool) error {
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	for _, desc := range mf.Manifests {
+		if seen[desc.Digest] {
+			continue
+		}
+		seen[desc.Digest] = true
+		switch {
+		case desc.MediaType.IsIndex():
+			child, err := idx.ImageIndex(desc.Digest)
+			if err != nil {
+				return fmt.Errorf("failed to get index %s: %w", desc.Digest, err)
+			}
+			err = indexBlobs(child, seen)
+			if err != nil {
+				return err
+			}
+		case desc.MediaType.IsImage():
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				return fmt.Errorf("failed to get image %s: %w", desc.Digest, err)
+			}
+			imf, err := img.Manifest()
+			if err != nil {
+				return fmt.Errorf("failed to get manifest %s: %w", desc.Digest, err)
+			}
+			seen[imf.Config.Digest] = true
+			for _, layer := range imf.Layers {
+				seen[layer.Digest] = true
+			}
+		}
+	}
+	return nil
+}
+
+// dockerManifest returns the docker manifest.json naming the tagged images of p repository:<tag>. Indexes
+// have no docker equivalent and are left out.
+func dockerManifest(p layout.Path, repository string) (tarball.Manifest, error) {
+	idx, err := p.ImageIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OCI layout: %w", err)
+	}
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	manifest := tarball.Manifest{}
+	for _, desc := range mf.Manifests {
+		tag, ok := desc.Annotations[mirrortuf.RefNameAnnotation]
+		if !ok || !desc.MediaType.IsImage() {
+			continue
+		}
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get image %s: %w", tag, err)
+		}
+		imf, err := img.Manifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get manifest %s: %w", tag, err)
+		}
+		d := tarball.Descriptor{
+			Config:   blobPath(imf.Config.Digest),
+			RepoTags: []string{repository + ":" + tag},
+		}
+		for _, layer := range imf.Layers {
+			d.Layers = append(d.Layers, blobPath(layer.Digest))
+		}
+		manifest = append(manifest, d)
+	}
+	return manifest, nil
+}
+
+func blobPath(hash v1.Hash) string {
+	return path.Join("blobs", hash.Algorithm, hash.Hex)
+}
+
+var invalidRepositoryChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// dockerRepository returns the repository docker manifest.json names images with, after the archive file.
+func dockerRepository(file string) string {
+	base := filepath.Base(file)
+	repository := invalidRepositoryChars.ReplaceAllString(strings.ToLower(strings.TrimSuffix(base, filepath.Ext(base))), "-")
+	repository = strings.Trim(repository, "._-")
+	if repository == "" {
+		return "tuf"
+	}
+	return repository
+}
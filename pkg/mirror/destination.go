@@ -0,0 +1,311 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/attest/oci"
+	"github.com/docker/go-tuf-mirror/internal/lock"
+	"github.com/docker/go-tuf-mirror/internal/registry"
+	"github.com/docker/go-tuf-mirror/internal/signing"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+)
+
+type (
+	// LockOptions configures how destinations are locked against concurrent runs.
+	LockOptions = lock.Options
+	// Fetcher reads back TUF metadata files mirrored to a destination.
+	Fetcher = mirrortuf.Fetcher
+	// TargetEntry describes a target file mirrored to a destination.
+	TargetEntry = mirrortuf.TargetEntry
+	// SignatureStore reads back signed manifests and their signatures from a destination.
+	SignatureStore = signing.Store
+)
+
+// ErrNotFound is returned by a Fetcher when a metadata file hasn't been mirrored to the destination.
+var ErrNotFound = mirrortuf.ErrNotFound
+
+// Destination is a location metadata or targets are mirrored to. Manifests are written tagged, the
+// top-level metadata image with an empty tag.
+type Destination interface {
+	// String returns the location of the destination.
+	String() string
+	// Location returns where a manifest tagged tag is written, for reporting.
+	Location(tag string) string
+	// LockKey identifies the lock of the destination, destinations sharing a key are locked once.
+	LockKey() string
+	// Lock locks the destination against concurrent runs, returning the function releasing the lock.
+	Lock(ctx context.Context, opts LockOptions) (func(context.Context) error, error)
+	// WriteImage writes img tagged tag, followed by the images referring to it.
+	WriteImage(ctx context.Context, tag string, img v1.Image, referrers []v1.Image) error
+	// WriteIndex writes idx tagged tag, followed by the images referring to it or its manifests.
+	WriteIndex(ctx context.Context, tag string, idx v1.ImageIndex, referrers []v1.Image) error
+	// Tag tags the manifest written tagged target with tag as well, without writing it again.
+	Tag(ctx context.Context, tag, target string) error
+	// Fetcher returns a fetcher for the TUF metadata mirrored to the destination.
+	Fetcher() (Fetcher, error)
+	// Targets returns the target files mirrored to the destination.
+	Targets(ctx context.Context) ([]TargetEntry, error)
+	// Signatures returns a store for the signatures of manifests mirrored to the destination.
+	Signatures() (SignatureStore, error)
+}
+
+// LayoutDestination writes OCI layouts, the top-level metadata image to the destination path and each
+// tagged manifest to a sub-directory named after its tag. Alternatively every manifest is written to a
+// single consolidated layout at the destination path, named by its tag with a ref name annotation as
+// tools like oras and skopeo expect, and the top-level metadata image named latest.
+type LayoutDestination struct {
+	path string
+	// Single writes a consolidated layout. A consolidated layout already at the destination path is
+	// written to as one without it.
+	Single bool
+}
+
+// NewLayoutDestination returns the destination writing OCI layouts under path.
+func NewLayoutDestination(path string) *LayoutDestination {
+	return &LayoutDestination{path: path}
+}
+
+func (d *LayoutDestination) String() string {
+	return "oci://" + d.path
+}
+
+func (d *LayoutDestination) Location(tag string) string {
+	if d.single() {
+		return refLocation(d.path, tag)
+	}
+	return d.dir(tag)
+}
+
+// single reports whether manifests are written to a consolidated layout.
+func (d *LayoutDestination) single() bool {
+	return d.Single || mirrortuf.IsRefLayout(d.path)
+}
+
+// dir returns the layout of the manifest tagged tag when there's a layout per tag.
+func (d *LayoutDestination) dir(tag string) string {
+	return filepath.Join(d.path, tag)
+}
+
+func (d *LayoutDestination) LockKey() string {
+	return filepath.Clean(d.path)
+}
+
+func (d *LayoutDestination) Lock(ctx context.Context, opts LockOptions) (func(context.Context) error, error) {
+	lk, err := lock.AcquireFile(ctx, d.LockKey(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return lk.Release, nil
+}
+
+func (d *LayoutDestination) WriteImage(_ context.Context, tag string, img v1.Image, referrers []v1.Image) error {
+	if d.single() {
+		return writeRefImage(d.path, tag, img, referrers)
+	}
+	err := oci.SaveImageAsOCILayout(img, d.dir(tag))
+	if err != nil {
+		return err
+	}
+	return d.appendReferrers(tag, referrers)
+}
+
+func (d *LayoutDestination) WriteIndex(_ context.Context, tag string, idx v1.ImageIndex, referrers []v1.Image) error {
+	if d.single() {
+		return writeRefIndex(d.path, tag, idx, referrers)
+	}
+	err := oci.SaveIndexAsOCILayout(idx, d.dir(tag))
+	if err != nil {
+		return err
+	}
+	return d.appendReferrers(tag, referrers)
+}
+
+// appendReferrers adds referrers to the OCI layout tagged tag, next to the image or index they refer to.
+func (d *LayoutDestination) appendReferrers(tag string, referrers []v1.Image) error {
+	if len(referrers) == 0 {
+		return nil
+	}
+	l, err := layout.FromPath(d.dir(tag))
+	if err != nil {
+		return fmt.Errorf("failed to open OCI layout: %w", err)
+	}
+	for _, img := range referrers {
+		err = l.AppendImage(img)
+		if err != nil {
+			return fmt.Errorf("failed to append referrer: %w", err)
+		}
+	}
+	return nil
+}
+
+// Tag points the sub-directory named tag at the layout of target with a relative symlink, replaced
+// atomically, so the layout isn't copied. In a consolidated layout target's descriptor is named tag too.
+func (d *LayoutDestination) Tag(_ context.Context, tag, target string) error {
+	if d.single() {
+		return tagRef(d.path, tag, target)
+	}
+	location := d.dir(tag)
+	// layouts written before tags were symlinked are directories, which a symlink can't replace
+	if fi, err := os.Lstat(location); err == nil && fi.IsDir() {
+		err = os.RemoveAll(location)
+		if err != nil {
+			return fmt.Errorf("failed to remove OCI layout %s: %w", location, err)
+		}
+	}
+	tmp := location + ".tmp"
+	err := os.Remove(tmp)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove %s: %w", tmp, err)
+	}
+	err = os.Symlink(target, tmp)
+	if err != nil {
+		return fmt.Errorf("failed to link %s to %s: %w", tag, target, err)
+	}
+	err = os.Rename(tmp, location)
+	if err != nil {
+		return fmt.Errorf("failed to link %s to %s: %w", tag, target, err)
+	}
+	return nil
+}
+
+func (d *LayoutDestination) Fetcher() (Fetcher, error) {
+	return newLayoutFetcher(d.path), nil
+}
+
+func (d *LayoutDestination) Targets(_ context.Context) ([]TargetEntry, error) {
+	if mirrortuf.IsRefLayout(d.path) {
+		return mirrortuf.ListRefLayoutTargets(d.path)
+	}
+	return mirrortuf.ListLayoutTargets(d.path)
+}
+
+func (d *LayoutDestination) Signatures() (SignatureStore, error) {
+	if mirrortuf.IsRefLayout(d.path) {
+		return signing.NewRefLayoutStore(d.path), nil
+	}
+	return signing.NewLayoutStore(d.path), nil
+}
+
+// newLayoutFetcher returns a fetcher for metadata saved to OCI layouts under path, or to the consolidated
+// layout at path.
+func newLayoutFetcher(path string) *mirrortuf.ImageFetcher {
+	if mirrortuf.IsRefLayout(path) {
+		return mirrortuf.NewRefLayoutFetcher(path, nil)
+	}
+	return mirrortuf.NewLayoutFetcher(path)
+}
+
+// RegistryDestination pushes to a registry repository, the top-level metadata image to the destination
+// reference and each tagged manifest to the same repository.
+type RegistryDestination struct {
+	imageName string
+	ref       name.Reference
+}
+
+// NewRegistryDestination returns the destination pushing to the registry reference imageName, which
+// must not have a digest.
+func NewRegistryDestination(imageName string) (*RegistryDestination, error) {
+	ref, err := name.ParseReference(imageName)
+	if err != nil {
+		return nil, invalidArgumentf("failed to parse destination registry reference: %w", err)
+	}
+	if _, ok := ref.(name.Digest); ok {
+		return nil, invalidArgumentf("destination registry reference should not have a digest: %s", imageName)
+	}
+	return &RegistryDestination{imageName: imageName, ref: ref}, nil
+}
+
+func (d *RegistryDestination) String() string {
+	return "docker://" + d.imageName
+}
+
+func (d *RegistryDestination) Location(tag string) string {
+	if tag == "" {
+		return d.imageName
+	}
+	return d.ref.Context().Tag(tag).String()
+}
+
+func (d *RegistryDestination) LockKey() string {
+	return d.ref.Context().Name()
+}
+
+func (d *RegistryDestination) Lock(ctx context.Context, opts LockOptions) (func(context.Context) error, error) {
+	lk, err := lock.AcquireRegistry(ctx, d.LockKey(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return lk.Release, nil
+}
+
+func (d *RegistryDestination) WriteImage(ctx context.Context, tag string, img v1.Image, referrers []v1.Image) error {
+	err := registry.PushImage(ctx, img, d.Location(tag))
+	if err != nil {
+		return err
+	}
+	return d.pushReferrers(ctx, referrers)
+}
+
+func (d *RegistryDestination) WriteIndex(ctx context.Context, tag string, idx v1.ImageIndex, referrers []v1.Image) error {
+	err := registry.PushIndex(ctx, idx, d.Location(tag))
+	if err != nil {
+		return err
+	}
+	return d.pushReferrers(ctx, referrers)
+}
+
+// pushReferrers pushes referrers by digest. The registry client maintains the referrers tag schema for
+// registries without the referrers API.
+func (d *RegistryDestination) pushReferrers(ctx context.Context, referrers []v1.Image) error {
+	for _, img := range referrers {
+		digest, err := img.Digest()
+		if err != nil {
+			return fmt.Errorf("failed to get referrer digest: %w", err)
+		}
+		err = registry.PushImage(ctx, img, d.ref.Context().Digest(digest.String()).String())
+		if err != nil {
+			return fmt.Errorf("failed to push referrer: %w", err)
+		}
+	}
+	return nil
+}
+
+func (d *RegistryDestination) Tag(ctx context.Context, tag, target string) error {
+	return registry.Tag(ctx, d.Location(target), tag)
+}
+
+func (d *RegistryDestination) Fetcher() (Fetcher, error) {
+	return mirrortuf.NewRegistryFetcher(d.imageName)
+}
+
+func (d *RegistryDestination) Targets(ctx context.Context) ([]TargetEntry, error) {
+	return mirrortuf.ListRegistryTargets(ctx, d.ref.Context().Name())
+}
+
+func (d *RegistryDestination) Signatures() (SignatureStore, error) {
+	return signing.NewRegistryStore(d.imageName)
+}
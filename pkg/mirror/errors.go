@@ -0,0 +1,98 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/docker/go-tuf-mirror/internal/bucket"
+	"github.com/docker/go-tuf-mirror/internal/failure"
+	"github.com/docker/go-tuf-mirror/internal/freshness"
+	"github.com/docker/go-tuf-mirror/internal/lock"
+	"github.com/docker/go-tuf-mirror/internal/rotation"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+// Classes of failure, errors returned by Mirror match any that apply with errors.Is.
+var (
+	// ErrInvalidArgument is an invalid Config, source or destination.
+	ErrInvalidArgument error = failure.InvalidArgument
+	// ErrSourceUnreachable is a failure to download from the source.
+	ErrSourceUnreachable error = failure.SourceUnreachable
+	// ErrVerification is source metadata or targets failing TUF verification.
+	ErrVerification error = failure.Verification
+	// ErrExpired is expired source metadata.
+	ErrExpired error = failure.Expired
+	// ErrDestinationAuth is a destination denying access.
+	ErrDestinationAuth error = failure.DestinationAuth
+	// ErrPartialPush is a failure to mirror to some destinations while others succeeded.
+	ErrPartialPush error = failure.PartialPush
+	// ErrStale is source metadata refused by Config.Freshness.
+	ErrStale = freshness.ErrStale
+	// ErrRollback is a destination holding newer metadata than the source.
+	ErrRollback = mirrortuf.ErrRollback
+	// ErrLockTimeout is a destination still locked by another run after Config.Lock.Timeout.
+	ErrLockTimeout = lock.ErrTimeout
+	// ErrLockLost is a run cancelled because another run took over the lock of one of its destinations.
+	ErrLockLost = lock.ErrLost
+	// ErrRootRotation is a source whose root was rotated since the last run, without Config.AcceptRootRotation.
+	ErrRootRotation = rotation.ErrRotation
+	// ErrRootChain is a root chain artifact not matching its pinned digest, or whose roots don't verify.
+	ErrRootChain = mirrortuf.ErrRootChain
+)
+
+// invalidArgumentf formats an invalid argument error.
+func invalidArgumentf(format string, a ...any) error {
+	return failure.Classify(failure.InvalidArgument, fmt.Errorf(format, a...))
+}
+
+// sourceError classifies an error updating TUF metadata or downloading targets from the source.
+func sourceError(err error) error {
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, &metadata.ErrExpiredMetadata{}):
+		return failure.Classify(failure.Expired, err)
+	case errors.Is(err, &metadata.ErrRepository{}), errors.Is(err, &metadata.ErrDownloadLengthMismatch{}):
+		return failure.Classify(failure.Verification, err)
+	case errors.Is(err, &metadata.ErrDownload{}), errors.As(err, &urlErr):
+		return failure.Classify(failure.SourceUnreachable, err)
+	}
+	return err
+}
+
+// destinationError classifies an error writing to or reading from a destination.
+func destinationError(err error) error {
+	var terr *transport.Error
+	if errors.As(err, &terr) && (terr.StatusCode == http.StatusUnauthorized || terr.StatusCode == http.StatusForbidden) {
+		return failure.Classify(failure.DestinationAuth, err)
+	}
+	// buckets whose credentials can't be loaded
+	if errors.Is(err, bucket.ErrConfig) {
+		return failure.Classify(failure.DestinationAuth, err)
+	}
+	// bucket responses
+	var rerr interface{ HTTPStatusCode() int }
+	if errors.As(err, &rerr) && (rerr.HTTPStatusCode() == http.StatusUnauthorized || rerr.HTTPStatusCode() == http.StatusForbidden) {
+		return failure.Classify(failure.DestinationAuth, err)
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+// EventKind is what happened to a manifest at a destination.
+type EventKind string
+
+const (
+	// Written is a manifest (with its referrers) written to a destination.
+	Written EventKind = "written"
+	// Skipped is a manifest already written to a destination by an interrupted run.
+	Skipped EventKind = "skipped"
+)
+
+// ManifestKind is the kind of a mirrored manifest.
+type ManifestKind string
+
+const (
+	// RootChainManifest is the artifact holding every root version.
+	RootChainManifest ManifestKind = "root chain manifest"
+	// MetadataManifest is the image holding the top-level TUF metadata.
+	MetadataManifest ManifestKind = "metadata manifest"
+	// DelegatedMetadataManifest is the image holding the metadata of a delegated role.
+	DelegatedMetadataManifest ManifestKind = "delegated metadata manifest"
+	// TargetManifest is the image holding a top-level target file.
+	TargetManifest ManifestKind = "target manifest"
+	// DelegatedTargetIndex is the index of images holding the target files of a delegated role.
+	DelegatedTargetIndex ManifestKind = "delegated target index manifest"
+	// SnapshotIndex is the index referencing every target image and delegated target index of a snapshot.
+	SnapshotIndex ManifestKind = "snapshot index"
+)
+
+// Event reports progress mirroring to a destination.
+type Event struct {
+	Kind        EventKind
+	Manifest    ManifestKind
+	Destination Destination
+	// Tag is the tag of the manifest, empty for the top-level metadata image.
+	Tag string
+	// Location is where the manifest was written, as reported by Destination.Location.
+	Location string
+}
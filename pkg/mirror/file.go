@@ -0,0 +1,135 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/go-tuf-mirror/internal/lock"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// FileDestination writes a plain TUF repository to a local directory, file://<path>, e.g. to be served by a
+// web server. Files are named as in S3Destination and written in publishing order, each to a temporary file
+// renamed into place, so readers never see a partial file nor metadata referring to files that aren't there
+// yet. Caching is up to the web server, see CacheControl for the policy S3Destination applies.
+type FileDestination struct {
+	path string
+}
+
+// NewFileDestination returns the destination writing to the directory at path.
+func NewFileDestination(path string) *FileDestination {
+	return &FileDestination{path: path}
+}
+
+func (d *FileDestination) String() string {
+	return "file://" + d.path
+}
+
+func (d *FileDestination) Location(tag string) string {
+	return filepath.Join(d.path, tag)
+}
+
+func (d *FileDestination) LockKey() string {
+	return filepath.Clean(d.path)
+}
+
+func (d *FileDestination) Lock(ctx context.Context, opts LockOptions) (func(context.Context) error, error) {
+	lk, err := lock.AcquireFile(ctx, d.LockKey(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return lk.Release, nil
+}
+
+// WriteImage writes the TUF files held by the layers of img.
+func (d *FileDestination) WriteImage(ctx context.Context, _ string, img v1.Image, referrers []v1.Image) error {
+	if len(referrers) > 0 {
+		return invalidArgumentf("%s can't hold signatures or referrers", d)
+	}
+	return writeImageFiles(ctx, "", img, d.put)
+}
+
+// WriteIndex writes the TUF files held by the images of a delegated target index, snapshot indexes are
+// skipped.
+func (d *FileDestination) WriteIndex(ctx context.Context, _ string, idx v1.ImageIndex, referrers []v1.Image) error {
+	if len(referrers) > 0 {
+		return invalidArgumentf("%s can't hold signatures or referrers", d)
+	}
+	return writeIndexFiles(ctx, idx, d.put)
+}
+
+// put writes a TUF file to a temporary file in its directory and renames it into place.
+func (d *FileDestination) put(_ context.Context, name, _ string, data []byte) (err error) {
+	target := filepath.Join(d.path, filepath.FromSlash(name))
+	err = os.MkdirAll(filepath.Dir(target), 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", name, err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	// temporary files are only readable by their owner
+	err = os.Chmod(f.Name(), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	err = os.Rename(f.Name(), target)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func (d *FileDestination) plainRepository() {}
+
+// Tag does nothing, only snapshot indexes are tagged twice and they're skipped.
+func (d *FileDestination) Tag(context.Context, string, string) error {
+	return nil
+}
+
+func (d *FileDestination) Fetcher() (Fetcher, error) {
+	return mirrortuf.NewDirFetcher(d.path), nil
+}
+
+func (d *FileDestination) Targets(_ context.Context) ([]TargetEntry, error) {
+	return mirrortuf.ListDirTargets(d.path)
+}
+
+func (d *FileDestination) Signatures() (SignatureStore, error) {
+	return nil, invalidArgumentf("%s can't hold signatures", d)
+}
@@ -0,0 +1,216 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/docker/attest/mirror"
+	"github.com/docker/go-tuf-mirror/internal/logging"
+	"github.com/docker/go-tuf-mirror/internal/tracing"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// fileGetter reads the file name of a source, returning an error wrapping fs.ErrNotExist if it doesn't exist.
+type fileGetter func(ctx context.Context, name string) ([]byte, error)
+
+// loopback serves the metadata and target files of a source on a loopback address. The TUF client only
+// downloads over http with the default transport, so every source is served to it from here while the files
+// are read with clients of our own, each download recorded as a span of the run.
+type loopback struct {
+	server *http.Server
+	url    string
+}
+
+// serveLoopback starts serving metadata under /metadata and targets under /targets until closed, recording
+// downloads as children of the span in ctx.
+func serveLoopback(ctx context.Context, metadata, targets fileGetter) (*loopback, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on loopback address: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metadata/", serveFiles(ctx, "/metadata/", metadata, metadataAttributes))
+	mux.Handle("/targets/", serveFiles(ctx, "/targets/", targets, targetAttributes))
+	l := &loopback{
+		server: &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+		url:    "http://" + ln.Addr().String(),
+	}
+	go func() {
+		_ = l.server.Serve(ln)
+	}()
+	return l, nil
+}
+
+// metadataURL and targetsURL return the locations the TUF client reads the source from.
+func (l *loopback) metadataURL() string {
+	return l.url + "/metadata"
+}
+
+func (l *loopback) targetsURL() string {
+	return l.url + "/targets"
+}
+
+// Close stops serving the source, closing a nil loopback succeeds.
+func (l *loopback) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.server.Close()
+}
+
+// serveFiles returns a handler serving GET requests for the files read by get under prefix. Requests run
+// with the logger and span of ctx, and are cancelled with either.
+func serveFiles(ctx context.Context, prefix string, get fileGetter, attrs func(name string) []attribute.KeyValue) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+		reqCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stop := context.AfterFunc(r.Context(), cancel)
+		defer stop()
+
+		reqCtx, span := tracing.Start(reqCtx, "tuf download", attrs(name)...)
+		data, err := get(reqCtx, name)
+		var httpErr *metadata.ErrDownloadHTTP
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// not found ends the root update, it isn't a failure
+			tracing.End(span, nil)
+			http.NotFound(w, r)
+		case errors.As(err, &httpErr):
+			tracing.End(span, err)
+			http.Error(w, err.Error(), httpErr.StatusCode)
+		case err != nil:
+			tracing.End(span, err)
+			logging.FromContext(ctx).DebugContext(ctx, "failed to read source file", "file", name, "error", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		default:
+			span.SetAttributes(attribute.Int("size", len(data)))
+			tracing.End(span, nil)
+			_, _ = w.Write(data)
+		}
+	})
+}
+
+// metadataRole returns the role of a metadata file, <version>.<role>.json or <role>.json.
+func metadataRole(name string) string {
+	role := strings.TrimSuffix(name, ".json")
+	if version, rest, ok := strings.Cut(role, "."); ok && strings.Trim(version, "0123456789") == "" {
+		role = rest
+	}
+	return role
+}
+
+// metadataAttributes describes a metadata file by role.
+func metadataAttributes(name string) []attribute.KeyValue {
+	return []attribute.KeyValue{attribute.String("file", name), attribute.String("role", metadataRole(name))}
+}
+
+// targetAttributes describes a target file, [<dir>/]<sha256>.<name>, by target path.
+func targetAttributes(name string) []attribute.KeyValue {
+	dir, base := path.Split(name)
+	if _, target, ok := strings.Cut(base, "."); ok {
+		base = target
+	}
+	return []attribute.KeyValue{attribute.String("file", name), attribute.String("target", dir+base)}
+}
+
+// webClient downloads the files of web sources, recording each request as a span and debug log.
+var webClient = &http.Client{Transport: tracing.Transport(logging.Transport(http.DefaultTransport))}
+
+// webFiles returns a getter reading files from under baseURL.
+func webFiles(baseURL string) fileGetter {
+	return func(ctx context.Context, name string) ([]byte, error) {
+		u := strings.TrimSuffix(baseURL, "/") + "/" + name
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, err := webClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", u, err)
+		}
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			return nil, fmt.Errorf("%s: %w", u, fs.ErrNotExist)
+		default:
+			return nil, &metadata.ErrDownloadHTTP{StatusCode: resp.StatusCode, URL: u}
+		}
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", u, err)
+		}
+		return data, nil
+	}
+}
+
+// openLoopback serves the files of a source on a loopback address and updates the trusted metadata from
+// there, recording the TUF update as a span. The loopback must be closed once the mirror is no longer used,
+// it is returned (and must be closed) even if the update fails.
+func openLoopback(ctx context.Context, root []byte, cachePath, metadataLocation, targetsLocation string, metadataFiles, targetFiles fileGetter) (l *loopback, m *mirror.TUFMirror, err error) {
+	// downloads outlive the update, targets are downloaded as they're mirrored
+	l, err = serveLoopback(ctx, metadataFiles, targetFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, span := tracing.Start(ctx, "tuf update", attribute.String("metadata", metadataLocation), attribute.String("targets", targetsLocation))
+	defer func() { tracing.End(span, err) }()
+	m, err = mirror.NewTUFMirror(ctx, root, cachePath, l.metadataURL(), l.targetsURL(), &mirrortuf.NullVersionChecker{})
+	if err != nil {
+		return l, nil, err
+	}
+	logger := logging.FromContext(ctx)
+	md := m.TUFClient.GetMetadata()
+	logger.DebugContext(ctx, "trusted root", "version", md.Root.Signed.Version, "expires", md.Root.Signed.Expires)
+	logger.DebugContext(ctx, "trusted timestamp", "version", md.Timestamp.Signed.Version, "expires", md.Timestamp.Signed.Expires)
+	logger.DebugContext(ctx, "trusted snapshot", "version", md.Snapshot.Signed.Version, "expires", md.Snapshot.Signed.Expires)
+	for role, t := range md.Targets {
+		logger.DebugContext(ctx, "trusted targets", "role", role, "version", t.Signed.Version, "expires", t.Signed.Expires)
+	}
+	return l, m, nil
+}
+
+// served is implemented by sources read through a loopback, returning the location the TUF client reads
+// their metadata from.
+type served interface {
+	servedMetadata() string
+}
+
+// sourceMetadataURL returns the http location the metadata of source is read from while it's open.
+func sourceMetadataURL(source Source) string {
+	if s, ok := source.(served); ok {
+		return s.servedMetadata()
+	}
+	return source.Metadata()
+}
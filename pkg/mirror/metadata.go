@@ -0,0 +1,158 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/attest/mirror"
+	"github.com/docker/go-tuf-mirror/internal/freshness"
+	"github.com/docker/go-tuf-mirror/internal/logging"
+	"github.com/docker/go-tuf-mirror/internal/metrics"
+	"github.com/docker/go-tuf-mirror/internal/tracing"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"github.com/theupdateframework/go-tuf/v2/metadata/trustedmetadata"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// mirrorMetadata mirrors the metadata images to the metadata destinations.
+func (r *run) mirrorMetadata(ctx context.Context) (err error) {
+	destinations := destinationNames(r.cfg.MetadataDestinations)
+	ctx, span := tracing.Start(ctx, "mirror metadata", attribute.String("source", r.cfg.Source.Metadata()), attribute.StringSlice("destinations", destinations))
+	defer func() { tracing.End(span, err) }()
+	logging.FromContext(ctx).InfoContext(ctx, "Mirroring TUF metadata", "source", r.cfg.Source.Metadata(), "destination", strings.Join(destinations, ","))
+
+	// create metadata image
+	_, buildSpan := tracing.Start(ctx, "build metadata manifest")
+	image, err := r.mirror.GetMetadataManifest(sourceMetadataURL(r.cfg.Source))
+	tracing.End(buildSpan, err)
+	if err != nil {
+		return sourceError(fmt.Errorf("failed to create metadata manifest: %w", err))
+	}
+	// record the tag scheme so readers can find delegated and target images, and when the timestamp version
+	// was first seen so later runs can tell if the source has stopped advancing
+	annotations := r.scheme.Annotations()
+	annotations[freshness.SinceAnnotation] = r.since.UTC().Format(time.RFC3339)
+	image, err = annotateImage(image, annotations)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata manifest: %w", err)
+	}
+
+	// create delegated metadata manifests
+	var delegated []*mirror.Image
+	if r.cfg.Full {
+		_, buildSpan := tracing.Start(ctx, "build delegated metadata manifests")
+		delegated, err = r.mirror.GetDelegatedMetadataMirrors()
+		tracing.End(buildSpan, err)
+		if err != nil {
+			return sourceError(fmt.Errorf("failed to create delegated metadata manifests: %w", err))
+		}
+		delegated, err = tagDelegatedImages(r.scheme, delegated, tagSet{})
+		if err != nil {
+			return fmt.Errorf("failed to tag delegated metadata manifests: %w", err)
+		}
+	}
+
+	// sign the metadata image (keyed by the empty tag) and delegated metadata images
+	signatures := map[string][]v1.Image{}
+	if r.cfg.Signer != nil {
+		signatures[""], err = signManifest(ctx, r.cfg.Signer, image)
+		if err != nil {
+			return fmt.Errorf("failed to sign metadata manifest: %w", err)
+		}
+		for _, d := range delegated {
+			signatures[d.Tag], err = signManifest(ctx, r.cfg.Signer, d.Image)
+			if err != nil {
+				return fmt.Errorf("failed to sign delegated metadata manifest: %w", err)
+			}
+		}
+	}
+
+	err = r.publishEach(ctx, r.cfg.MetadataDestinations, func(ctx context.Context, d Destination) error {
+		return r.publishMetadata(ctx, d, image, delegated, signatures)
+	})
+	if err != nil {
+		return err
+	}
+	r.metrics.RecordSuccess(metrics.MetadataJob)
+	return nil
+}
+
+// publishMetadata writes the metadata manifests, with their signatures by tag, to d.
+func (r *run) publishMetadata(ctx context.Context, d Destination, image v1.Image, delegated []*mirror.Image, signatures map[string][]v1.Image) error {
+	// refuse to replace newer metadata at the destination
+	if !r.cfg.AllowRollback {
+		err := checkRollback(ctx, d, r.result.Metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	// delegated roles go first, the metadata image holds the snapshot and timestamp referencing them
+	for _, m := range delegated {
+		err := r.write(ctx, metrics.MetadataJob, d, DelegatedMetadataManifest, m.Tag, m.Image, signatures[m.Tag], nil)
+		if err != nil {
+			return err
+		}
+	}
+	return r.write(ctx, metrics.MetadataJob, d, MetadataManifest, "", image, signatures[""], nil)
+}
+
+// checkRollback returns an error if any role in md is older than the metadata already at d.
+func checkRollback(ctx context.Context, d Destination, md trustedmetadata.TrustedMetadata) error {
+	f, err := d.Fetcher()
+	if err != nil {
+		return err
+	}
+	current, err := mirrortuf.LoadAvailable(ctx, f)
+	if errors.Is(err, mirrortuf.ErrNotFound) {
+		// nothing published yet
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read destination metadata: %w", err)
+	}
+	// an interrupted publish leaves some roles out, the roles that were published are still checked
+	var missing []string
+	if current.Timestamp == nil {
+		missing = append(missing, metadata.TIMESTAMP)
+	}
+	if current.Snapshot == nil {
+		missing = append(missing, metadata.SNAPSHOT)
+	}
+	if current.Targets[metadata.TARGETS] == nil {
+		missing = append(missing, metadata.TARGETS)
+	}
+	if len(missing) > 0 {
+		logging.FromContext(ctx).WarnContext(ctx, "Destination metadata is partially published, checking the roles found for rollback", "destination", d.String(), "missing", missing)
+	}
+	rollbacks := mirrortuf.CheckRollback(current, md)
+	if len(rollbacks) == 0 {
+		return nil
+	}
+	lines := make([]string, len(rollbacks))
+	for i, rb := range rollbacks {
+		lines[i] = rb.String()
+	}
+	return fmt.Errorf("%w: refusing to publish older metadata to %s (allow rollback to override):\n%s", mirrortuf.ErrRollback, d, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,574 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package mirror mirrors TUF metadata and target files from a TUF repository to OCI layouts and registries.
+//
+// Metadata is mirrored as an image holding the top-level roles (and an image per delegated role), targets
+// as an image per target file, an index per delegated role and a snapshot index referencing them all.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/docker/attest/mirror"
+	"github.com/docker/go-tuf-mirror/internal/cache"
+	"github.com/docker/go-tuf-mirror/internal/failure"
+	"github.com/docker/go-tuf-mirror/internal/freshness"
+	"github.com/docker/go-tuf-mirror/internal/journal"
+	"github.com/docker/go-tuf-mirror/internal/logging"
+	"github.com/docker/go-tuf-mirror/internal/metrics"
+	"github.com/docker/go-tuf-mirror/internal/rotation"
+	"github.com/docker/go-tuf-mirror/internal/signing"
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	"github.com/docker/go-tuf-mirror/internal/tracing"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"github.com/theupdateframework/go-tuf/v2/metadata/trustedmetadata"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DefaultLockStaleAfter is how long a destination lock lasts without being renewed unless configured.
+const DefaultLockStaleAfter = 5 * time.Minute
+
+// FreshnessOptions configures the refusal of stale source metadata.
+type FreshnessOptions = freshness.Options
+
+// RootRotation is the source root rotated since the last run, with the key and threshold changes.
+type RootRotation = rotation.Rotation
+
+// Config configures a mirror run.
+type Config struct {
+	// Root is the trusted root metadata, the default Docker TUF root if nil.
+	Root []byte
+	// CachePath is the directory caching TUF metadata, freshness and root records and journals between runs.
+	// TUF metadata is cached in a directory per source metadata location and root.
+	CachePath string
+	// Source is the TUF repository to mirror.
+	Source Source
+	// RootDestinations receive the root chain artifact, with every root version, which isn't mirrored if empty.
+	RootDestinations []Destination
+	// MetadataDestinations receive the metadata images, metadata isn't mirrored if empty.
+	MetadataDestinations []Destination
+	// TargetsDestinations receive the target images and indexes, targets aren't mirrored if empty.
+	TargetsDestinations []Destination
+	// Full mirrors delegated roles along with the top-level roles.
+	Full bool
+	// TargetTag and DelegatedTag are the templates tagging target images and delegated role images and
+	// indexes, the defaults if empty.
+	TargetTag    string
+	DelegatedTag string
+	// Signer signs each mirrored manifest, nothing is signed if nil.
+	Signer dsse.SignerVerifier
+	// Referrers attaches the signing targets metadata to each target image.
+	Referrers bool
+	// AllowRollback publishes metadata even if a destination holds newer metadata.
+	AllowRollback bool
+	// AcceptRootRotation mirrors a source whose root was rotated since the last run, which is refused
+	// otherwise.
+	AcceptRootRotation bool
+	// Freshness refuses source metadata whose timestamp has stopped advancing or is about to expire.
+	Freshness FreshnessOptions
+	// Lock configures the locks held on every destination for the whole run, a zero Lock.StaleAfter is
+	// DefaultLockStaleAfter.
+	Lock LockOptions
+	// Registerer records the metrics of the run, none are recorded if nil. Runs sharing a registerer add up.
+	Registerer prometheus.Registerer
+	// OnEvent, if set, is called as manifests are written to each destination.
+	OnEvent func(Event)
+}
+
+// Result describes a completed mirror run.
+type Result struct {
+	// Metadata is the trusted metadata that was mirrored.
+	Metadata trustedmetadata.TrustedMetadata
+	// RootChain is the digest of the root chain artifact, to pin with FetchRoot, if it was mirrored.
+	RootChain v1.Hash
+	// RootRotation is the rotation of the source root since the last run, nil if it wasn't rotated.
+	RootRotation *RootRotation
+	// Written and Skipped count the manifests written to destinations and already written by an interrupted run.
+	Written int
+	Skipped int
+}
+
+// run is the state of a mirror run.
+type run struct {
+	cfg     *Config
+	scheme  *tags.Scheme
+	mirror  *mirror.TUFMirror
+	metrics *metrics.Metrics
+	// since is when the source timestamp version was first seen
+	since time.Time
+	// published counts the destinations each phase was published to
+	published int
+	result    Result
+}
+
+// Mirror updates the trusted metadata from cfg.Source and mirrors its metadata and targets to the
+// destinations of cfg. A failed destination doesn't stop the others.
+func Mirror(ctx context.Context, cfg Config) (result Result, err error) {
+	if cfg.Source == nil {
+		return result, invalidArgumentf("missing source")
+	}
+	if len(cfg.RootDestinations) == 0 && len(cfg.MetadataDestinations) == 0 && len(cfg.TargetsDestinations) == 0 {
+		return result, invalidArgumentf("missing destination")
+	}
+	if cfg.CachePath == "" {
+		return result, invalidArgumentf("missing cache path")
+	}
+	targetTag, delegatedTag := cfg.TargetTag, cfg.DelegatedTag
+	if targetTag == "" {
+		targetTag = tags.DefaultTargetTemplate
+	}
+	if delegatedTag == "" {
+		delegatedTag = tags.DefaultDelegatedTemplate
+	}
+	scheme, err := tags.NewScheme(targetTag, delegatedTag)
+	if err != nil {
+		return result, invalidArgumentf("%w", err)
+	}
+	mt, err := metrics.New(cfg.Registerer)
+	if err != nil {
+		return result, invalidArgumentf("%w", err)
+	}
+
+	ctx, span := tracing.Start(ctx, "mirror", attribute.String("source", cfg.Source.Metadata()))
+	defer func() { tracing.End(span, err) }()
+
+	if cfg.Lock.StaleAfter <= 0 {
+		cfg.Lock.StaleAfter = DefaultLockStaleAfter
+	}
+	destinations := append(append(append([]Destination{}, cfg.RootDestinations...), cfg.MetadataDestinations...), cfg.TargetsDestinations...)
+	err = checkArchives(destinations)
+	if err != nil {
+		return result, err
+	}
+	lockCtx, unlock, err := lockDestinations(ctx, cfg.Lock, destinations)
+	if err != nil {
+		return result, err
+	}
+	defer func(ctx context.Context) {
+		// a run losing a lock is cancelled, and fails with the lock lost whatever the cancellation caused
+		if cause := context.Cause(lockCtx); errors.Is(cause, ErrLockLost) {
+			err = errors.Join(cause, err)
+		}
+		err = errors.Join(err, unlock(ctx))
+	}(ctx)
+	ctx = lockCtx
+	// destinations written when closed, such as archives, are closed while still locked
+	defer func() {
+		err = errors.Join(err, closeDestinations(destinations))
+	}()
+
+	// sources sharing a cache path get a TUF cache each, which the TUF client splits by root within
+	tufPath, err := cache.Namespace(cfg.CachePath, cfg.Source.Metadata())
+	if err != nil {
+		return result, err
+	}
+	m, err := cfg.Source.Open(ctx, cfg.Root, tufPath)
+	// sources serving files for the duration of the run are closed once it's over
+	if c, ok := cfg.Source.(io.Closer); ok {
+		defer func() {
+			err = errors.Join(err, c.Close())
+		}()
+	}
+	if err != nil {
+		return result, sourceError(fmt.Errorf("failed to create TUF mirror: %w", err))
+	}
+	md := m.TUFClient.GetMetadata()
+	since, err := checkFreshness(ctx, cfg.CachePath, cfg.Source.Metadata(), md, cfg.MetadataDestinations, cfg.Freshness)
+	if err != nil {
+		return result, err
+	}
+	rotated, err := checkRotation(ctx, cfg.CachePath, cfg.Source.Metadata(), md, cfg.MetadataDestinations, cfg.AcceptRootRotation)
+	if err != nil {
+		return Result{Metadata: md, RootRotation: rotated}, err
+	}
+	mt.RecordRoles(md)
+
+	r := &run{cfg: &cfg, scheme: scheme, mirror: m, metrics: mt, since: since, result: Result{Metadata: md, RootRotation: rotated}}
+	// clients read plain TUF repositories file by file as they're written, so targets go first and the
+	// metadata referencing them is held back unless every target was published
+	targetsFirst := hasPlainRepository(cfg.MetadataDestinations)
+	var errs []error
+	if len(cfg.RootDestinations) > 0 {
+		err = r.mirrorRootChain(ctx)
+		var perr *publishError
+		if err != nil && !errors.As(err, &perr) {
+			return r.result, err
+		}
+		errs = append(errs, err)
+	}
+	if len(cfg.MetadataDestinations) > 0 && !targetsFirst {
+		err = r.mirrorMetadata(ctx)
+		// destinations refusing the metadata don't stop the targets reaching the others
+		var perr *publishError
+		if err != nil && !errors.As(err, &perr) {
+			return r.result, err
+		}
+		errs = append(errs, err)
+	}
+	if len(cfg.TargetsDestinations) > 0 {
+		errs = append(errs, r.mirrorTargets(ctx))
+	}
+	if len(cfg.MetadataDestinations) > 0 && targetsFirst && errors.Join(errs...) == nil {
+		errs = append(errs, r.mirrorMetadata(ctx))
+	}
+	err = errors.Join(errs...)
+	if err != nil && r.published > 0 {
+		return r.result, failure.Classify(failure.PartialPush, err)
+	}
+	return r.result, err
+}
+
+// plainRepository is implemented by destinations writing a plain TUF repository, whose files clients read
+// as soon as they're written rather than through manifests pushed whole.
+type plainRepository interface {
+	plainRepository()
+}
+
+// hasPlainRepository reports whether any of destinations is a plain TUF repository.
+func hasPlainRepository(destinations []Destination) bool {
+	for _, d := range destinations {
+		if _, ok := d.(plainRepository); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkArchives refuses archives written by more than one destination, each packs its own content when
+// closed and would replace the other's.
+func checkArchives(destinations []Destination) error {
+	seen := map[string]bool{}
+	for _, d := range destinations {
+		if _, ok := d.(*ArchiveDestination); !ok {
+			continue
+		}
+		if seen[d.LockKey()] {
+			return invalidArgumentf("archive used by more than one destination: %s", d)
+		}
+		seen[d.LockKey()] = true
+	}
+	return nil
+}
+
+// closeDestinations closes the destinations implementing io.Closer, once each.
+func closeDestinations(destinations []Destination) error {
+	closed := map[Destination]bool{}
+	var errs []error
+	for _, d := range destinations {
+		c, ok := d.(io.Closer)
+		if !ok || closed[d] {
+			continue
+		}
+		closed[d] = true
+		err := c.Close()
+		if err != nil {
+			errs = append(errs, destinationError(fmt.Errorf("failed to close destination %s: %w", d, err)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// lockDestinations locks each destination against concurrent runs, once per lock key. Locks are taken in
+// a consistent order so runs sharing several destinations can't deadlock. The returned context is cancelled
+// with an error wrapping ErrLockLost if another run takes over a lock, so the run stops writing to a
+// destination it no longer holds. The returned function releases the locks.
+func lockDestinations(ctx context.Context, opts LockOptions, destinations []Destination) (context.Context, func(context.Context) error, error) {
+	byKey := map[string]Destination{}
+	for _, d := range destinations {
+		byKey[d.LockKey()] = d
+	}
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	onLost := opts.OnLost
+	opts.OnLost = func(err error) {
+		cancel(err)
+		if onLost != nil {
+			onLost(err)
+		}
+	}
+	var releases []func(context.Context) error
+	unlock := func(ctx context.Context) error {
+		cancel(nil)
+		var errs []error
+		for _, release := range releases {
+			errs = append(errs, release(ctx))
+		}
+		return errors.Join(errs...)
+	}
+	for _, key := range keys {
+		release, err := byKey[key].Lock(ctx, opts)
+		if err != nil {
+			return nil, nil, errors.Join(destinationError(fmt.Errorf("failed to lock destination: %w", err)), unlock(ctx))
+		}
+		releases = append(releases, release)
+	}
+	return ctx, unlock, nil
+}
+
+// checkFreshness refuses to mirror metadata from source whose timestamp has stopped advancing or is about to
+// expire, returning when the timestamp version was first seen. The cache and the records of the metadata
+// destinations are both consulted, so runs that don't keep the cache are guarded too.
+func checkFreshness(ctx context.Context, cachePath, source string, md trustedmetadata.TrustedMetadata, destinations []Destination, opts FreshnessOptions) (time.Time, error) {
+	ts := md.Timestamp.Signed
+	var observed []freshness.Observation
+	for _, d := range destinations {
+		o, ok := observeFreshness(ctx, d)
+		if ok {
+			observed = append(observed, o)
+		}
+	}
+	since, err := freshness.Check(cachePath, source, ts.Version, ts.Expires, observed, opts)
+	if err != nil {
+		return since, fmt.Errorf("refusing to mirror from %s: %w", source, err)
+	}
+	logging.FromContext(ctx).DebugContext(ctx, "source timestamp is fresh", "source", source, "version", ts.Version, "expires", ts.Expires, "since", since)
+	return since, nil
+}
+
+// checkRotation returns the rotation of the source root since the last run, compared with the root recorded
+// in the cache and the newest root at destinations. Destinations that can't be read are ignored.
+func checkRotation(ctx context.Context, cachePath, source string, md trustedmetadata.TrustedMetadata, destinations []Destination, accept bool) (*RootRotation, error) {
+	var observed []*metadata.Metadata[metadata.RootType]
+	for _, d := range destinations {
+		f, err := d.Fetcher()
+		if err != nil {
+			continue
+		}
+		root, err := mirrortuf.LoadRoot(ctx, f)
+		if err != nil {
+			if !errors.Is(err, mirrortuf.ErrNotFound) {
+				logging.FromContext(ctx).DebugContext(ctx, "failed to read destination root", "destination", d.String(), "error", err)
+			}
+			continue
+		}
+		observed = append(observed, root)
+	}
+	rotated, err := rotation.Check(cachePath, source, md.Root, observed, accept)
+	if rotated != nil {
+		logging.FromContext(ctx).WarnContext(ctx, "TUF root rotated", "source", source, "from", rotated.From, "to", rotated.To, "changes", len(rotated.Changes), "accepted", err == nil)
+	}
+	return rotated, err
+}
+
+// observeFreshness returns when the timestamp version mirrored to d was first seen, as recorded on its
+// metadata image. Destinations without a record, or that can't be read, are ignored.
+func observeFreshness(ctx context.Context, d Destination) (freshness.Observation, bool) {
+	f, err := d.Fetcher()
+	if err != nil {
+		return freshness.Observation{}, false
+	}
+	images, ok := f.(*mirrortuf.ImageFetcher)
+	if !ok {
+		return freshness.Observation{}, false
+	}
+	data, err := images.Fetch(ctx, metadata.TIMESTAMP, metadata.TIMESTAMP+".json")
+	if err != nil {
+		if !errors.Is(err, mirrortuf.ErrNotFound) {
+			logging.FromContext(ctx).DebugContext(ctx, "failed to read destination timestamp", "destination", d.String(), "error", err)
+		}
+		return freshness.Observation{}, false
+	}
+	ts, err := metadata.Timestamp().FromBytes(data)
+	if err != nil {
+		return freshness.Observation{}, false
+	}
+	since, err := time.Parse(time.RFC3339, images.Annotations()[freshness.SinceAnnotation])
+	if err != nil {
+		return freshness.Observation{}, false
+	}
+	return freshness.Observation{Version: ts.Signed.Version, Since: since}, true
+}
+
+// write writes a manifest tagged tag, with its referrers, to d unless journal j (if any) records it as
+// written already. The write is recorded as a span and in the push metrics for job, and reported as an event.
+func (r *run) write(ctx context.Context, job string, d Destination, kind ManifestKind, tag string, m manifest, referrers []v1.Image, j *journal.Journal) (err error) {
+	location := d.Location(tag)
+	ctx, span := tracing.Start(ctx, "write "+string(kind), attribute.String("job", job), attribute.String("destination", d.String()), attribute.String("tag", tag), attribute.String("location", location))
+	defer func() { tracing.End(span, err) }()
+
+	// destinations ignoring the context, such as layouts, stop once the run is cancelled too
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	digest, err := m.Digest()
+	if err != nil {
+		return fmt.Errorf("failed to get %s digest: %w", kind, err)
+	}
+	size := manifestSize(m)
+	for _, img := range referrers {
+		size += metrics.ImageSize(img)
+	}
+	key, err := journalKey(digest, referrers)
+	if err != nil {
+		return err
+	}
+	if j != nil && j.Done(tag, key) {
+		r.metrics.ObserveSkip(job, size)
+		r.emit(Event{Kind: Skipped, Manifest: kind, Destination: d, Tag: tag, Location: location})
+		return nil
+	}
+
+	start := time.Now()
+	switch m := m.(type) {
+	case v1.Image:
+		err = d.WriteImage(ctx, tag, m, referrers)
+	case v1.ImageIndex:
+		err = d.WriteIndex(ctx, tag, m, referrers)
+	default:
+		err = fmt.Errorf("unexpected manifest type %T", m)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", kind, err)
+	}
+	r.metrics.ObservePush(job, size, start)
+	logging.FromContext(ctx).DebugContext(ctx, "wrote "+string(kind), "job", job, "location", location, "referrers", len(referrers), "size", size, "duration", time.Since(start))
+	if j != nil {
+		err = j.Record(tag, key)
+		if err != nil {
+			return err
+		}
+	}
+	r.emit(Event{Kind: Written, Manifest: kind, Destination: d, Tag: tag, Location: location})
+	return nil
+}
+
+// tag tags the manifest written tagged target to d with tag as well, reporting it as written under tag.
+func (r *run) tag(ctx context.Context, job string, d Destination, kind ManifestKind, tag, target string) (err error) {
+	location := d.Location(tag)
+	ctx, span := tracing.Start(ctx, "tag "+string(kind), attribute.String("job", job), attribute.String("destination", d.String()), attribute.String("tag", tag), attribute.String("location", location))
+	defer func() { tracing.End(span, err) }()
+
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	err = d.Tag(ctx, tag, target)
+	if err != nil {
+		return fmt.Errorf("failed to tag %s: %w", kind, err)
+	}
+	logging.FromContext(ctx).DebugContext(ctx, "tagged "+string(kind), "job", job, "location", location, "target", target)
+	r.emit(Event{Kind: Written, Manifest: kind, Destination: d, Tag: tag, Location: location})
+	return nil
+}
+
+// journalKey identifies a manifest written with its referrers, so enabling referrers or signing resumes
+// nothing written without them. Signatures are identified by their key, since signing may not be
+// deterministic.
+func journalKey(digest v1.Hash, referrers []v1.Image) (string, error) {
+	key := digest.String()
+	for _, img := range referrers {
+		mf, err := img.Manifest()
+		if err != nil {
+			return "", fmt.Errorf("failed to get referrer manifest: %w", err)
+		}
+		if keyID, ok := mf.Annotations[signing.KeyIDAnnotation]; ok {
+			key += " " + mf.ArtifactType + "/" + keyID
+			continue
+		}
+		d, err := img.Digest()
+		if err != nil {
+			return "", fmt.Errorf("failed to get referrer digest: %w", err)
+		}
+		key += " " + mf.ArtifactType + "/" + d.String()
+	}
+	return key, nil
+}
+
+func (r *run) emit(ev Event) {
+	switch ev.Kind {
+	case Written:
+		r.result.Written++
+	case Skipped:
+		r.result.Skipped++
+	}
+	if r.cfg.OnEvent != nil {
+		r.cfg.OnEvent(ev)
+	}
+}
+
+// manifestSize returns the size of the image or index m, as recorded in the push metrics.
+func manifestSize(m manifest) int64 {
+	switch m := m.(type) {
+	case v1.Image:
+		return metrics.ImageSize(m)
+	case v1.ImageIndex:
+		return metrics.IndexSize(m)
+	}
+	return 0
+}
+
+// publishError is the failure of destinations to take what a phase of the run publishes, which doesn't stop
+// the next phase.
+type publishError struct {
+	err error
+}
+
+func (e *publishError) Error() string {
+	return e.err.Error()
+}
+
+func (e *publishError) Unwrap() error {
+	return e.err
+}
+
+// publishEach calls publish for each destination. A failed destination doesn't stop the others, the
+// returned error lists every destination that failed.
+func (r *run) publishEach(ctx context.Context, destinations []Destination, publish func(ctx context.Context, d Destination) error) error {
+	logger := logging.FromContext(ctx)
+	var errs []error
+	for _, d := range destinations {
+		ctx, span := tracing.Start(ctx, "publish", attribute.String("destination", d.String()))
+		err := destinationError(publish(ctx, d))
+		tracing.End(span, err)
+		if err != nil {
+			if len(destinations) == 1 {
+				return &publishError{err: err}
+			}
+			logger.ErrorContext(ctx, "Failed to mirror to destination", "destination", d.String(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", d, err))
+			continue
+		}
+		r.published++
+		logger.InfoContext(ctx, "Mirrored to destination", "destination", d.String())
+	}
+	if len(errs) > 0 {
+		return &publishError{err: fmt.Errorf("failed to mirror to %d of %d destinations: %w", len(errs), len(destinations), errors.Join(errs...))}
+	}
+	return nil
+}
+
+// destinationNames returns the location of each destination.
+func destinationNames(destinations []Destination) []string {
+	names := make([]string, len(destinations))
+	for i, d := range destinations {
+		names[i] = d.String()
+	}
+	return names
+}
@@ -0,0 +1,687 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/attest/mirror"
+	"github.com/docker/attest/tuf"
+	"github.com/docker/go-tuf-mirror/internal/journal"
+	"github.com/docker/go-tuf-mirror/internal/lock"
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	"github.com/docker/go-tuf-mirror/internal/test"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"github.com/theupdateframework/go-tuf/v2/metadata/trustedmetadata"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMirror(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	root, err := tuf.GetEmbeddedRoot("dev")
+	require.NoError(t, err)
+	source, err := NewWebSource(server.URL+"/metadata", server.URL+"/targets")
+	require.NoError(t, err)
+
+	var events []Event
+	cfg := Config{
+		Root:                 root.Data,
+		CachePath:            filepath.Join(tempDir, "tuf"),
+		Source:               source,
+		MetadataDestinations: []Destination{NewLayoutDestination(filepath.Join(tempDir, "metadata"))},
+		TargetsDestinations:  []Destination{NewLayoutDestination(filepath.Join(tempDir, "targets"))},
+		OnEvent: func(ev Event) {
+			events = append(events, ev)
+		},
+	}
+	result, err := Mirror(context.Background(), cfg)
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, result.Metadata.Snapshot.Signed.Version)
+	// the metadata image, 5 target images, the snapshot index and its latest tag
+	assert.Equal(t, 8, result.Written)
+	assert.Zero(t, result.Skipped)
+	require.Len(t, events, result.Written)
+	assert.Equal(t, Event{Kind: Written, Manifest: MetadataManifest, Destination: cfg.MetadataDestinations[0], Location: filepath.Join(tempDir, "metadata")}, events[0])
+	for _, ev := range events[1:6] {
+		assert.Equal(t, TargetManifest, ev.Manifest)
+	}
+	assert.Equal(t, SnapshotIndex, events[7].Manifest)
+	assert.Equal(t, filepath.Join(tempDir, "targets", "latest"), events[7].Location)
+
+	// invalid configuration is classified for callers
+	_, err = Mirror(context.Background(), Config{Source: source, CachePath: cfg.CachePath})
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestTracing(t *testing.T) {
+	tempDir := t.TempDir()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(provider)
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	root, err := tuf.GetEmbeddedRoot("dev")
+	require.NoError(t, err)
+	source, err := NewWebSource(server.URL+"/metadata", server.URL+"/targets")
+	require.NoError(t, err)
+	_, err = Mirror(context.Background(), Config{
+		Root:                 root.Data,
+		CachePath:            filepath.Join(tempDir, "tuf"),
+		Source:               source,
+		MetadataDestinations: []Destination{NewLayoutDestination(filepath.Join(tempDir, "metadata"))},
+		TargetsDestinations:  []Destination{NewLayoutDestination(filepath.Join(tempDir, "targets"))},
+		Full:                 true,
+	})
+	require.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	var runID trace.TraceID
+	roles := map[string]bool{}
+	targets := map[string]bool{}
+	writes := map[string]int{}
+	for _, span := range spans {
+		attrs := map[attribute.Key]string{}
+		for _, kv := range span.Attributes {
+			attrs[kv.Key] = kv.Value.Emit()
+		}
+		switch {
+		case span.Name == "mirror":
+			runID = span.SpanContext.TraceID()
+		case span.Name == "tuf download" && attrs["role"] != "":
+			roles[attrs["role"]] = true
+		case span.Name == "tuf download" && attrs["target"] != "":
+			targets[attrs["target"]] = true
+		case strings.HasPrefix(span.Name, "write "):
+			writes[strings.TrimPrefix(span.Name, "write ")]++
+			assert.NotEmpty(t, attrs["tag"]+attrs["location"])
+		}
+	}
+	require.True(t, runID.IsValid())
+	// every span, downloads included, belongs to the run
+	for _, span := range spans {
+		assert.Equal(t, runID, span.SpanContext.TraceID(), span.Name)
+	}
+	for _, role := range []string{"root", "timestamp", "snapshot", "targets", "test-role"} {
+		assert.True(t, roles[role], role)
+	}
+	assert.True(t, targets["test.txt"])
+	assert.True(t, targets["test-role/test.txt"])
+	assert.Equal(t, 5, writes[string(TargetManifest)])
+	assert.Equal(t, 1, writes[string(MetadataManifest)])
+	assert.Equal(t, 1, writes[string(DelegatedMetadataManifest)])
+}
+
+func TestLockLost(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	root, err := tuf.GetEmbeddedRoot("dev")
+	require.NoError(t, err)
+	source, err := NewWebSource(server.URL+"/metadata", server.URL+"/targets")
+	require.NoError(t, err)
+
+	// another run takes the lock over after the first target is written, while the run is stalled
+	destination := NewLayoutDestination(filepath.Join(tempDir, "targets"))
+	lost := make(chan struct{})
+	var written int
+	_, err = Mirror(context.Background(), Config{
+		Root:                root.Data,
+		CachePath:           filepath.Join(tempDir, "tuf"),
+		Source:              source,
+		TargetsDestinations: []Destination{destination},
+		Lock: LockOptions{
+			StaleAfter: 30 * time.Millisecond,
+			OnLost: func(error) {
+				close(lost)
+			},
+		},
+		OnEvent: func(Event) {
+			written++
+			if written > 1 {
+				return
+			}
+			later := func() time.Time { return time.Now().Add(time.Minute) }
+			taken, err := lock.AcquireFile(context.Background(), destination.LockKey(), lock.Options{StaleAfter: time.Minute, Now: later})
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				_ = taken.Release(context.Background())
+			})
+			select {
+			case <-lost:
+			case <-time.After(5 * time.Second):
+				t.Error("lock not lost")
+			}
+		},
+	})
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrLockLost)
+	// nothing is written once the lock is lost
+	assert.Equal(t, 1, written)
+}
+
+func TestCheckRollback(t *testing.T) {
+	testdata := filepath.Join("..", "..", "internal", "test", "testdata", "test-repo", "metadata")
+	testCases := []struct {
+		name     string
+		missing  []string
+		corrupt  string
+		role     string
+		version  int64
+		rollback bool
+		err      bool
+	}{
+		{name: "nothing published", missing: []string{"1.root.json", "2.root.json", "timestamp.json", "7.snapshot.json", "8.targets.json", "2.test-role.json"}, role: metadata.TIMESTAMP, version: 1},
+		{name: "same versions", role: metadata.TIMESTAMP, version: 7},
+		{name: "older snapshot", role: metadata.SNAPSHOT, version: 6, rollback: true},
+		{name: "older delegated role", role: "test-role", version: 1, rollback: true},
+		{name: "older timestamp, snapshot missing", missing: []string{"7.snapshot.json"}, role: metadata.TIMESTAMP, version: 6, rollback: true},
+		{name: "older timestamp, targets missing", missing: []string{"8.targets.json"}, role: metadata.TIMESTAMP, version: 6, rollback: true},
+		{name: "older root, timestamp missing", missing: []string{"timestamp.json"}, role: metadata.ROOT, version: 1, rollback: true},
+		{name: "snapshot missing", missing: []string{"7.snapshot.json"}, role: metadata.TIMESTAMP, version: 7},
+		{name: "corrupt timestamp", corrupt: "timestamp.json", role: metadata.TIMESTAMP, version: 7, err: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			entries, err := os.ReadDir(testdata)
+			require.NoError(t, err)
+			for _, e := range entries {
+				if slices.Contains(tc.missing, e.Name()) {
+					continue
+				}
+				data, err := os.ReadFile(filepath.Join(testdata, e.Name()))
+				require.NoError(t, err)
+				if e.Name() == tc.corrupt {
+					data = data[:len(data)/2]
+				}
+				require.NoError(t, os.WriteFile(filepath.Join(dir, e.Name()), data, 0o644))
+			}
+
+			// the metadata to publish is the test repository, with one role at another version
+			repo, err := mirrortuf.LoadRepository(context.Background(), mirrortuf.NewDirFetcher(testdata))
+			require.NoError(t, err)
+			md := trustedmetadata.TrustedMetadata{Root: repo.Root, Timestamp: repo.Timestamp, Snapshot: repo.Snapshot, Targets: repo.Targets}
+			switch tc.role {
+			case metadata.ROOT:
+				md.Root.Signed.Version = tc.version
+			case metadata.TIMESTAMP:
+				md.Timestamp.Signed.Version = tc.version
+			case metadata.SNAPSHOT:
+				md.Snapshot.Signed.Version = tc.version
+			default:
+				md.Targets[tc.role].Signed.Version = tc.version
+			}
+
+			err = checkRollback(context.Background(), NewFileDestination(dir), md)
+			switch {
+			case tc.rollback:
+				require.ErrorIs(t, err, ErrRollback)
+				assert.Contains(t, err.Error(), tc.role)
+			case tc.err:
+				require.Error(t, err)
+				assert.NotErrorIs(t, err, ErrRollback)
+			default:
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTagCollisions(t *testing.T) {
+	targets := []*mirror.Image{{Tag: "abcd1234.a.txt"}, {Tag: "abcd5678.b.txt"}}
+	indexes := []*mirror.Index{{Index: empty.Index, Tag: "a"}}
+	testCases := []struct {
+		name      string
+		target    string
+		delegated string
+		expected  string
+	}{
+		{name: "default", target: tags.DefaultTargetTemplate, delegated: tags.DefaultDelegatedTemplate},
+		{name: "targets", target: "{{.Hash | trunc 4}}", delegated: tags.DefaultDelegatedTemplate, expected: `target a.txt and target b.txt are both tagged "abcd"`},
+		{name: "target and delegated role", target: "{{.Name | replace \".txt\" \"\"}}", delegated: tags.DefaultDelegatedTemplate, expected: `target a.txt and delegated role a are both tagged "a"`},
+		{name: "target and delegated role template", target: "{{.Name}}", delegated: "{{.Role}}.txt", expected: `target a.txt and delegated role a are both tagged "a.txt"`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scheme, err := tags.NewScheme(tc.target, tc.delegated)
+			require.NoError(t, err)
+			seen := tagSet{}
+			_, err = tagTargets(scheme, targets, seen)
+			if err == nil {
+				_, err = tagDelegatedIndexes(scheme, indexes, seen)
+			}
+			if tc.expected == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrInvalidArgument)
+			assert.ErrorContains(t, err, tc.expected)
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	var location string
+	Register("test", Scheme{
+		Destination: func(l string) (Destination, error) {
+			location = l
+			return NewLayoutDestination(t.TempDir()), nil
+		},
+	})
+	defer func() {
+		schemesMu.Lock()
+		delete(schemes, "test")
+		schemesMu.Unlock()
+	}()
+	assert.Contains(t, DestinationSchemes(), "test")
+	assert.NotContains(t, SourceSchemes(), "test")
+
+	_, err := NewDestination("test://mirror")
+	require.NoError(t, err)
+	assert.Equal(t, "test://mirror", location)
+	// metadata is read back through the destination
+	_, err = NewFetcher("test://mirror")
+	require.NoError(t, err)
+
+	_, err = NewSource("test://metadata", "test://targets")
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, err = NewDestination("unknown://mirror")
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+	_, err = NewDestination("mirror")
+	assert.ErrorIs(t, err, ErrInvalidArgument)
+}
+
+func TestS3(t *testing.T) {
+	tempDir := t.TempDir()
+	store := test.NewS3Server(t)
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	root, err := tuf.GetEmbeddedRoot("dev")
+	require.NoError(t, err)
+	source, err := NewSource(server.URL+"/metadata", server.URL+"/targets")
+	require.NoError(t, err)
+	metadataDestination, err := NewDestination("s3://tuf/metadata")
+	require.NoError(t, err)
+	metadataDestination.(*S3Destination).CacheControl = CacheControl{metadata.ROOT: "max-age=300"}
+	targetsDestination, err := NewDestination("s3://tuf/targets")
+	require.NoError(t, err)
+	_, err = Mirror(context.Background(), Config{
+		Root:                 root.Data,
+		CachePath:            filepath.Join(tempDir, "tuf"),
+		Source:               source,
+		MetadataDestinations: []Destination{metadataDestination},
+		TargetsDestinations:  []Destination{targetsDestination},
+		Full:                 true,
+	})
+	require.NoError(t, err)
+
+	// a plain TUF repository, timestamp.json written last and always revalidated
+	timestamp, ok := store.Object("tuf/metadata/timestamp.json")
+	require.True(t, ok)
+	assert.Equal(t, "application/json", timestamp.ContentType)
+	assert.Equal(t, "no-cache", timestamp.CacheControl)
+	snapshot, ok := store.Object("tuf/metadata/7.snapshot.json")
+	require.True(t, ok)
+	assert.Equal(t, "public, max-age=31536000, immutable", snapshot.CacheControl)
+	// metadata without consistent snapshots is replaced by every run
+	assert.Equal(t, "no-cache", objectOptions(nil, mirrortuf.MetadataMediaType, "snapshot.json").CacheControl)
+	root1, ok := store.Object("tuf/metadata/1.root.json")
+	require.True(t, ok)
+	assert.Equal(t, "max-age=300", root1.CacheControl)
+	// targets first, then delegated metadata, then the top-level metadata
+	var puts []string
+	for _, p := range store.Puts() {
+		if !strings.HasSuffix(p, ".lock") {
+			puts = append(puts, p)
+		}
+	}
+	firstMetadata := slices.IndexFunc(puts, func(p string) bool { return strings.HasPrefix(p, "tuf/metadata/") })
+	require.Greater(t, firstMetadata, 0)
+	for _, p := range puts[:firstMetadata] {
+		assert.True(t, strings.HasPrefix(p, "tuf/targets/"), p)
+	}
+	delegated := slices.Index(puts, "tuf/metadata/2.test-role.json")
+	require.GreaterOrEqual(t, delegated, 0, puts)
+	assert.Less(t, delegated, slices.Index(puts, "tuf/metadata/8.targets.json"))
+	assert.Less(t, slices.Index(puts, "tuf/metadata/8.targets.json"), slices.Index(puts, "tuf/metadata/7.snapshot.json"))
+	assert.Equal(t, "tuf/metadata/timestamp.json", puts[len(puts)-1])
+	targets, err := targetsDestination.Targets(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, targets, 7)
+	target, ok := store.Object("tuf/targets/" + targets[0].Tag)
+	require.True(t, ok)
+	assert.Equal(t, "application/octet-stream", target.ContentType)
+	// locks are released
+	_, ok = store.Object("tuf/metadata/.go-tuf-mirror.lock")
+	assert.False(t, ok)
+
+	// and mirrored on from the bucket
+	source, err = NewSource("s3://tuf/metadata", "s3://tuf/targets")
+	require.NoError(t, err)
+	result, err := Mirror(context.Background(), Config{
+		Root:                root.Data,
+		CachePath:           filepath.Join(tempDir, "tuf-s3"),
+		Source:              source,
+		TargetsDestinations: []Destination{NewLayoutDestination(filepath.Join(tempDir, "targets"))},
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, result.Metadata.Snapshot.Signed.Version)
+	assert.Equal(t, 7, result.Written)
+}
+
+func TestFileDestination(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	root, err := tuf.GetEmbeddedRoot("dev")
+	require.NoError(t, err)
+	source, err := NewSource(server.URL+"/metadata", server.URL+"/targets")
+	require.NoError(t, err)
+	metadataDestination, err := NewDestination("file://" + filepath.Join(tempDir, "repo", "metadata"))
+	require.NoError(t, err)
+	targetsDestination, err := NewDestination("file://" + filepath.Join(tempDir, "repo", "targets"))
+	require.NoError(t, err)
+	_, err = Mirror(context.Background(), Config{
+		Root:                 root.Data,
+		CachePath:            filepath.Join(tempDir, "tuf"),
+		Source:               source,
+		MetadataDestinations: []Destination{metadataDestination},
+		TargetsDestinations:  []Destination{targetsDestination},
+		Full:                 true,
+	})
+	require.NoError(t, err)
+
+	// a plain TUF repository without temporary files
+	assert.FileExists(t, filepath.Join(tempDir, "repo", "metadata", "timestamp.json"))
+	assert.FileExists(t, filepath.Join(tempDir, "repo", "metadata", "2.test-role.json"))
+	temporary, err := filepath.Glob(filepath.Join(tempDir, "repo", "*", ".*"))
+	require.NoError(t, err)
+	assert.Empty(t, temporary)
+	targets, err := targetsDestination.Targets(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, targets, 7)
+
+	// which is a source as is
+	source, err = NewSource("file://"+filepath.Join(tempDir, "repo", "metadata"), "file://"+filepath.Join(tempDir, "repo", "targets"))
+	require.NoError(t, err)
+	result, err := Mirror(context.Background(), Config{
+		Root:                root.Data,
+		CachePath:           filepath.Join(tempDir, "tuf-file"),
+		Source:              source,
+		TargetsDestinations: []Destination{NewLayoutDestination(filepath.Join(tempDir, "targets"))},
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, result.Metadata.Snapshot.Signed.Version)
+}
+
+func TestPublishOrder(t *testing.T) {
+	layer := func(mediaType, name string) v1.Descriptor {
+		return v1.Descriptor{MediaType: types.MediaType(mediaType), Annotations: map[string]string{tuf.TUFFileNameAnnotation: name}}
+	}
+	layers := []v1.Descriptor{
+		layer(mirrortuf.MetadataMediaType, "timestamp.json"),
+		layer(mirrortuf.MetadataMediaType, "7.snapshot.json"),
+		layer(mirrortuf.MetadataMediaType, "8.targets.json"),
+		layer(mirrortuf.MetadataMediaType, "2.test-role.json"),
+		layer(mirrortuf.MetadataMediaType, "2.root.json"),
+		layer(mirrortuf.TargetMediaType, strings.Repeat("0", 64)+".test.txt"),
+	}
+	sort.SliceStable(layers, func(i, j int) bool {
+		return publishOrder(layers[i]) < publishOrder(layers[j])
+	})
+	var names []string
+	for _, l := range layers {
+		names = append(names, l.Annotations[tuf.TUFFileNameAnnotation])
+	}
+	assert.Equal(t, []string{"2.root.json", strings.Repeat("0", 64) + ".test.txt", "2.test-role.json", "8.targets.json", "7.snapshot.json", "timestamp.json"}, names)
+}
+
+func TestArchiveDestination(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	root, err := tuf.GetEmbeddedRoot("dev")
+	require.NoError(t, err)
+	metadataArchive := "oci-archive://" + filepath.Join(tempDir, "metadata.tar")
+	targetsArchive := "docker-archive://" + filepath.Join(tempDir, "targets.tar")
+	// a second run extracts the archives written by the first and packs them again
+	for range 2 {
+		source, err := NewSource(server.URL+"/metadata", server.URL+"/targets")
+		require.NoError(t, err)
+		metadataDestination, err := NewDestination(metadataArchive)
+		require.NoError(t, err)
+		targetsDestination, err := NewDestination(targetsArchive)
+		require.NoError(t, err)
+		_, err = Mirror(context.Background(), Config{
+			Root:                 root.Data,
+			CachePath:            filepath.Join(tempDir, "tuf"),
+			Source:               source,
+			MetadataDestinations: []Destination{metadataDestination},
+			TargetsDestinations:  []Destination{targetsDestination},
+			Full:                 true,
+		})
+		require.NoError(t, err)
+	}
+	temporary, err := filepath.Glob(filepath.Join(tempDir, ".*"))
+	require.NoError(t, err)
+	assert.Empty(t, temporary)
+
+	// targets are listed from the extracted archive
+	d, err := NewDestination(targetsArchive)
+	require.NoError(t, err)
+	targets, err := d.Targets(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, d.(io.Closer).Close())
+	assert.Len(t, targets, 7)
+
+	// the docker archive names its images after the archive file
+	manifest, err := tarball.LoadManifest(func() (io.ReadCloser, error) {
+		return os.Open(filepath.Join(tempDir, "targets.tar"))
+	})
+	require.NoError(t, err)
+	var repoTags []string
+	for _, desc := range manifest {
+		repoTags = append(repoTags, desc.RepoTags...)
+	}
+	assert.Contains(t, repoTags, "targets:"+targets[0].Tag)
+
+	// metadata is read back from the archive
+	f, err := NewFetcher(metadataArchive)
+	require.NoError(t, err)
+	repo, err := mirrortuf.LoadRepository(context.Background(), f)
+	require.NoError(t, err)
+	require.NoError(t, f.(io.Closer).Close())
+	assert.EqualValues(t, 7, repo.Snapshot.Signed.Version)
+	assert.Contains(t, repo.Targets, "test-role")
+
+	// one archive can't be written by two destinations
+	source, err := NewSource(server.URL+"/metadata", server.URL+"/targets")
+	require.NoError(t, err)
+	_, err = Mirror(context.Background(), Config{
+		Root:                 root.Data,
+		CachePath:            filepath.Join(tempDir, "tuf"),
+		Source:               source,
+		MetadataDestinations: []Destination{NewArchiveDestination(OCIArchive, filepath.Join(tempDir, "both.tar"))},
+		TargetsDestinations:  []Destination{NewArchiveDestination(OCIArchive, filepath.Join(tempDir, "both.tar"))},
+	})
+	require.ErrorIs(t, err, ErrInvalidArgument)
+
+	// the archives are a source
+	source, err = NewSource(metadataArchive, targetsArchive)
+	require.NoError(t, err)
+	result, err := Mirror(context.Background(), Config{
+		Root:                root.Data,
+		CachePath:           filepath.Join(tempDir, "tuf-archive"),
+		Source:              source,
+		TargetsDestinations: []Destination{NewFileDestination(filepath.Join(tempDir, "targets"))},
+		Full:                true,
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, result.Metadata.Snapshot.Signed.Version)
+	mirrored, err := mirrortuf.ListDirTargets(filepath.Join(tempDir, "targets"))
+	require.NoError(t, err)
+	assert.Len(t, mirrored, 7)
+}
+
+func TestArchiveDestinationInterrupted(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	root, err := tuf.GetEmbeddedRoot("dev")
+	require.NoError(t, err)
+	cachePath := filepath.Join(tempDir, "tuf")
+	archive := filepath.Join(tempDir, "targets.tar")
+	run := func(ctx context.Context, onEvent func(Event)) error {
+		source, err := NewSource(server.URL+"/metadata", server.URL+"/targets")
+		require.NoError(t, err)
+		_, err = Mirror(ctx, Config{
+			Root:                root.Data,
+			CachePath:           cachePath,
+			Source:              source,
+			TargetsDestinations: []Destination{NewArchiveDestination(OCIArchive, archive)},
+			OnEvent:             onEvent,
+		})
+		return err
+	}
+
+	// the run is interrupted after its first write, before the archive is complete
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err = run(ctx, func(ev Event) {
+		if ev.Kind == Written {
+			cancel()
+		}
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	// archives aren't journaled, the next run writes everything again rather than skipping what wasn't packed
+	assert.NoDirExists(t, filepath.Join(cachePath, journal.Dir))
+
+	var skipped int
+	err = run(context.Background(), func(ev Event) {
+		if ev.Kind == Skipped {
+			skipped++
+		}
+	})
+	require.NoError(t, err)
+	assert.Zero(t, skipped)
+}
+
+func TestSingleLayout(t *testing.T) {
+	tempDir := t.TempDir()
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join("..", "..", "internal", "test", "testdata", "test-repo"))))
+	defer server.Close()
+
+	root, err := tuf.GetEmbeddedRoot("dev")
+	require.NoError(t, err)
+	source, err := NewSource(server.URL+"/metadata", server.URL+"/targets")
+	require.NoError(t, err)
+	metadataDestination := NewLayoutDestination(filepath.Join(tempDir, "metadata"))
+	metadataDestination.Single = true
+	targetsDestination := NewLayoutDestination(filepath.Join(tempDir, "targets"))
+	targetsDestination.Single = true
+	_, err = Mirror(context.Background(), Config{
+		Root:                 root.Data,
+		CachePath:            filepath.Join(tempDir, "tuf"),
+		Source:               source,
+		MetadataDestinations: []Destination{metadataDestination},
+		TargetsDestinations:  []Destination{targetsDestination},
+		Full:                 true,
+	})
+	require.NoError(t, err)
+
+	// one layout, each manifest named by its tag
+	for _, dir := range []string{"metadata", "targets"} {
+		entries, err := os.ReadDir(filepath.Join(tempDir, dir))
+		require.NoError(t, err)
+		for _, e := range entries {
+			if e.IsDir() {
+				assert.Equal(t, "blobs", e.Name())
+			}
+		}
+	}
+	idx, err := layout.ImageIndexFromPath(filepath.Join(tempDir, "metadata"))
+	require.NoError(t, err)
+	mf, err := idx.IndexManifest()
+	require.NoError(t, err)
+	var refNames []string
+	for _, desc := range mf.Manifests {
+		refNames = append(refNames, desc.Annotations[mirrortuf.RefNameAnnotation])
+	}
+	assert.ElementsMatch(t, []string{"test-role", "latest"}, refNames)
+	assert.Equal(t, filepath.Join(tempDir, "targets")+":latest", targetsDestination.Location("latest"))
+
+	// the layouts are read as consolidated ones without asking
+	d, err := NewDestination("oci://" + filepath.Join(tempDir, "targets"))
+	require.NoError(t, err)
+	targets, err := d.Targets(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, targets, 7)
+	source, err = NewSource("oci://"+filepath.Join(tempDir, "metadata"), "oci://"+filepath.Join(tempDir, "targets"))
+	require.NoError(t, err)
+	result, err := Mirror(context.Background(), Config{
+		Root:                root.Data,
+		CachePath:           filepath.Join(tempDir, "tuf-layout"),
+		Source:              source,
+		TargetsDestinations: []Destination{NewFileDestination(filepath.Join(tempDir, "repo"))},
+		Full:                true,
+	})
+	require.NoError(t, err)
+	assert.EqualValues(t, 7, result.Metadata.Snapshot.Signed.Version)
+	mirrored, err := mirrortuf.ListDirTargets(filepath.Join(tempDir, "repo"))
+	require.NoError(t, err)
+	assert.Len(t, mirrored, 7)
+}
@@ -0,0 +1,163 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/attest/mirror"
+	"github.com/docker/attest/oci"
+	"github.com/docker/attest/tuf"
+	"github.com/docker/go-tuf-mirror/internal/signing"
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"github.com/theupdateframework/go-tuf/v2/metadata/trustedmetadata"
+)
+
+// metadataReferrers returns, by tag, the artifacts carrying the TUF metadata of the roles that sign each
+// target image and the images of each delegated target index. Each artifact refers to its target image,
+// so the provenance of a target can be found with a single referrers query.
+func metadataReferrers(md trustedmetadata.TrustedMetadata, targets []*mirror.Image, delegated []*mirror.Index) (map[string][]v1.Image, error) {
+	referrers := make(map[string][]v1.Image)
+	for _, t := range targets {
+		subject, err := descriptor(t.Image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get target manifest descriptor: %w", err)
+		}
+		img, err := metadataReferrer(md, subject, metadata.TARGETS)
+		if err != nil {
+			return nil, err
+		}
+		referrers[t.Tag] = []v1.Image{img}
+	}
+	for _, d := range delegated {
+		mf, err := d.Index.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get delegated target index manifest: %w", err)
+		}
+		role := mf.Annotations[tags.RoleAnnotation]
+		for _, desc := range mf.Manifests {
+			subject := v1.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}
+			img, err := metadataReferrer(md, subject, metadata.TARGETS, role)
+			if err != nil {
+				return nil, err
+			}
+			referrers[d.Tag] = append(referrers[d.Tag], img)
+		}
+	}
+	return referrers, nil
+}
+
+// metadataReferrer returns an artifact referring to subject with a layer for the metadata of each role,
+// named <version>.<role>.json.
+func metadataReferrer(md trustedmetadata.TrustedMetadata, subject v1.Descriptor, roles ...string) (v1.Image, error) {
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ArtifactType(img, mirrortuf.MetadataArtifactType)
+	for _, role := range roles {
+		meta, ok := md.Targets[role]
+		if !ok {
+			return nil, fmt.Errorf("missing trusted metadata for role %s", role)
+		}
+		data, err := meta.ToBytes(false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get role %s metadata: %w", role, err)
+		}
+		img, err = mutate.Append(img, mutate.Addendum{
+			Layer:       static.NewLayer(data, mirrortuf.MetadataMediaType),
+			Annotations: map[string]string{tuf.TUFFileNameAnnotation: fmt.Sprintf("%d.%s.json", meta.Signed.Version, role)},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to append role %s metadata layer: %w", role, err)
+		}
+	}
+	img, ok := mutate.Subject(img, subject).(v1.Image)
+	if !ok {
+		return nil, fmt.Errorf("failed to set referrer subject")
+	}
+	return &oci.EmptyConfigImage{Image: img}, nil
+}
+
+// signatureReferrers adds a signature of each target image, including the images of delegated target
+// indexes, to referrers by tag.
+func signatureReferrers(ctx context.Context, signer dsse.SignerVerifier, referrers map[string][]v1.Image, targets []*mirror.Image, delegated []*mirror.Index) error {
+	for _, t := range targets {
+		sigs, err := signManifest(ctx, signer, t.Image)
+		if err != nil {
+			return err
+		}
+		referrers[t.Tag] = append(referrers[t.Tag], sigs...)
+	}
+	for _, d := range delegated {
+		mf, err := d.Index.IndexManifest()
+		if err != nil {
+			return fmt.Errorf("failed to get delegated target index manifest: %w", err)
+		}
+		for _, desc := range mf.Manifests {
+			sig, err := signing.Sign(ctx, signer, desc)
+			if err != nil {
+				return err
+			}
+			referrers[d.Tag] = append(referrers[d.Tag], sig)
+		}
+	}
+	return nil
+}
+
+// signManifest returns the signature of m, as a single referrer.
+func signManifest(ctx context.Context, signer dsse.SignerVerifier, m manifest) ([]v1.Image, error) {
+	subject, err := descriptor(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest descriptor: %w", err)
+	}
+	sig, err := signing.Sign(ctx, signer, subject)
+	if err != nil {
+		return nil, err
+	}
+	return []v1.Image{sig}, nil
+}
+
+// manifest is an image or index.
+type manifest interface {
+	MediaType() (types.MediaType, error)
+	Digest() (v1.Hash, error)
+	RawManifest() ([]byte, error)
+}
+
+// descriptor returns the descriptor of m, as referenced by a subject.
+func descriptor(m manifest) (v1.Descriptor, error) {
+	mediaType, err := m.MediaType()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	digest, err := m.Digest()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	raw, err := m.RawManifest()
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	return v1.Descriptor{MediaType: mediaType, Digest: digest, Size: int64(len(raw))}, nil
+}
@@ -0,0 +1,135 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+)
+
+// openRefLayout opens the consolidated OCI layout at dir, creating an empty one if there's none.
+func openRefLayout(dir string) (layout.Path, error) {
+	p, err := layout.FromPath(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return "", fmt.Errorf("failed to create OCI layout: %w", err)
+		}
+		p, err = layout.Write(dir, empty.Index)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to open OCI layout: %w", err)
+	}
+	return p, nil
+}
+
+// writeRefImage writes img named tag to the consolidated OCI layout at dir, replacing the manifest named tag,
+// followed by the images referring to it.
+func writeRefImage(dir, tag string, img v1.Image, referrers []v1.Image) error {
+	p, err := openRefLayout(dir)
+	if err != nil {
+		return err
+	}
+	err = p.ReplaceImage(img, refName(tag), layout.WithAnnotations(refAnnotations(tag)))
+	if err != nil {
+		return fmt.Errorf("failed to write image %s: %w", refTag(tag), err)
+	}
+	return writeRefReferrers(p, referrers)
+}
+
+// writeRefIndex writes idx named tag to the consolidated OCI layout at dir, see writeRefImage.
+func writeRefIndex(dir, tag string, idx v1.ImageIndex, referrers []v1.Image) error {
+	p, err := openRefLayout(dir)
+	if err != nil {
+		return err
+	}
+	err = p.ReplaceIndex(idx, refName(tag), layout.WithAnnotations(refAnnotations(tag)))
+	if err != nil {
+		return fmt.Errorf("failed to write index %s: %w", refTag(tag), err)
+	}
+	return writeRefReferrers(p, referrers)
+}
+
+// writeRefReferrers writes referrers untagged, once each.
+func writeRefReferrers(p layout.Path, referrers []v1.Image) error {
+	for _, img := range referrers {
+		digest, err := img.Digest()
+		if err != nil {
+			return fmt.Errorf("failed to get referrer digest: %w", err)
+		}
+		err = p.ReplaceImage(img, match.Digests(digest))
+		if err != nil {
+			return fmt.Errorf("failed to write referrer: %w", err)
+		}
+	}
+	return nil
+}
+
+// tagRef names the manifest named target in the consolidated OCI layout at dir tag as well.
+func tagRef(dir, tag, target string) error {
+	_, desc, err := mirrortuf.RefLayoutManifest(dir, target)
+	if err != nil {
+		return err
+	}
+	p, err := openRefLayout(dir)
+	if err != nil {
+		return err
+	}
+	err = p.RemoveDescriptors(refName(tag))
+	if err != nil {
+		return fmt.Errorf("failed to tag %s: %w", refTag(tag), err)
+	}
+	desc.Annotations = refAnnotations(tag)
+	err = p.AppendDescriptor(desc)
+	if err != nil {
+		return fmt.Errorf("failed to tag %s: %w", refTag(tag), err)
+	}
+	return nil
+}
+
+// refLocation returns where the manifest tagged tag is written to the consolidated layout at path, for reporting.
+func refLocation(path, tag string) string {
+	if tag == "" {
+		return path
+	}
+	return path + ":" + tag
+}
+
+// refTag returns the ref name of the manifest tagged tag, latest for the top-level metadata image.
+func refTag(tag string) string {
+	if tag == "" {
+		return tags.LatestTag
+	}
+	return tag
+}
+
+func refName(tag string) match.Matcher {
+	return match.Annotation(mirrortuf.RefNameAnnotation, refTag(tag))
+}
+
+func refAnnotations(tag string) map[string]string {
+	return map[string]string{mirrortuf.RefNameAnnotation: refTag(tag)}
+}
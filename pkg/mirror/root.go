@@ -0,0 +1,160 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/attest/mirror"
+	"github.com/docker/attest/oci"
+	"github.com/docker/attest/tuf"
+	"github.com/docker/go-tuf-mirror/internal/failure"
+	"github.com/docker/go-tuf-mirror/internal/logging"
+	"github.com/docker/go-tuf-mirror/internal/metrics"
+	"github.com/docker/go-tuf-mirror/internal/registry"
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	"github.com/docker/go-tuf-mirror/internal/tracing"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// mirrorRootChain publishes the root chain artifact, every root version of the source, to the root
+// destinations.
+func (r *run) mirrorRootChain(ctx context.Context) (err error) {
+	destinations := destinationNames(r.cfg.RootDestinations)
+	ctx, span := tracing.Start(ctx, "mirror root chain", attribute.String("source", r.cfg.Source.Metadata()), attribute.StringSlice("destinations", destinations))
+	defer func() { tracing.End(span, err) }()
+	logging.FromContext(ctx).InfoContext(ctx, "Mirroring TUF root chain", "source", r.cfg.Source.Metadata(), "destination", strings.Join(destinations, ","))
+
+	image, err := rootChainImage(r.mirror, sourceMetadataURL(r.cfg.Source))
+	if err != nil {
+		return err
+	}
+	digest, err := image.Digest()
+	if err != nil {
+		return fmt.Errorf("failed to get root chain digest: %w", err)
+	}
+	var signatures []v1.Image
+	if r.cfg.Signer != nil {
+		signatures, err = signManifest(ctx, r.cfg.Signer, image)
+		if err != nil {
+			return fmt.Errorf("failed to sign root chain manifest: %w", err)
+		}
+	}
+	err = r.publishEach(ctx, r.cfg.RootDestinations, func(ctx context.Context, d Destination) error {
+		return r.write(ctx, metrics.MetadataJob, d, RootChainManifest, tags.RootChainTag, image, signatures, nil)
+	})
+	if err != nil {
+		return err
+	}
+	r.result.RootChain = digest
+	return nil
+}
+
+// rootChainImage returns an artifact with a <version>.root.json layer for each root version trusted by m,
+// downloading prior versions from metadataURL. The chain is verified, so clients pinning the artifact can
+// start from its first root.
+func rootChainImage(m *mirror.TUFMirror, metadataURL string) (v1.Image, error) {
+	root := m.TUFClient.GetMetadata().Root
+	files := map[string][]byte{}
+	if root.Signed.Version > 1 {
+		var err error
+		files, err = m.TUFClient.GetPriorRoots(metadataURL)
+		if err != nil {
+			return nil, sourceError(fmt.Errorf("failed to get prior root metadata: %w", err))
+		}
+	}
+	data, err := root.ToBytes(false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root metadata: %w", err)
+	}
+	files[fmt.Sprintf("%d.%s.json", root.Signed.Version, metadata.ROOT)] = data
+
+	names := make([]string, 0, len(files))
+	versions := map[string]int64{}
+	for filename, data := range files {
+		r, err := metadata.Root().FromBytes(data)
+		if err != nil {
+			return nil, failure.Classify(failure.Verification, fmt.Errorf("failed to parse %s: %w", filename, err))
+		}
+		names = append(names, filename)
+		versions[filename] = r.Signed.Version
+	}
+	sort.Slice(names, func(i, j int) bool { return versions[names[i]] < versions[names[j]] })
+	roots := make([][]byte, len(names))
+	for i, filename := range names {
+		roots[i] = files[filename]
+	}
+	_, err = mirrortuf.VerifyRootChain(roots)
+	if err != nil {
+		return nil, failure.Classify(failure.Verification, err)
+	}
+
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ArtifactType(img, mirrortuf.RootChainArtifactType)
+	for i, filename := range names {
+		img, err = mutate.Append(img, mutate.Addendum{
+			Layer:       static.NewLayer(roots[i], mirrortuf.MetadataMediaType),
+			Annotations: map[string]string{tuf.TUFFileNameAnnotation: filename},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to append root metadata layer: %w", err)
+		}
+	}
+	return &oci.EmptyConfigImage{Image: img}, nil
+}
+
+// FetchRoot returns the latest root of the root chain artifact at reference, a registry reference pinned by
+// digest (<repository>@sha256:<digest>, optionally docker://). The artifact must match the digest and each
+// root must be signed by the previous one, so the first root is trusted on the strength of the pin alone.
+func FetchRoot(ctx context.Context, reference string) ([]byte, error) {
+	ref, err := name.NewDigest(strings.TrimPrefix(reference, "docker://"))
+	if err != nil {
+		return nil, invalidArgumentf("root chain reference must be pinned by digest, <repository>@sha256:<digest>: %w", err)
+	}
+	img, err := remote.Image(ref, registry.Options(ctx)...)
+	if err != nil {
+		return nil, failure.Classify(failure.SourceUnreachable, fmt.Errorf("failed to fetch root chain %s: %w", reference, err))
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root chain digest: %w", err)
+	}
+	if digest.String() != ref.DigestStr() {
+		return nil, failure.Classify(failure.Verification, fmt.Errorf("%w: %s has digest %s", mirrortuf.ErrRootChain, reference, digest))
+	}
+	roots, err := mirrortuf.RootChain(img)
+	if err != nil {
+		return nil, failure.Classify(failure.Verification, err)
+	}
+	root, err := mirrortuf.VerifyRootChain(roots)
+	if err != nil {
+		return nil, failure.Classify(failure.Verification, err)
+	}
+	return root, nil
+}
@@ -0,0 +1,157 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/docker/go-tuf-mirror/internal/bucket"
+	"github.com/docker/go-tuf-mirror/internal/lock"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// S3Destination writes a plain TUF repository to an S3 bucket, s3://<bucket>/<prefix>. Metadata files are
+// written to the prefix under their consistent snapshot names and target files under
+// [<dir>/]<sha256>.<name>, so the bucket can be served to TUF clients as is. Files are written in
+// publishing order, see publishOrder.
+type S3Destination struct {
+	// CacheControl overrides the caching of the files written, by role.
+	CacheControl CacheControl
+
+	bucket *bucket.Bucket
+}
+
+// NewS3Destination returns the destination writing to the bucket at location, s3://<bucket>/<prefix>.
+func NewS3Destination(location string) (*S3Destination, error) {
+	b, err := bucket.New(location)
+	if err != nil {
+		return nil, invalidArgumentf("invalid destination bucket: %w", err)
+	}
+	return &S3Destination{bucket: b}, nil
+}
+
+func (d *S3Destination) String() string {
+	return d.bucket.String()
+}
+
+func (d *S3Destination) Location(tag string) string {
+	if tag == "" {
+		return d.bucket.String()
+	}
+	return d.bucket.String() + "/" + tag
+}
+
+func (d *S3Destination) LockKey() string {
+	return d.bucket.String()
+}
+
+func (d *S3Destination) Lock(ctx context.Context, opts LockOptions) (func(context.Context) error, error) {
+	lk, err := lock.AcquireBucket(ctx, d.bucket, opts)
+	if err != nil {
+		return nil, err
+	}
+	return lk.Release, nil
+}
+
+// WriteImage writes the TUF files held by the layers of img.
+func (d *S3Destination) WriteImage(ctx context.Context, _ string, img v1.Image, referrers []v1.Image) error {
+	if len(referrers) > 0 {
+		return invalidArgumentf("%s can't hold signatures or referrers", d)
+	}
+	return writeImageFiles(ctx, "", img, d.put)
+}
+
+// WriteIndex writes the TUF files held by the images of a delegated target index, snapshot indexes are
+// skipped.
+func (d *S3Destination) WriteIndex(ctx context.Context, _ string, idx v1.ImageIndex, referrers []v1.Image) error {
+	if len(referrers) > 0 {
+		return invalidArgumentf("%s can't hold signatures or referrers", d)
+	}
+	return writeIndexFiles(ctx, idx, d.put)
+}
+
+// put writes a TUF file as an object with its content type and caching.
+func (d *S3Destination) put(ctx context.Context, name, mediaType string, data []byte) error {
+	return d.bucket.Put(ctx, name, data, objectOptions(d.CacheControl, mediaType, name))
+}
+
+// objectOptions returns the content type and caching of a TUF file.
+func objectOptions(cacheControl CacheControl, mediaType, name string) bucket.PutOptions {
+	opts := bucket.PutOptions{ContentType: "application/octet-stream", CacheControl: cacheControl.header(mediaType, name)}
+	if mediaType == mirrortuf.MetadataMediaType {
+		opts.ContentType = "application/json"
+	}
+	return opts
+}
+
+func (d *S3Destination) plainRepository() {}
+
+// Tag does nothing, only snapshot indexes are tagged twice and they're skipped.
+func (d *S3Destination) Tag(context.Context, string, string) error {
+	return nil
+}
+
+func (d *S3Destination) Fetcher() (Fetcher, error) {
+	return mirrortuf.NewBucketFetcher(d.bucket), nil
+}
+
+func (d *S3Destination) Targets(ctx context.Context) ([]TargetEntry, error) {
+	return mirrortuf.ListBucketTargets(ctx, d.bucket)
+}
+
+func (d *S3Destination) Signatures() (SignatureStore, error) {
+	return nil, invalidArgumentf("%s can't hold signatures", d)
+}
+
+// S3Source is a plain TUF repository in S3 buckets, e.g. one written by S3Destination.
+type S3Source struct {
+	servedSource
+}
+
+// NewS3Source returns the source reading metadata and target files from the buckets at the given
+// locations, s3://<bucket>/<prefix>.
+func NewS3Source(metadataLocation, targetsLocation string) (*S3Source, error) {
+	md, err := bucket.New(metadataLocation)
+	if err != nil {
+		return nil, invalidArgumentf("invalid source bucket: %w", err)
+	}
+	targets, err := bucket.New(targetsLocation)
+	if err != nil {
+		return nil, invalidArgumentf("invalid source bucket: %w", err)
+	}
+	return &S3Source{servedSource{
+		metadataLocation: md.String(),
+		targetsLocation:  targets.String(),
+		metadataFiles:    bucketFiles(md),
+		targetFiles:      bucketFiles(targets),
+	}}, nil
+}
+
+// bucketFiles returns a getter reading the objects of b.
+func bucketFiles(b *bucket.Bucket) fileGetter {
+	return func(ctx context.Context, name string) ([]byte, error) {
+		data, err := b.Get(ctx, name)
+		if errors.Is(err, bucket.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+		}
+		return data, err
+	}
+}
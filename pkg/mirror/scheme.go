@@ -0,0 +1,227 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	"github.com/docker/go-tuf-mirror/internal/util"
+)
+
+// Scheme implements the locations of a URL scheme, <scheme>://<location>. Any of its functions may be nil
+// if locations of the scheme can't be used that way.
+type Scheme struct {
+	// Source returns the source of the TUF metadata and target files at the given locations.
+	Source func(metadata, targets string) (Source, error)
+	// Destination returns the destination at location.
+	Destination func(location string) (Destination, error)
+	// Metadata returns a fetcher for the TUF metadata at location, defaults to the fetcher of the
+	// destination at location.
+	Metadata func(location string) (Fetcher, error)
+}
+
+var (
+	schemesMu sync.RWMutex
+	schemes   = map[string]Scheme{}
+)
+
+func init() {
+	web := Scheme{
+		Source: func(metadata, targets string) (Source, error) {
+			s, err := NewWebSource(metadata, targets)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+		Metadata: func(location string) (Fetcher, error) {
+			if !util.IsValidUrl(location) {
+				return nil, invalidArgumentf("invalid metadata url: %s", location)
+			}
+			return mirrortuf.NewWebFetcher(location), nil
+		},
+	}
+	Register("https", web)
+	Register("http", web)
+	Register("file", Scheme{
+		Source: func(metadata, targets string) (Source, error) {
+			s, err := NewFileSource(metadata, targets)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+		Destination: func(location string) (Destination, error) {
+			return NewFileDestination(strings.TrimPrefix(location, "file://")), nil
+		},
+	})
+	Register("oci", Scheme{
+		Source: func(metadata, targets string) (Source, error) {
+			s, err := NewLayoutSource(metadata, targets)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+		Destination: func(location string) (Destination, error) {
+			return NewLayoutDestination(strings.TrimPrefix(location, "oci://")), nil
+		},
+	})
+	for name, format := range map[string]ArchiveFormat{"oci-archive": OCIArchive, "docker-archive": DockerArchive, "tar": OCIArchive} {
+		Register(name, archiveScheme(format))
+	}
+	Register("s3", Scheme{
+		Source: func(metadata, targets string) (Source, error) {
+			s, err := NewS3Source(metadata, targets)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+		Destination: func(location string) (Destination, error) {
+			d, err := NewS3Destination(location)
+			if err != nil {
+				return nil, err
+			}
+			return d, nil
+		},
+	})
+	Register("docker", Scheme{
+		Source: func(metadata, targets string) (Source, error) {
+			s, err := NewRegistrySource(metadata, targets)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+		Destination: func(location string) (Destination, error) {
+			d, err := NewRegistryDestination(strings.TrimPrefix(location, "docker://"))
+			if err != nil {
+				return nil, err
+			}
+			return d, nil
+		},
+	})
+}
+
+// archiveScheme implements locations of archives in format, <scheme>://<path>.
+func archiveScheme(format ArchiveFormat) Scheme {
+	return Scheme{
+		Source: func(metadata, targets string) (Source, error) {
+			s, err := NewArchiveSource(metadata, targets)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		},
+		Destination: func(location string) (Destination, error) {
+			path, ok := archivePath(location)
+			if !ok || path == "" {
+				return nil, invalidArgumentf("invalid destination archive: %s", location)
+			}
+			return NewArchiveDestination(format, path), nil
+		},
+		Metadata: newArchiveFetcher,
+	}
+}
+
+// Register makes locations of the scheme name (e.g. "oci" for oci://<path>) available to NewSource,
+// NewDestination and NewFetcher, replacing any scheme already registered with the same name.
+func Register(name string, s Scheme) {
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+	schemes[name] = s
+}
+
+// NewSource returns the source of the TUF metadata and target files at the given locations, implemented
+// by the scheme of the metadata location.
+func NewSource(metadata, targets string) (Source, error) {
+	_, s := lookup(metadata)
+	if s.Source == nil {
+		return nil, invalidArgumentf("source not implemented: %s", metadata)
+	}
+	return s.Source(metadata, targets)
+}
+
+// NewDestination returns the destination at location. Destinations implementing io.Closer, such as
+// archives, should be closed once done with, Mirror closes its destinations.
+func NewDestination(location string) (Destination, error) {
+	_, s := lookup(location)
+	if s.Destination == nil {
+		return nil, invalidArgumentf("destination not implemented: %s", location)
+	}
+	return s.Destination(location)
+}
+
+// NewFetcher returns a fetcher for the TUF metadata at location, a source or a destination. Fetchers
+// implementing io.Closer, such as those of archives, should be closed once done with.
+func NewFetcher(location string) (Fetcher, error) {
+	_, s := lookup(location)
+	switch {
+	case s.Metadata != nil:
+		return s.Metadata(location)
+	case s.Destination != nil:
+		d, err := s.Destination(location)
+		if err != nil {
+			return nil, err
+		}
+		return d.Fetcher()
+	}
+	return nil, invalidArgumentf("metadata location not implemented: %s", location)
+}
+
+// SourceSchemes returns the names of the schemes of sources, sorted.
+func SourceSchemes() []string {
+	return schemeNames(func(s Scheme) bool { return s.Source != nil })
+}
+
+// DestinationSchemes returns the names of the schemes of destinations, sorted.
+func DestinationSchemes() []string {
+	return schemeNames(func(s Scheme) bool { return s.Destination != nil })
+}
+
+// MetadataSchemes returns the names of the schemes of locations NewFetcher reads metadata from, sorted.
+func MetadataSchemes() []string {
+	return schemeNames(func(s Scheme) bool { return s.Metadata != nil || s.Destination != nil })
+}
+
+// lookup returns the name and implementation of the scheme of location, the zero Scheme if it isn't registered.
+func lookup(location string) (string, Scheme) {
+	name, _, ok := strings.Cut(location, "://")
+	if !ok {
+		return "", Scheme{}
+	}
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+	return name, schemes[name]
+}
+
+func schemeNames(supports func(Scheme) bool) []string {
+	schemesMu.RLock()
+	defer schemesMu.RUnlock()
+	var names []string
+	for name, s := range schemes {
+		if supports(s) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,288 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/docker/attest/mirror"
+	"github.com/docker/go-tuf-mirror/internal/registry"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	"github.com/docker/go-tuf-mirror/internal/util"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// Source is a TUF repository to mirror from.
+type Source interface {
+	// Metadata returns the location of the source metadata, identifying the source in freshness records.
+	Metadata() string
+	// Targets returns the location of the source target files, identifying the source in journals.
+	Targets() string
+	// Open updates the trusted metadata from the source, starting from the trusted root and caching metadata
+	// under cachePath.
+	Open(ctx context.Context, root []byte, cachePath string) (*mirror.TUFMirror, error)
+}
+
+// servedSource implements a Source whose files are read by getters of our own and served to the TUF client
+// on a loopback address while the source is open.
+type servedSource struct {
+	metadataLocation string
+	targetsLocation  string
+	metadataFiles    fileGetter
+	targetFiles      fileGetter
+	loopback         *loopback
+}
+
+func (s *servedSource) Metadata() string {
+	return s.metadataLocation
+}
+
+func (s *servedSource) Targets() string {
+	return s.targetsLocation
+}
+
+// Open serves the source on a loopback address and updates the trusted metadata from there.
+func (s *servedSource) Open(ctx context.Context, root []byte, cachePath string) (m *mirror.TUFMirror, err error) {
+	s.loopback, m, err = openLoopback(ctx, root, cachePath, s.metadataLocation, s.targetsLocation, s.metadataFiles, s.targetFiles)
+	return m, err
+}
+
+// Close stops serving the source.
+func (s *servedSource) Close() error {
+	err := s.loopback.Close()
+	s.loopback = nil
+	return err
+}
+
+func (s *servedSource) servedMetadata() string {
+	if s.loopback == nil {
+		return s.metadataLocation
+	}
+	return s.loopback.metadataURL()
+}
+
+// WebSource is a TUF repository served over http(s), each file downloaded with an instrumented client.
+type WebSource struct {
+	servedSource
+}
+
+// NewWebSource returns the source serving metadata from metadataURL and target files from targetsURL.
+func NewWebSource(metadataURL, targetsURL string) (*WebSource, error) {
+	for _, u := range []string{metadataURL, targetsURL} {
+		if !strings.HasPrefix(u, "https://") && !strings.HasPrefix(u, "http://") {
+			return nil, invalidArgumentf("source not implemented: %s", u)
+		}
+		if !util.IsValidUrl(u) {
+			return nil, invalidArgumentf("invalid source url: %s", u)
+		}
+	}
+	return &WebSource{servedSource{
+		metadataLocation: metadataURL,
+		targetsLocation:  targetsURL,
+		metadataFiles:    webFiles(metadataURL),
+		targetFiles:      webFiles(targetsURL),
+	}}, nil
+}
+
+// FileSource is a plain TUF repository in local directories, file://<path>, e.g. a copy of a repository
+// served over http.
+type FileSource struct {
+	servedSource
+}
+
+// NewFileSource returns the source reading metadata and target files from the directories at the given
+// locations, file://<path>.
+func NewFileSource(metadataLocation, targetsLocation string) (*FileSource, error) {
+	dirs := make([]string, 2)
+	for i, location := range []string{metadataLocation, targetsLocation} {
+		dir, ok := strings.CutPrefix(location, "file://")
+		if !ok || dir == "" {
+			return nil, invalidArgumentf("invalid source directory: %s", location)
+		}
+		dirs[i] = dir
+	}
+	return &FileSource{servedSource{
+		metadataLocation: metadataLocation,
+		targetsLocation:  targetsLocation,
+		metadataFiles:    dirFiles(dirs[0]),
+		targetFiles:      dirFiles(dirs[1]),
+	}}, nil
+}
+
+// LayoutSource is metadata and targets mirrored to OCI layouts, oci://<path>, e.g. by LayoutDestination.
+type LayoutSource struct {
+	servedSource
+}
+
+// NewLayoutSource returns the source reading metadata and target files from the OCI layouts at the given
+// locations, oci://<path>.
+func NewLayoutSource(metadataLocation, targetsLocation string) (*LayoutSource, error) {
+	dirs := make([]string, 2)
+	for i, location := range []string{metadataLocation, targetsLocation} {
+		dir, ok := strings.CutPrefix(location, "oci://")
+		if !ok || dir == "" {
+			return nil, invalidArgumentf("invalid source OCI layout: %s", location)
+		}
+		dirs[i] = dir
+	}
+	return &LayoutSource{servedSource{
+		metadataLocation: metadataLocation,
+		targetsLocation:  targetsLocation,
+		metadataFiles:    imageMetadataFiles(newLayoutFetcher(dirs[0])),
+		targetFiles:      layoutTargetFiles(dirs[1]),
+	}}, nil
+}
+
+// RegistrySource is metadata and targets mirrored to registry repositories, docker://<reference>, e.g. by
+// RegistryDestination.
+type RegistrySource struct {
+	servedSource
+}
+
+// NewRegistrySource returns the source reading metadata from the image at metadataLocation,
+// docker://<reference>, and target files from the repository at targetsLocation, docker://<repository>.
+func NewRegistrySource(metadataLocation, targetsLocation string) (*RegistrySource, error) {
+	imageName, ok := strings.CutPrefix(metadataLocation, "docker://")
+	if !ok {
+		return nil, invalidArgumentf("source not implemented: %s", metadataLocation)
+	}
+	f, err := mirrortuf.NewRegistryFetcher(imageName)
+	if err != nil {
+		return nil, invalidArgumentf("failed to parse source registry reference: %w", err)
+	}
+	repository, ok := strings.CutPrefix(targetsLocation, "docker://")
+	if !ok {
+		return nil, invalidArgumentf("source not implemented: %s", targetsLocation)
+	}
+	repo, err := name.NewRepository(repository)
+	if err != nil {
+		return nil, invalidArgumentf("failed to parse source registry repository: %w", err)
+	}
+	return &RegistrySource{servedSource{
+		metadataLocation: metadataLocation,
+		targetsLocation:  targetsLocation,
+		metadataFiles:    imageMetadataFiles(f),
+		targetFiles:      registryTargetFiles(repo),
+	}}, nil
+}
+
+// dirFiles returns a getter reading files from under dir.
+func dirFiles(dir string) fileGetter {
+	files := os.DirFS(dir)
+	return func(_ context.Context, name string) ([]byte, error) {
+		return fs.ReadFile(files, name)
+	}
+}
+
+// imageMetadataFiles returns a getter reading metadata files from the images fetched by f.
+func imageMetadataFiles(f *mirrortuf.ImageFetcher) fileGetter {
+	// the fetcher caches images and isn't safe for concurrent use
+	var mu sync.Mutex
+	return func(ctx context.Context, name string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		data, err := f.Fetch(ctx, metadataRole(name), name)
+		if errors.Is(err, mirrortuf.ErrNotFound) {
+			return nil, fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+		}
+		return data, err
+	}
+}
+
+// targetDigest returns the sha256 of a target file, [<dir>/]<sha256>.<name>, which is the digest of the
+// layer holding it.
+func targetDigest(name string) (string, error) {
+	hex, _, _ := strings.Cut(path.Base(name), ".")
+	if len(hex) != sha256.Size*2 || strings.Trim(hex, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return hex, nil
+}
+
+// layoutTargetFiles returns a getter reading target files from the blobs of the OCI layouts under dir, or
+// of the consolidated layout at dir.
+func layoutTargetFiles(dir string) fileGetter {
+	return func(_ context.Context, name string) ([]byte, error) {
+		hex, err := targetDigest(name)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "blobs", "sha256", hex))
+		if !errors.Is(err, fs.ErrNotExist) {
+			return data, err
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read targets directory: %w", err)
+		}
+		for _, e := range entries {
+			data, err := os.ReadFile(filepath.Join(dir, e.Name(), "blobs", "sha256", hex))
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+				continue
+			}
+			return data, err
+		}
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+}
+
+// registryTargetFiles returns a getter reading target files from the blobs of repo.
+func registryTargetFiles(repo name.Repository) fileGetter {
+	return func(ctx context.Context, name string) ([]byte, error) {
+		hex, err := targetDigest(name)
+		if err != nil {
+			return nil, err
+		}
+		data, err := readLayer(ctx, repo.Digest("sha256:"+hex))
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read layer %s: %w", name, err)
+		}
+		return data, nil
+	}
+}
+
+// readLayer returns the blob at ref.
+func readLayer(ctx context.Context, ref name.Digest) ([]byte, error) {
+	layer, err := remote.Layer(ref, registry.Options(ctx)...)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
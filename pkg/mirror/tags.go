@@ -0,0 +1,115 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/attest/mirror"
+	"github.com/docker/attest/oci"
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// tagSet maps the tags of manifests written to the same destinations to what they tag.
+type tagSet map[string]string
+
+// add records that tag tags what, failing if another manifest is tagged the same, since they'd overwrite
+// each other and their referrers.
+func (s tagSet) add(tag, what string) error {
+	if other, ok := s[tag]; ok {
+		return invalidArgumentf("%s and %s are both tagged %q, tag templates must tag each target and delegated role differently", other, what, tag)
+	}
+	s[tag] = what
+	return nil
+}
+
+// tagTargets returns the target images tagged by scheme, recording their tags in seen.
+func tagTargets(scheme *tags.Scheme, targets []*mirror.Image, seen tagSet) ([]*mirror.Image, error) {
+	tagged := make([]*mirror.Image, len(targets))
+	for i, t := range targets {
+		// target images are tagged with their TUF filename
+		tag, err := scheme.TargetFromFilename(t.Tag)
+		if err != nil {
+			return nil, invalidArgumentf("%w", err)
+		}
+		_, name, _ := strings.Cut(t.Tag, ".")
+		err = seen.add(tag, "target "+name)
+		if err != nil {
+			return nil, err
+		}
+		tagged[i] = &mirror.Image{Image: t.Image, Tag: tag}
+	}
+	return tagged, nil
+}
+
+// tagDelegatedImages returns the delegated metadata images tagged by scheme, each annotated with its role,
+// recording their tags in seen.
+func tagDelegatedImages(scheme *tags.Scheme, images []*mirror.Image, seen tagSet) ([]*mirror.Image, error) {
+	tagged := make([]*mirror.Image, len(images))
+	for i, d := range images {
+		// delegated images are tagged with their role
+		tag, err := scheme.Delegated(d.Tag)
+		if err != nil {
+			return nil, invalidArgumentf("%w", err)
+		}
+		err = seen.add(tag, "delegated role "+d.Tag)
+		if err != nil {
+			return nil, err
+		}
+		img, err := annotateImage(d.Image, map[string]string{tags.RoleAnnotation: d.Tag})
+		if err != nil {
+			return nil, err
+		}
+		tagged[i] = &mirror.Image{Image: img, Tag: tag}
+	}
+	return tagged, nil
+}
+
+// tagDelegatedIndexes returns the delegated target indexes tagged by scheme, each annotated with its role,
+// recording their tags in seen.
+func tagDelegatedIndexes(scheme *tags.Scheme, indexes []*mirror.Index, seen tagSet) ([]*mirror.Index, error) {
+	tagged := make([]*mirror.Index, len(indexes))
+	for i, d := range indexes {
+		// delegated indexes are tagged with their role
+		tag, err := scheme.Delegated(d.Tag)
+		if err != nil {
+			return nil, invalidArgumentf("%w", err)
+		}
+		err = seen.add(tag, "delegated role "+d.Tag)
+		if err != nil {
+			return nil, err
+		}
+		idx, ok := mutate.Annotations(d.Index, map[string]string{tags.RoleAnnotation: d.Tag}).(v1.ImageIndex)
+		if !ok {
+			return nil, fmt.Errorf("failed to annotate delegated target index %s", d.Tag)
+		}
+		tagged[i] = &mirror.Index{Index: idx, Tag: tag}
+	}
+	return tagged, nil
+}
+
+// annotateImage adds annotations to the manifest of img, keeping its empty config.
+func annotateImage(img *oci.EmptyConfigImage, annotations map[string]string) (*oci.EmptyConfigImage, error) {
+	annotated, ok := mutate.Annotations(img.Image, annotations).(v1.Image)
+	if !ok {
+		return nil, fmt.Errorf("failed to annotate image")
+	}
+	return &oci.EmptyConfigImage{Image: annotated}, nil
+}
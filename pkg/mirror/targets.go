@@ -0,0 +1,212 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/attest/mirror"
+	"github.com/docker/attest/oci"
+	"github.com/docker/attest/tuf"
+	"github.com/docker/go-tuf-mirror/internal/journal"
+	"github.com/docker/go-tuf-mirror/internal/logging"
+	"github.com/docker/go-tuf-mirror/internal/metrics"
+	"github.com/docker/go-tuf-mirror/internal/tags"
+	"github.com/docker/go-tuf-mirror/internal/tracing"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// mirrorTargets mirrors the target images, delegated target indexes and snapshot indexes to the targets destinations.
+func (r *run) mirrorTargets(ctx context.Context) (err error) {
+	destinations := destinationNames(r.cfg.TargetsDestinations)
+	ctx, span := tracing.Start(ctx, "mirror targets", attribute.String("source", r.cfg.Source.Targets()), attribute.StringSlice("destinations", destinations))
+	defer func() { tracing.End(span, err) }()
+	logging.FromContext(ctx).InfoContext(ctx, "Mirroring TUF targets", "source", r.cfg.Source.Targets(), "destination", strings.Join(destinations, ","))
+
+	// create target manifests
+	_, buildSpan := tracing.Start(ctx, "build target manifests")
+	targets, err := r.mirror.GetTUFTargetMirrors()
+	tracing.End(buildSpan, err)
+	if err != nil {
+		return sourceError(fmt.Errorf("failed to create target mirrors: %w", err))
+	}
+	// target images and delegated target indexes are written side by side
+	seen := tagSet{}
+	targets, err = tagTargets(r.scheme, targets, seen)
+	if err != nil {
+		return fmt.Errorf("failed to tag target mirrors: %w", err)
+	}
+
+	// create delegated target manifests
+	var delegated []*mirror.Index
+	if r.cfg.Full {
+		_, buildSpan := tracing.Start(ctx, "build delegated target manifests")
+		delegated, err = r.mirror.GetDelegatedTargetMirrors()
+		tracing.End(buildSpan, err)
+		if err != nil {
+			return sourceError(fmt.Errorf("failed to create delegated target index manifests: %w", err))
+		}
+		delegated, err = tagDelegatedIndexes(r.scheme, delegated, seen)
+		if err != nil {
+			return fmt.Errorf("failed to tag delegated target index manifests: %w", err)
+		}
+	}
+
+	// reference the whole target set from a single index, tagged for the snapshot version and latest
+	md := r.result.Metadata
+	idx, err := snapshotIndex(md.Snapshot.Signed.Version, targets, delegated)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot index: %w", err)
+	}
+	snapshot := &mirror.Index{Index: idx, Tag: r.scheme.Snapshot(md.Snapshot.Signed.Version)}
+
+	referrers := map[string][]v1.Image{}
+	if r.cfg.Referrers {
+		referrers, err = metadataReferrers(md, targets, delegated)
+		if err != nil {
+			return fmt.Errorf("failed to create metadata referrers: %w", err)
+		}
+	}
+	if r.cfg.Signer != nil {
+		err = signatureReferrers(ctx, r.cfg.Signer, referrers, targets, delegated)
+		if err != nil {
+			return fmt.Errorf("failed to sign target manifests: %w", err)
+		}
+		// snapshot tags are reserved, so the snapshot index signature can't collide with a target's referrers
+		referrers[snapshot.Tag], err = signManifest(ctx, r.cfg.Signer, snapshot.Index)
+		if err != nil {
+			return fmt.Errorf("failed to sign snapshot index: %w", err)
+		}
+	}
+
+	err = r.publishEach(ctx, r.cfg.TargetsDestinations, func(ctx context.Context, d Destination) error {
+		return r.publishTargets(ctx, d, targets, delegated, snapshot, referrers)
+	})
+	if err != nil {
+		return err
+	}
+	r.metrics.RecordSuccess(metrics.TargetsJob)
+	return nil
+}
+
+// publishTargets writes the target manifests (with any referrers, by tag), delegated target indexes and then
+// the snapshot index (with its signature) to d, tagging it latest, resuming an interrupted run of the same targets metadata version,
+// unless d is an archive.
+func (r *run) publishTargets(ctx context.Context, d Destination, targets []*mirror.Image, delegated []*mirror.Index, snapshot *mirror.Index, referrers map[string][]v1.Image) (err error) {
+	// archives are only packed when closed, so an interrupted run leaves nothing in them to resume and
+	// they're written afresh without a journal
+	var j *journal.Journal
+	if _, ok := d.(*ArchiveDestination); !ok {
+		targetsVersion := r.result.Metadata.Targets[metadata.TARGETS].Signed.Version
+		j, err = journal.Open(r.cfg.CachePath, r.cfg.Source.Targets(), d.String(), targetsVersion)
+		if err != nil {
+			return fmt.Errorf("failed to open journal: %w", err)
+		}
+		// an interrupted run keeps the journal for the next one
+		defer func() {
+			err = errors.Join(err, j.Close())
+		}()
+		if n := j.Len(); n > 0 {
+			logging.FromContext(ctx).InfoContext(ctx, "Resuming interrupted run", "destination", d.String(), "completed", n, "targets_version", targetsVersion)
+		}
+	}
+
+	for _, t := range targets {
+		err = r.write(ctx, metrics.TargetsJob, d, TargetManifest, t.Tag, t.Image, referrers[t.Tag], j)
+		if err != nil {
+			return err
+		}
+	}
+	for _, idx := range delegated {
+		err = r.write(ctx, metrics.TargetsJob, d, DelegatedTargetIndex, idx.Tag, idx.Index, referrers[idx.Tag], j)
+		if err != nil {
+			return err
+		}
+	}
+	// the snapshot index is small and always written, so latest moves even when every target was written before
+	err = r.write(ctx, metrics.TargetsJob, d, SnapshotIndex, snapshot.Tag, snapshot.Index, referrers[snapshot.Tag], nil)
+	if err != nil {
+		return err
+	}
+	err = r.tag(ctx, metrics.TargetsJob, d, SnapshotIndex, r.scheme.Latest(), snapshot.Tag)
+	if err != nil {
+		return err
+	}
+
+	if j == nil {
+		return nil
+	}
+	// the run is complete, the next one starts afresh
+	return j.Remove()
+}
+
+// snapshotIndex returns an index referencing each target image and delegated target index, annotated with
+// their target path, role and tag.
+func snapshotIndex(version int64, targets []*mirror.Image, delegated []*mirror.Index) (v1.ImageIndex, error) {
+	idx, ok := mutate.Annotations(empty.Index, map[string]string{
+		mirrortuf.SnapshotVersionAnnotation: strconv.FormatInt(version, 10),
+	}).(v1.ImageIndex)
+	if !ok {
+		return nil, fmt.Errorf("failed to annotate snapshot index")
+	}
+	for _, t := range targets {
+		mf, err := t.Image.Manifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get target manifest: %w", err)
+		}
+		if len(mf.Layers) != 1 {
+			return nil, fmt.Errorf("unexpected number of layers in target manifest %s: %d", t.Tag, len(mf.Layers))
+		}
+		// target files are named <sha256>.<path>
+		_, path, _ := strings.Cut(mf.Layers[0].Annotations[tuf.TUFFileNameAnnotation], ".")
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add: t.Image,
+			Descriptor: v1.Descriptor{
+				Annotations: map[string]string{
+					mirrortuf.TargetAnnotation: path,
+					tags.RoleAnnotation:        metadata.TARGETS,
+					oci.OCIReferenceTarget:     t.Tag,
+				},
+			},
+		})
+	}
+	for _, d := range delegated {
+		mf, err := d.Index.IndexManifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get delegated target index manifest: %w", err)
+		}
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add: d.Index,
+			Descriptor: v1.Descriptor{
+				Annotations: map[string]string{
+					tags.RoleAnnotation:    mf.Annotations[tags.RoleAnnotation],
+					oci.OCIReferenceTarget: d.Tag,
+				},
+			},
+		})
+	}
+	return idx, nil
+}
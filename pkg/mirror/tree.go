@@ -0,0 +1,167 @@
+/*
+   Copyright Docker go-tuf-mirror authors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/attest/tuf"
+	mirrortuf "github.com/docker/go-tuf-mirror/internal/tuf"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+const (
+	// versioned metadata and hash-prefixed targets never change once written
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// other files (timestamp.json, and any metadata without consistent snapshots) are replaced by every run,
+	// clients must always revalidate them
+	mutableCacheControl = "no-cache"
+)
+
+// TargetFilesCacheKey is the CacheControl key of target files.
+const TargetFilesCacheKey = "target-files"
+
+// CacheControl is the Cache-Control of the files of a plain TUF repository served over http, keyed by role
+// for metadata files (root, targets, snapshot, timestamp or a delegated role) and by TargetFilesCacheKey for
+// target files. Files without an entry are cached as immutable if their name is versioned or hash-prefixed,
+// and revalidated otherwise.
+type CacheControl map[string]string
+
+// header returns the Cache-Control of the TUF file name.
+func (c CacheControl) header(mediaType, name string) string {
+	key := TargetFilesCacheKey
+	if mediaType == mirrortuf.MetadataMediaType {
+		key = metadataRole(path.Base(name))
+	}
+	if h, ok := c[key]; ok {
+		return h
+	}
+	if isConsistentName(name) {
+		return immutableCacheControl
+	}
+	return mutableCacheControl
+}
+
+// isConsistentName reports whether a TUF file name is unique to its content, <version>.<role>.json for
+// metadata and [<dir>/]<sha256>.<name> for targets.
+func isConsistentName(name string) bool {
+	prefix, _, ok := strings.Cut(path.Base(name), ".")
+	if !ok || prefix == "" {
+		return false
+	}
+	if strings.Trim(prefix, "0123456789") == "" {
+		return true
+	}
+	_, err := targetDigest(name)
+	return err == nil
+}
+
+// putFunc writes a file of a plain TUF repository.
+type putFunc func(ctx context.Context, name, mediaType string, data []byte) error
+
+// writeIndexFiles writes the TUF files held by the images of a delegated target index with put, snapshot
+// indexes have no equivalent in a plain TUF repository and are skipped.
+func writeIndexFiles(ctx context.Context, idx v1.ImageIndex, put putFunc) error {
+	mf, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	if _, ok := mf.Annotations[mirrortuf.SnapshotVersionAnnotation]; ok {
+		return nil
+	}
+	for _, desc := range mf.Manifests {
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("failed to get image %s: %w", desc.Digest, err)
+		}
+		// delegated targets are annotated with their directory, <dir>/<sha256>.<name>
+		dir := ""
+		if filename, ok := desc.Annotations[tuf.TUFFileNameAnnotation]; ok {
+			dir = path.Dir(filename)
+		}
+		err = writeImageFiles(ctx, dir, img, put)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeImageFiles writes each annotated layer of img as a file under dir with put, in publishing order.
+func writeImageFiles(ctx context.Context, dir string, img v1.Image, put putFunc) error {
+	mf, err := img.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to get manifest: %w", err)
+	}
+	var layers []v1.Descriptor
+	for _, layer := range mf.Layers {
+		if layer.Annotations[tuf.TUFFileNameAnnotation] != "" {
+			layers = append(layers, layer)
+		}
+	}
+	sort.SliceStable(layers, func(i, j int) bool {
+		return publishOrder(layers[i]) < publishOrder(layers[j])
+	})
+	for _, desc := range layers {
+		layer, err := img.LayerByDigest(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("failed to get layer %s: %w", desc.Digest, err)
+		}
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return fmt.Errorf("failed to read layer %s: %w", desc.Digest, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read layer %s: %w", desc.Digest, err)
+		}
+		err = put(ctx, path.Join(dir, desc.Annotations[tuf.TUFFileNameAnnotation]), string(desc.MediaType), data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishOrder ranks a TUF file by when it's published. Clients of a plain repository read each file as soon
+// as it's written, so nothing may be published before the files it refers to: target files (and the root
+// chain) first, then delegated roles, targets, snapshot and timestamp last. Metadata images are written
+// after the target images, and delegated metadata images before the top-level one, so ranking the layers
+// of each image is enough.
+func publishOrder(layer v1.Descriptor) int {
+	if string(layer.MediaType) != mirrortuf.MetadataMediaType {
+		return 0
+	}
+	switch metadataRole(layer.Annotations[tuf.TUFFileNameAnnotation]) {
+	case metadata.ROOT:
+		return 0
+	case metadata.TARGETS:
+		return 2
+	case metadata.SNAPSHOT:
+		return 3
+	case metadata.TIMESTAMP:
+		return 4
+	}
+	return 1
+}